@@ -0,0 +1,86 @@
+package chartify
+
+import (
+	"fmt"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Chart API versions, as in the apiVersion field of Chart.yaml.
+// See https://helm.sh/docs/topics/charts/#the-apiversion-field
+const (
+	// ChartAPIVersionV1 is the chart format of Helm 2, which declares dependencies in requirements.yaml.
+	ChartAPIVersionV1 = "v1"
+
+	// ChartAPIVersionV2 is the chart format introduced by Helm 3.
+	// It is the apiVersion of the charts chartify generates from K8s manifests and kustomizations by default.
+	ChartAPIVersionV2 = "v2"
+
+	// ChartAPIVersionV3 is the chart format introduced by Helm 4.
+	ChartAPIVersionV3 = "v3"
+)
+
+// chartAPIVersion returns the apiVersion of the chart, which is v1 when Chart.yaml lacks it as Helm assumes.
+func (m *chartMetadata) chartAPIVersion() string {
+	if v, ok := m.Data["apiVersion"].(string); ok && v != "" {
+		return v
+	}
+	return ChartAPIVersionV1
+}
+
+// validateChartAPIVersion returns an error when the Helm binary used by the runner is unable to load
+// charts of the apiVersion.
+func (r *Runner) validateChartAPIVersion(apiVersion string) error {
+	a := r.HelmAdapter()
+
+	if supported := a.ChartAPIVersions(); !slices.Contains(supported, apiVersion) {
+		return fmt.Errorf("chart apiVersion %q is not supported by Helm %d: it must be one of %q", apiVersion, a.MajorVersion(), supported)
+	}
+
+	return nil
+}
+
+// detectChartAPIVersion returns the apiVersion of the chart at chartYamlPath,
+// failing when the Helm binary used by the runner is unable to render the chart.
+func (r *Runner) detectChartAPIVersion(chartYamlPath string) (string, error) {
+	chartMeta, err := r.readChartMetadata(chartYamlPath)
+	if err != nil {
+		return "", err
+	}
+
+	apiVersion := chartMeta.chartAPIVersion()
+
+	if err := r.validateChartAPIVersion(apiVersion); err != nil {
+		return "", fmt.Errorf("%s: %w", chartYamlPath, err)
+	}
+
+	return apiVersion, nil
+}
+
+// setChartAPIVersion rewrites the apiVersion of the chart at chartYamlPath.
+// It does nothing when the chart already has the apiVersion.
+func (r *Runner) setChartAPIVersion(chartYamlPath, apiVersion string) error {
+	chartMeta, err := r.readChartMetadata(chartYamlPath)
+	if err != nil {
+		return err
+	}
+
+	if chartMeta.chartAPIVersion() == apiVersion {
+		return nil
+	}
+
+	r.Logf("Changing apiVersion of %s from %s to %s", chartYamlPath, chartMeta.chartAPIVersion(), apiVersion)
+
+	if chartMeta.Data == nil {
+		chartMeta.Data = map[string]interface{}{}
+	}
+	chartMeta.Data["apiVersion"] = apiVersion
+
+	chartYamlContent, err := yaml.Marshal(chartMeta)
+	if err != nil {
+		return fmt.Errorf("marshaling-back Chart.yaml: %w", err)
+	}
+
+	return r.WriteFile(chartYamlPath, chartYamlContent, 0644)
+}
@@ -0,0 +1,143 @@
+package chartify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner_detectChartAPIVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	writeTestFiles(t, dir, map[string]string{
+		"v1/Chart.yaml":      "name: v1\nversion: 0.1.0\n",
+		"v2/Chart.yaml":      "apiVersion: v2\nname: v2\nversion: 0.1.0\n",
+		"v3/Chart.yaml":      "apiVersion: v3\nname: v3\nversion: 0.1.0\n",
+		"unknown/Chart.yaml": "apiVersion: v9\nname: unknown\nversion: 0.1.0\n",
+	})
+
+	for _, tc := range []struct {
+		adapter HelmVersionAdapter
+		chart   string
+		want    string
+		err     string
+	}{
+		{adapter: helm3Adapter{}, chart: "v1", want: ChartAPIVersionV1},
+		{adapter: helm3Adapter{}, chart: "v2", want: ChartAPIVersionV2},
+		{adapter: helm3Adapter{}, chart: "v3", err: `chart apiVersion "v3" is not supported by Helm 3`},
+		{adapter: helm4Adapter{}, chart: "v1", want: ChartAPIVersionV1},
+		{adapter: helm4Adapter{}, chart: "v2", want: ChartAPIVersionV2},
+		{adapter: helm4Adapter{}, chart: "v3", want: ChartAPIVersionV3},
+		{adapter: helm4Adapter{}, chart: "unknown", err: `chart apiVersion "v9" is not supported by Helm 4`},
+	} {
+		r := New(WithHelmVersionAdapter(tc.adapter), WithLogf(t.Logf))
+
+		got, err := r.detectChartAPIVersion(filepath.Join(dir, tc.chart, "Chart.yaml"))
+		if tc.err != "" {
+			require.ErrorContains(t, err, tc.err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.want, got)
+	}
+}
+
+func TestRunner_setChartAPIVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	writeTestFiles(t, dir, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: myapp\nversion: 0.1.0\nappVersion: 1.0.0\n",
+	})
+
+	r := New(WithLogf(t.Logf))
+
+	chartYamlPath := filepath.Join(dir, "Chart.yaml")
+	require.NoError(t, r.setChartAPIVersion(chartYamlPath, ChartAPIVersionV3))
+
+	chartMeta, err := r.readChartMetadata(chartYamlPath)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"apiVersion": "v3",
+		"name":       "myapp",
+		"version":    "0.1.0",
+		"appVersion": "1.0.0",
+	}, chartMeta.Data)
+}
+
+func TestChartify_ChartAPIVersion(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	chartify := func(t *testing.T, adapter HelmVersionAdapter, input string, opts *ChartifyOpts) (string, error) {
+		t.Helper()
+
+		r := New(HelmBin(helm), WithHelmVersionAdapter(adapter), WithLogf(t.Logf))
+
+		tmpDir, err := r.Chartify("myapp", input, WithChartifyOpts(opts))
+		t.Cleanup(func() {
+			if tmpDir != "" {
+				_ = os.RemoveAll(tmpDir)
+			}
+		})
+
+		return tmpDir, err
+	}
+
+	apiVersionOf := func(t *testing.T, chartDir string) string {
+		t.Helper()
+
+		chartMeta, err := New().readChartMetadata(filepath.Join(chartDir, "Chart.yaml"))
+		require.NoError(t, err)
+
+		return chartMeta.chartAPIVersion()
+	}
+
+	for _, adapter := range []HelmVersionAdapter{helm3Adapter{}, helm4Adapter{}} {
+		t.Run(fmt.Sprintf("preserve/helm%d", adapter.MajorVersion()), func(t *testing.T) {
+			chartDir := filepath.Join(t.TempDir(), "legacy")
+			writeTestFiles(t, chartDir, map[string]string{
+				"Chart.yaml":          "apiVersion: v1\nname: legacy\nversion: 0.1.0\n",
+				"templates/cm.yaml":   "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n",
+				"templates/NOTES.txt": "installed\n",
+			})
+
+			tmpDir, err := chartify(t, adapter, chartDir, &ChartifyOpts{OverrideNamespace: "ns"})
+			require.NoError(t, err)
+			require.Equal(t, ChartAPIVersionV1, apiVersionOf(t, tmpDir))
+
+			tmpDir, err = chartify(t, adapter, "testdata/kube_manifest", &ChartifyOpts{})
+			require.NoError(t, err)
+			require.Equal(t, ChartAPIVersionV2, apiVersionOf(t, tmpDir))
+		})
+	}
+
+	t.Run("emit v3", func(t *testing.T) {
+		// The chart is rendered as v2, so even a Helm 4 binary without the v3 chart support is able to generate v3 charts
+		tmpDir, err := chartify(t, helm4Adapter{}, "testdata/kube_manifest", &ChartifyOpts{ChartAPIVersion: ChartAPIVersionV3})
+		require.NoError(t, err)
+		require.Equal(t, ChartAPIVersionV3, apiVersionOf(t, tmpDir))
+
+		tmpDir, err = chartify(t, helm4Adapter{}, "testdata/charts/db", &ChartifyOpts{ChartAPIVersion: ChartAPIVersionV3})
+		require.NoError(t, err)
+		require.Equal(t, ChartAPIVersionV3, apiVersionOf(t, tmpDir))
+	})
+
+	t.Run("v3 unsupported by helm3", func(t *testing.T) {
+		_, err := chartify(t, helm3Adapter{}, "testdata/kube_manifest", &ChartifyOpts{ChartAPIVersion: ChartAPIVersionV3})
+		require.ErrorContains(t, err, `chart apiVersion "v3" is not supported by Helm 3`)
+
+		chartDir := filepath.Join(t.TempDir(), "next")
+		writeTestFiles(t, chartDir, map[string]string{
+			"Chart.yaml": "apiVersion: v3\nname: next\nversion: 0.1.0\n",
+		})
+
+		_, err = chartify(t, helm3Adapter{}, chartDir, &ChartifyOpts{})
+		require.ErrorContains(t, err, `chart apiVersion "v3" is not supported by Helm 3`)
+	})
+}
@@ -11,8 +11,6 @@ import (
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/otiai10/copy"
-	registryv3 "helm.sh/helm/v3/pkg/registry"
-	registryv4 "helm.sh/helm/v4/pkg/registry"
 )
 
 var (
@@ -32,6 +30,8 @@ type ChartifyOpts struct {
 	//ReleaseName string
 
 	// ValuesFiles are a list of Helm chart values files
+	// "-" reads the values from the standard input, like `helm template -f -`.
+	// The standard input is read only once, and the same values are used throughout Chartify.
 	ValuesFiles []string
 
 	// DEPRECATED: Use SetFlags instead.
@@ -77,10 +77,39 @@ type ChartifyOpts struct {
 	// See https://github.com/kubernetes-sigs/kustomize/blob/master/examples/inlinePatch.md
 	Patches []string
 
+	// HookPatches is like Patches, but every patch in it is applied only to Helm hooks.
+	// See PatchOpts.HookPatches for more details.
+	HookPatches []string
+
+	// DropTestHooks removes Helm test hooks, like templates/tests/test-connection.yaml in charts generated by `helm create`,
+	// from the generated chart.
+	DropTestHooks bool
+
+	// ExposedValues re-exposes the selected fields of the rendered resources as values of the generated chart,
+	// so that they can still be overridden on installing or upgrading it, as the other values are frozen at rendering.
+	// See ExposedValue for more details.
+	ExposedValues []ExposedValue
+
+	// PermissiveValuesSchema makes the values.schema.json chartify writes for charts generated from K8s manifests or kustomizations
+	// accept any values.
+	// By default, the schema rejects unknown values, so that typos like `helm install --set replcias=2` fail.
+	// It accepts only `global`, the values chartify added to the chart, and the values given to chartify,
+	// so set this when Helm is given values that aren't given to chartify.
+	PermissiveValuesSchema bool
+
 	// Transformers is the list of YAML files each defines a Kustomize transformer
 	// See https://github.com/kubernetes-sigs/kustomize/blob/master/examples/configureBuiltinPlugin.md#configuring-the-builtin-plugins-instead for more information.
 	Transformers []string
 
+	// MergePatches is the list of YAML files each defining a JSON merge patch (RFC 7386) and its target,
+	// which suits custom resources whose strategic merge keys are unknown.
+	// See PatchOpts.MergePatches for more details.
+	MergePatches []string
+
+	// FieldSetters set a field at a path like `spec.template.spec.containers[0].image` of every resource their targets match.
+	// See FieldSetter for more details.
+	FieldSetters []FieldSetter
+
 	// WorkaroundOutputDirIssue prevents chartify from using `helm template --output-dir` and let it use `helm template > some.yaml` instead to
 	// workaround the potential helm issue
 	// See https://github.com/roboll/helmfile/issues/1279#issuecomment-636839395
@@ -101,6 +130,16 @@ type ChartifyOpts struct {
 	// Required for local/insecure OCI registries in Helm 4.
 	OCIPlainHTTP bool
 
+	// Verify makes chartify verify the provenance of the chart before rendering it.
+	// Remote charts and adhoc chart dependencies are pulled along with their `.prov` files and verified with `helm --verify`,
+	// and a local chart archive is verified against the `.prov` file next to it.
+	// Local chart directories cannot be verified, and result in an error.
+	Verify bool
+
+	// Keyring is the path to the keyring containing the public keys used to verify charts when Verify is true.
+	// Defaults to the keyring helm uses by default, `~/.gnupg/pubring.gpg`.
+	Keyring string
+
 	// IncludeCRDs is a Helm 3 only option. When it is true, chartify passes a `--include-crds` flag
 	// to helm-template.
 	IncludeCRDs bool
@@ -139,6 +178,47 @@ type ChartifyOpts struct {
 
 	// TemplateArgs to pass Flags to helm template
 	TemplateArgs string
+
+	// CRDPlacement determines where CustomResourceDefinitions go in the generated chart.
+	// When it is set to anything other than CRDPlacementPreserve, chartify renders and patches the chart
+	// even if no patches are given, so that the CRDs can be moved.
+	// See CRDPlacement for the available options.
+	CRDPlacement CRDPlacement
+
+	// PreserveFileLayout makes chartify write every patched resource back to the chart file it was rendered from,
+	// instead of merging all the patched resources into templates/patched_resources.yaml and crds/patched_crds.yaml.
+	// See PatchOpts.PreserveFileLayout for more details.
+	PreserveFileLayout bool
+
+	// SeparateHookFiles makes chartify write patched Helm hooks to templates/patched_hooks.yaml and
+	// test hooks to templates/tests/patched_tests.yaml, instead of merging them into templates/patched_resources.yaml.
+	// See PatchOpts.SeparateHookFiles for more details.
+	SeparateHookFiles bool
+
+	// EscapeTemplates makes chartify keep the rendered files in templates/ of the generated chart, escaping every `{{` and `}}`
+	// in them like `{{"{{"}}`, instead of moving them under files/ and replacing them with `{{ .Files.Get "files/..." }}` stubs.
+	// Either way, the Go template expressions contained in the rendered resources, like ones in PrometheusRules, are not rendered twice.
+	EscapeTemplates bool
+
+	// ChartAPIVersion is the apiVersion of Chart.yaml of the generated chart, like ChartAPIVersionV3.
+	// Defaults to the apiVersion of the input chart, or ChartAPIVersionV2 when the input is not a chart.
+	// The Helm binary must be able to load charts of the apiVersion.
+	ChartAPIVersion string
+
+	// FailOnUnmatchedPatch makes chartify fail when any of JsonPatches, StrategicMergePatches, Patches, HookPatches,
+	// MergePatches, FieldSetters, or Transformers with targets matches no resources, instead of silently doing nothing.
+	// The resources each patch matches are logged regardless of this option.
+	FailOnUnmatchedPatch bool
+
+	// PatchEngine determines what applies JsonPatches, StrategicMergePatches, Patches and HookPatches.
+	// Set it to PatchEngineNative to patch resources without the kustomize binary, unless Transformers are also used.
+	// See PatchEngine for the available options.
+	PatchEngine PatchEngine
+
+	// InputKind forces chartify to treat the input as the kind, like InputKindJsonnet,
+	// instead of detecting the kind with the InputDetectors of the Runner.
+	// It must be the kind of one of the detectors, either the default ones or the ones added via WithInputDetectors.
+	InputKind InputKind
 }
 
 type ChartifyOption interface {
@@ -180,68 +260,180 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 		}
 	}
 
+	chart, _, err := r.chartify(release, dirOrChart, u, false)
+
+	return chart, err
+}
+
+// chartify generates the chart like Chartify does.
+// When render is true, it stops after the resources are patched and injected, and returns them along with the unfinished chart.
+func (r *Runner) chartify(release, dirOrChart string, u *ChartifyOpts, render bool) (string, []*Resource, error) {
 	if u.SortOptions != nil {
 		if err := u.SortOptions.validate(); err != nil {
-			return "", err
+			return "", nil, err
+		}
+	}
+
+	if err := u.CRDPlacement.Validate(); err != nil {
+		return "", nil, err
+	}
+
+	if err := u.PatchEngine.Validate(); err != nil {
+		return "", nil, err
+	}
+
+	if u.ChartAPIVersion != "" {
+		if err := r.validateChartAPIVersion(u.ChartAPIVersion); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if u.InputKind != "" {
+		if _, err := r.inputDetector(u.InputKind); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// The original source is used to identify the temporary directory, as the path it's fetched to differs on every run
+	source := dirOrChart
+
+	if isRemoteSource(dirOrChart) {
+		downloadDir, err := os.MkdirTemp("", "chartify-source")
+		if err != nil {
+			return "", nil, err
+		}
+		defer func() {
+			_ = os.RemoveAll(downloadDir)
+		}()
+
+		dirOrChart, err = r.fetchRemoteSource(source, downloadDir, u)
+		if err != nil {
+			return "", nil, fmt.Errorf("fetching %s: %w", source, err)
 		}
 	}
 
 	isLocal, _ := r.Exists(dirOrChart)
 
-	var isKustomization bool
+	// Remote charts are pulled by helm
+	kind := InputKindChart
+
+	var (
+		builder InputBuilder
+		isDir   bool
+	)
 
 	if isLocal {
 		if stat, err := os.Stat(dirOrChart); err != nil {
-			return "", fmt.Errorf("unable to stat %s: %w", dirOrChart, err)
+			return "", nil, fmt.Errorf("unable to stat %s: %w", dirOrChart, err)
 		} else if stat.IsDir() {
-			var err error
-			isKustomization, err = r.Exists(filepath.Join(dirOrChart, "kustomization.yaml"))
-			if err != nil {
-				return "", err
+			isDir = true
+
+			if u.Verify {
+				return "", nil, fmt.Errorf("unable to verify %s: unpacked charts cannot be verified", dirOrChart)
 			}
 		}
+
+		var (
+			detector InputDetector
+			err      error
+		)
+
+		if u.InputKind != "" {
+			detector, err = r.inputDetector(u.InputKind)
+		} else {
+			detector, err = r.detectInput(dirOrChart)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		kind = detector.Kind()
+		builder, _ = detector.(InputBuilder)
+
+		r.Logf("Treating %s as %s", dirOrChart, kind)
+
+		if builder != nil && u.Verify {
+			return "", nil, fmt.Errorf("unable to verify %s: %s inputs cannot be verified", dirOrChart, kind)
+		}
+	} else if u.InputKind != "" && u.InputKind != InputKindChart {
+		return "", nil, fmt.Errorf("unable to read %s as %s: no such file or directory", dirOrChart, u.InputKind)
 	}
 
 	var tempDir string
-	if !isKustomization {
-		tempDir = r.MakeTempDir(release, dirOrChart, u)
+	if builder == nil {
+		tempDir = r.MakeTempDir(release, source, u)
 
 		if filepath.Ext(dirOrChart) == ".tgz" {
+			if u.Verify {
+				if err := VerifyChartArchive(dirOrChart, u.Keyring); err != nil {
+					return "", nil, err
+				}
+			}
+
 			tgzReader, err := os.Open(dirOrChart)
 			if err != nil {
-				return "", fmt.Errorf("unable to open %s: %w", dirOrChart, err)
+				return "", nil, fmt.Errorf("unable to open %s: %w", dirOrChart, err)
 			}
+			defer func() {
+				_ = tgzReader.Close()
+			}()
 
 			tempDir, err = ExtractFilesFromChartTGZ(tgzReader, tempDir)
 			if err != nil {
-				return "", fmt.Errorf("unable to extract files out of %s: %w", dirOrChart, err)
+				return "", nil, fmt.Errorf("unable to extract files out of %s: %w", dirOrChart, err)
 			}
 		} else {
 			var err error
-			tempDir, err = r.copyToTempDir(dirOrChart, tempDir, u.ChartVersion)
+			tempDir, err = r.copyToTempDir(dirOrChart, tempDir, u)
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 		}
 	} else {
-		tempDir = r.MakeTempDir(release, dirOrChart, u)
+		tempDir = r.MakeTempDir(release, source, u)
+	}
+
+	// This is done after the temporary directory is created, so that the ID of the directory doesn't depend on the path to the saved file
+	valuesFiles, removeStdinValues, err := saveStdinValues(u.ValuesFiles)
+	if err != nil {
+		return "", nil, err
 	}
+	defer removeStdinValues()
+
+	u.ValuesFiles = valuesFiles
 
 	chartYamlPath := filepath.Join(tempDir, "Chart.yaml")
 
-	isChart, err := r.Exists(chartYamlPath)
+	hasChartYaml, err := r.Exists(chartYamlPath)
 	if err != nil {
-		return "", err
+		return "", nil, err
+	}
+
+	isChart := kind == InputKindChart
+
+	if isChart && !hasChartYaml {
+		return "", nil, fmt.Errorf("unable to read %s as %s: Chart.yaml not found", dirOrChart, kind)
+	} else if !isChart && hasChartYaml {
+		// The input is forced to be treated as manifests, and Chart.yaml is generated below
+		if err := os.Remove(chartYamlPath); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if isChart {
+		if _, err := r.detectChartAPIVersion(chartYamlPath); err != nil {
+			return "", nil, err
+		}
 	}
 
 	templatesDir := filepath.Join(tempDir, "templates")
 	dirExists, err := r.Exists(templatesDir)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	if !dirExists {
 		if err := os.Mkdir(templatesDir, 0755); err != nil {
-			return "", err
+			return "", nil, err
 		}
 	}
 
@@ -253,52 +445,59 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 			fileType: []string{"gotmpl"},
 		})
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 
 		for _, absPath := range templateFiles {
 			tmpl := template.New(filepath.Base(absPath))
 			body, err := r.ReadFile(absPath)
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 
 			tmpl, err = tmpl.Funcs(u.TemplateFuncs).Parse(string(body))
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 
 			var buf bytes.Buffer
 
 			if err := tmpl.Execute(&buf, u.TemplateData); err != nil {
-				return "", err
+				return "", nil, err
 			}
 
 			if err := r.WriteFile(strings.TrimSuffix(absPath, filepath.Ext(absPath)), buf.Bytes(), 0644); err != nil {
-				return "", err
+				return "", nil, err
 			}
 		}
 	}
 
+	if !isChart {
+		cueOpts := &CueExportOpts{
+			ValuesFiles: u.ValuesFiles,
+			SetValues:   u.SetValues,
+			SetFlags:    u.SetFlags,
+		}
+		if _, err := r.CueExport(tempDir, cueOpts); err != nil {
+			return "", nil, err
+		}
+	}
+
 	generatedManifestsUnderTemplatesDir := []string{}
 
-	if isKustomization {
-		kustomizeOpts := &KustomizeBuildOpts{
-			ValuesFiles:        u.ValuesFiles,
-			SetValues:          u.SetValues,
-			SetFlags:           u.SetFlags,
-			EnableAlphaPlugins: u.EnableKustomizeAlphaPlugins,
-			Namespace:          u.Namespace,
-			HelmBinary:         r.helmBin(),
-			SortOptions:        u.SortOptions,
-			ExtraArgs:          u.KustomizeBuildArgs,
-		}
-		kustomizeFile, err := r.KustomizeBuild(dirOrChart, tempDir, kustomizeOpts)
+	if builder != nil {
+		builtFile, err := builder.Build(r, dirOrChart, tempDir, u)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 
-		generatedManifestsUnderTemplatesDir = append(generatedManifestsUnderTemplatesDir, kustomizeFile)
+		generatedManifestsUnderTemplatesDir = append(generatedManifestsUnderTemplatesDir, builtFile)
+
+		// kustomize sets the namespace by itself.
+		// Do set namespace if and only if the manifest has no `metadata.namespace` set
+		if kind != InputKindKustomize && overrideNamespace == "" && u.Namespace != "" {
+			overrideNamespace = u.Namespace
+		}
 	} else if !isChart {
 		manifestFileOptions := SearchFileOpts{
 			basePath: tempDir,
@@ -306,7 +505,7 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 		}
 		manifestFiles, err := r.SearchFiles(manifestFileOptions)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 
 		var usedDirs []string
@@ -314,7 +513,7 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 		for _, absPath := range manifestFiles {
 			relPath, err := filepath.Rel(tempDir, absPath)
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 
 			dst := filepath.Join(templatesDir, relPath)
@@ -322,14 +521,20 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 			dstDir := filepath.Dir(dst)
 			if _, err := os.Lstat(dstDir); err != nil && os.IsNotExist(err) {
 				if err := os.MkdirAll(dstDir, 0755); err != nil {
-					return "", err
+					return "", nil, err
 				}
 
 				usedDirs = append(usedDirs, filepath.Dir(absPath))
 			}
 
 			if err := os.Rename(absPath, dst); err != nil {
-				return "", err
+				return "", nil, err
+			}
+
+			// Helm splits manifests on `---` lines only, so get rid of CRLFs, `...` markers and
+			// comments on separator lines that it would otherwise choke on.
+			if err := r.normalizeManifestFile(dst); err != nil {
+				return "", nil, err
 			}
 
 			generatedManifestsUnderTemplatesDir = append(generatedManifestsUnderTemplatesDir, dst)
@@ -337,7 +542,7 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 
 		for _, d := range usedDirs {
 			if err := os.RemoveAll(d); err != nil {
-				return "", err
+				return "", nil, err
 			}
 		}
 
@@ -348,13 +553,16 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 	}
 
 	chartName := filepath.Base(filepath.Clean(dirOrChart))
+	if builder != nil && !isDir {
+		chartName = strings.TrimSuffix(chartName, filepath.Ext(chartName))
+	}
 	if !isChart {
 		ver := u.ChartVersion
 		if u.ChartVersion == "" {
 			ver = "1.0.0"
 			r.Logf("using the default chart version 1.0.0 due to that no ChartVersion is specified")
 		}
-		chartYamlContent := fmt.Sprintf("name: %q\nversion: %s\napiVersion: v2\n", chartName, ver)
+		chartYamlContent := fmt.Sprintf("name: %q\nversion: %s\napiVersion: %s\n", chartName, ver, ChartAPIVersionV2)
 		if u.AppVersion != "" {
 			chartYamlContent += fmt.Sprintf("appVersion: %q\n", u.AppVersion)
 		}
@@ -362,22 +570,17 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 		r.Logf("Writing %s", chartYamlPath)
 
 		if err := r.WriteFile(chartYamlPath, []byte(chartYamlContent), 0644); err != nil {
-			return "", err
-		}
-
-		filesDir, err := r.EnsureFilesDir(tempDir)
-		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 
-		if err := r.RewriteChartToPreventDoubleRendering(tempDir, filesDir); err != nil {
-			return "", err
+		if err := r.preventDoubleRendering(tempDir, u.EscapeTemplates, nil); err != nil {
+			return "", nil, err
 		}
 	}
 
 	deps, err := r.ReadAdhocDependencies(u)
 	if err != nil {
-		return "", fmt.Errorf("failed reading adhoc dependencies: %w", err)
+		return "", nil, fmt.Errorf("failed reading adhoc dependencies: %w", err)
 	}
 
 	// We need to modify the original Chart.yaml dependencies or requirements.yaml dependencies to only include
@@ -386,7 +589,7 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 	// and add deps when it's a local chart. That's why we specify `!isLocal` as the first argument(replace).
 	all, err := r.UpdateRequirements(!isLocal, chartYamlPath, tempDir, deps)
 	if err != nil {
-		return "", fmt.Errorf("release %s: updating requirements: %w", release, err)
+		return "", nil, fmt.Errorf("release %s: updating requirements: %w", release, err)
 	}
 
 	var generatedManifestFiles []string
@@ -418,10 +621,7 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 				depCmd = "build"
 			}
 			depArgs := []string{"dependency", depCmd, tempDir}
-			// Helm 4 requires --plain-http for HTTP-only OCI registries
-			if u.OCIPlainHTTP && r.IsHelm4() {
-				depArgs = append(depArgs, "--plain-http")
-			}
+			depArgs = append(depArgs, r.HelmAdapter().DependencyFlags(u)...)
 			_, err := r.run(nil, r.helmBin(), depArgs...)
 			if err != nil && useBuild && isLockOutOfSyncErr(err) {
 				// `helm dependency build` errors when Chart.lock is out of sync with Chart.yaml.
@@ -432,7 +632,7 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 				_, err = r.run(nil, r.helmBin(), depArgs...)
 			}
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 		}
 	} else if len(u.AdhocChartDependencies) > 0 {
@@ -441,56 +641,64 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 		// after running `helm fetch`.
 		// We need to download adhoc dependencies on our own by running helmfile dependency up.
 		depArgs := []string{"dependency", "up", tempDir}
-		// Helm 4 requires --plain-http for HTTP-only OCI registries
-		if u.OCIPlainHTTP && r.IsHelm4() {
-			depArgs = append(depArgs, "--plain-http")
-		}
+		depArgs = append(depArgs, r.HelmAdapter().DependencyFlags(u)...)
 		_, err := r.run(nil, r.helmBin(), depArgs...)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 	}
 
-	templateOptions := ReplaceWithRenderedOpts{
-		Debug:        u.Debug,
-		Namespace:    u.Namespace,
-		SetValues:    u.SetValues,
-		SetFlags:     u.SetFlags,
-		ValuesFiles:  u.ValuesFiles,
-		ChartVersion: u.ChartVersion,
-		IncludeCRDs:  u.IncludeCRDs,
-		Validate:     u.Validate,
-		KubeVersion:  u.KubeVersion,
-		ApiVersions:  u.ApiVersions,
-		TemplateArgs: u.TemplateArgs,
-
-		WorkaroundOutputDirIssue: u.WorkaroundOutputDirIssue,
-	}
+	templateOptions := u.templateOpts()
 
 	if _, err := r.UpdateRequirements(true, chartYamlPath, tempDir, all); err != nil {
-		return "", fmt.Errorf("release %s: replacing requirements: %w", release, err)
+		return "", nil, fmt.Errorf("release %s: replacing requirements: %w", release, err)
 	}
 
 	var (
 		needsNamespaceOverride = overrideNamespace != ""
-		needsKustomizeBuild    = len(u.JsonPatches) > 0 || len(u.StrategicMergePatches) > 0 || len(u.Patches) > 0 || len(u.Transformers) > 0
+		needsKustomizeBuild    = len(u.JsonPatches) > 0 || len(u.StrategicMergePatches) > 0 || len(u.Patches) > 0 || len(u.HookPatches) > 0 || len(u.Transformers) > 0 || len(u.MergePatches) > 0 || len(u.FieldSetters) > 0 || (u.CRDPlacement != "" && u.CRDPlacement != CRDPlacementPreserve)
 		needsInjections        = len(u.Injectors) > 0 || len(u.Injects) > 0
+		needsTestHooksDropped  = u.DropTestHooks
+		needsValuesExposed     = len(u.ExposedValues) > 0
 	)
 
 	// This is required to support charts depend on `{{ .Release.Revision }}`,
 	// in case we don't need to run helm-template to generate the intermediate chart.
 	// See https://github.com/helmfile/helmfile/issues/430
-	if !needsNamespaceOverride && !needsKustomizeBuild && !needsInjections && isChart {
-		return tempDir, nil
+	if !needsNamespaceOverride && !needsKustomizeBuild && !needsInjections && !needsTestHooksDropped && !needsValuesExposed && isChart && !render {
+		if err := r.writeValuesSchema(tempDir, false, false, deps, nil, nil); err != nil {
+			return "", nil, err
+		}
+
+		if u.ChartAPIVersion != "" {
+			if err := r.setChartAPIVersion(chartYamlPath, u.ChartAPIVersion); err != nil {
+				return "", nil, err
+			}
+		}
+
+		return tempDir, nil, nil
+	}
+
+	if isChart {
+		if err := r.validateValues(tempDir, u); err != nil {
+			return "", nil, fmt.Errorf("release %s: validating values: %w", release, err)
+		}
 	}
 
 	generated, err := r.ReplaceWithRendered(release, chartName, tempDir, templateOptions)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	generatedManifestFiles = generated
 
+	if needsTestHooksDropped {
+		generatedManifestFiles, err = r.dropTestHooks(generatedManifestFiles)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
 	// We've already rendered resources from the chart and its subcharts to the helmx.1.rendered directory
 	// No need to double-render them by leaving requirements.yaml/lock and downloaded sub-charts
 	_ = os.Remove(filepath.Join(tempDir, "requirements.yaml"))
@@ -498,26 +706,48 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 
 	if needsNamespaceOverride {
 		if err := r.SetNamespace(tempDir, overrideNamespace); err != nil {
-			return "", err
+			return "", nil, err
 		}
 	}
 
+	patchOpts := &PatchOpts{
+		JsonPatches:           u.JsonPatches,
+		StrategicMergePatches: u.StrategicMergePatches,
+		Patches:               u.Patches,
+		HookPatches:           u.HookPatches,
+		Transformers:          u.Transformers,
+		MergePatches:          u.MergePatches,
+		FieldSetters:          u.FieldSetters,
+		EnableAlphaPlugins:    u.EnableKustomizeAlphaPlugins,
+		SortOptions:           u.SortOptions,
+		ExtraArgs:             u.KustomizeBuildArgs,
+		CRDPlacement:          u.CRDPlacement,
+		PreserveFileLayout:    u.PreserveFileLayout,
+		SeparateHookFiles:     u.SeparateHookFiles,
+		FailOnUnmatchedPatch:  u.FailOnUnmatchedPatch,
+		PatchEngine:           u.PatchEngine,
+	}
+
 	// When the chart rendered no resources, there is nothing for kustomize to build or
 	// patch. Skip the kustomize step entirely so an empty render is treated as a no-op
 	// success even when JsonPatches/StrategicMergePatches/Transformers are configured
 	// (the patches simply have no resources to apply to). See issue #206.
-	if needsKustomizeBuild && len(generatedManifestFiles) > 0 {
-		patchOpts := &PatchOpts{
-			JsonPatches:           u.JsonPatches,
-			StrategicMergePatches: u.StrategicMergePatches,
-			Patches:               u.Patches,
-			Transformers:          u.Transformers,
-			EnableAlphaPlugins:    u.EnableKustomizeAlphaPlugins,
-			SortOptions:           u.SortOptions,
-			ExtraArgs:             u.KustomizeBuildArgs,
+	// FailOnUnmatchedPatch still makes it fail, as none of the patches matches anything.
+	if needsKustomizeBuild && len(generatedManifestFiles) == 0 {
+		if u.FailOnUnmatchedPatch {
+			if err := r.checkPatchMatches(nil, patchOpts); err != nil {
+				return "", nil, err
+			}
 		}
+	} else if needsKustomizeBuild {
+		if u.CRDPlacement == CRDPlacementSeparateChart {
+			if err := os.RemoveAll(CRDsChartPath(tempDir)); err != nil {
+				return "", nil, err
+			}
+		}
+
 		if err := r.Patch(tempDir, generatedManifestFiles, patchOpts); err != nil {
-			return "", err
+			return "", nil, err
 		}
 	}
 
@@ -530,24 +760,84 @@ func (r *Runner) Chartify(release, dirOrChart string, opts ...ChartifyOption) (s
 		injects:   u.Injects,
 	}
 	if err := r.Inject(generatedManifestFiles, injectOptions); err != nil {
-		return "", err
+		return "", nil, err
+	}
+
+	if render {
+		resources, err := r.readRenderedResources(tempDir, u.IncludeCRDs)
+		if err != nil {
+			return "", nil, err
+		}
+
+		return tempDir, resources, nil
+	}
+
+	var exposedValuePlaceholders map[string][]exposedValuePlaceholder
+	if needsValuesExposed {
+		exposedValuePlaceholders, err = r.exposeValues(tempDir, u.ExposedValues)
+		if err != nil {
+			return "", nil, err
+		}
 	}
 
 	//
 	// Move all the resulting files under `templates` and `crds` to `files/templates` and `files/crds` and
-	// create replacement template files in their original locations to avoid double rendering.
+	// create replacement template files in their original locations to avoid double rendering,
+	// or escape them in place when EscapeTemplates is set.
 	//
 
-	filesDir, err := r.EnsureFilesDir(tempDir)
-	if err != nil {
-		return "", err
+	if err := r.preventDoubleRendering(tempDir, u.EscapeTemplates, exposedValuePlaceholders); err != nil {
+		return "", nil, err
 	}
 
-	if err := r.RewriteChartToPreventDoubleRendering(tempDir, filesDir); err != nil {
-		return "", err
+	strictValuesSchema := !u.PermissiveValuesSchema
+
+	var givenValues []string
+	if strictValuesSchema {
+		givenValues, err = r.givenValuesKeys(u)
+		if err != nil {
+			return "", nil, err
+		}
 	}
 
-	return tempDir, nil
+	if err := r.writeValuesSchema(tempDir, !isChart, strictValuesSchema, deps, u.ExposedValues, givenValues); err != nil {
+		return "", nil, err
+	}
+
+	// The chart is rendered in its original format, so that the Helm binary doesn't need to support
+	// the requested apiVersion to generate the chart.
+	if u.ChartAPIVersion != "" {
+		if err := r.setChartAPIVersion(chartYamlPath, u.ChartAPIVersion); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if u.CRDPlacement == CRDPlacementSeparateChart {
+		if err := r.writeCRDsChart(tempDir, u.EscapeTemplates, strictValuesSchema); err != nil {
+			return "", nil, fmt.Errorf("writing the CRDs chart: %w", err)
+		}
+	}
+
+	return tempDir, nil, nil
+}
+
+// templateOpts returns the options to render the chart with `helm template`.
+func (u *ChartifyOpts) templateOpts() ReplaceWithRenderedOpts {
+	return ReplaceWithRenderedOpts{
+		Debug:        u.Debug,
+		Namespace:    u.Namespace,
+		SetValues:    u.SetValues,
+		SetFlags:     u.SetFlags,
+		ValuesFiles:  u.ValuesFiles,
+		ChartVersion: u.ChartVersion,
+		IncludeCRDs:  u.IncludeCRDs,
+		Validate:     u.Validate,
+		KubeVersion:  u.KubeVersion,
+		ApiVersions:  u.ApiVersions,
+		TemplateArgs: u.TemplateArgs,
+
+		WorkaroundOutputDirIssue: u.WorkaroundOutputDirIssue,
+	}
 }
 
 func (r *Runner) ReadAdhocDependencies(u *ChartifyOpts) ([]Dependency, error) {
@@ -585,7 +875,7 @@ func (r *Runner) ReadAdhocDependencies(u *ChartifyOpts) ([]Dependency, error) {
 		if isLocalChart {
 			name = filepath.Base(d.Chart)
 			repoUrl = fmt.Sprintf("file://%s", d.Chart)
-		} else if (r.IsHelm3() && registryv3.IsOCI(d.Chart)) || (r.IsHelm4() && registryv4.IsOCI(d.Chart)) {
+		} else if r.HelmAdapter().IsOCI(d.Chart) {
 			name = filepath.Base(d.Chart)
 			// Trim trailing slash to avoid invalid repository error due to duplicate slash in oci registry url
 			// while running helm dependency up
@@ -707,20 +997,8 @@ func (r *Runner) RewriteChartToPreventDoubleRendering(tempDir, filesDir string)
 			return err
 		}
 
-		// Without this, any sub-sequent helm command on the generated local chart result in
-		// an error due to missing Chart.yaml for every `charts/SUBCHART`
 		if d == "charts" {
-			chartsDir := filepath.Join(tempDir, "charts")
-			templatesDir := filepath.Join(tempDir, "templates")
-			templateChartsDir := filepath.Join(templatesDir, "charts")
-
-			// Otherwise the below Rename fail due to missing destination `templates` directory when
-			// the original chart had no `templates` directory. Yes, that's a valid chart.
-			if err := os.MkdirAll(templatesDir, 0755); err != nil {
-				return err
-			}
-
-			if err := os.Rename(chartsDir, templateChartsDir); err != nil {
+			if err := moveChartsUnderTemplates(tempDir); err != nil {
 				return err
 			}
 		}
@@ -729,6 +1007,23 @@ func (r *Runner) RewriteChartToPreventDoubleRendering(tempDir, filesDir string)
 	return nil
 }
 
+// moveChartsUnderTemplates moves the rendered subcharts in charts/ to templates/charts/.
+// Without this, any sub-sequent helm command on the generated local chart result in
+// an error due to missing Chart.yaml for every `charts/SUBCHART`
+func moveChartsUnderTemplates(tempDir string) error {
+	chartsDir := filepath.Join(tempDir, "charts")
+	templatesDir := filepath.Join(tempDir, "templates")
+	templateChartsDir := filepath.Join(templatesDir, "charts")
+
+	// Otherwise the below Rename fail due to missing destination `templates` directory when
+	// the original chart had no `templates` directory. Yes, that's a valid chart.
+	if err := os.MkdirAll(templatesDir, 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(chartsDir, templateChartsDir)
+}
+
 // hasChartLock reports whether a Chart.lock or requirements.lock file exists in the
 // given chart directory. Helm uses Chart.lock for apiVersion v2 charts and the legacy
 // requirements.lock for v1 charts; either is sufficient for `helm dependency build`.
@@ -767,13 +1062,13 @@ func createDirForFile(f string) error {
 
 // copyToTempDir checks if the path is local or a repo (in this order) and copies it to a temp directory
 // It will perform a `helm fetch` if required
-func (r *Runner) copyToTempDir(path, tempDir, chartVersion string) (string, error) {
+func (r *Runner) copyToTempDir(path, tempDir string, u *ChartifyOpts) (string, error) {
 	exists, err := r.Exists(path)
 	if err != nil {
 		return "", err
 	}
 	if !exists {
-		return r.fetchAndUntarUnderDir(path, tempDir, chartVersion)
+		return r.fetchAndUntarUnderDir(path, tempDir, u)
 	}
 	err = copy.Copy(path, tempDir)
 	if err != nil {
@@ -782,7 +1077,18 @@ func (r *Runner) copyToTempDir(path, tempDir, chartVersion string) (string, erro
 	return tempDir, nil
 }
 
-func (r *Runner) fetchAndUntarUnderDir(chart, tempDir, chartVersion string) (string, error) {
+func (r *Runner) fetchAndUntarUnderDir(chart, tempDir string, u *ChartifyOpts) (string, error) {
+	// Charts in classic chart repositories are fetched in-process only when enabled,
+	// as it bypasses RunCommand and the helm binary.
+	// Others like OCI charts are always fetched by `helm pull`.
+	if r.InProcessChartFetch && isRepoChartRef(chart) {
+		return NewChartFetcher(r.Logf).Fetch(chart, tempDir, FetchOpts{
+			Version: u.ChartVersion,
+			Verify:  u.Verify,
+			Keyring: u.Keyring,
+		})
+	}
+
 	helmVersionConstraint, _ := semver.NewConstraint(">= 3.7.0")
 	helmVersion, err := r.DetectHelmVersion()
 	if err != nil {
@@ -792,10 +1098,15 @@ func (r *Runner) fetchAndUntarUnderDir(chart, tempDir, chartVersion string) (str
 	if helmVersionConstraint.Check(helmVersion) {
 		helmPullCommand = []string{"pull", chart, "--untar", "-d", tempDir}
 
-		if chartVersion != "" {
-			helmPullCommand = append(helmPullCommand, "--version", chartVersion)
+		if u.ChartVersion != "" {
+			helmPullCommand = append(helmPullCommand, "--version", u.ChartVersion)
 		}
+
+		helmPullCommand = append(helmPullCommand, verifyFlags(u.Verify, u.Keyring)...)
 	} else {
+		if u.Verify {
+			return "", fmt.Errorf("unable to verify %s: verifying charts requires helm 3.7.0 or greater, but got %s", chart, helmVersion)
+		}
 		helmPullCommand = []string{"chart", "pull", chart}
 	}
 
@@ -0,0 +1,227 @@
+package chartrepo
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fault configures a failure injected by the server while serving a specific path.
+// It is intended to be used for testing how clients handle flaky chart repositories.
+type Fault struct {
+	// Latency delays the response by the duration.
+	Latency time.Duration
+
+	// StatusCode makes the server respond with the status code and an empty body
+	// instead of the actual content.
+	StatusCode int
+
+	// TruncateAfter makes the server stop writing the response body after the number of bytes,
+	// while still advertising the full Content-Length, so that the client sees an unexpected EOF.
+	TruncateAfter int64
+
+	// CorruptDigest makes the index.yaml list a wrong digest for the chart package served at the path.
+	// It has no effect on paths other than chart packages.
+	// The digest is corrupted once when the index is built, so it ignores Times and
+	// never counts towards it: combined with e.g. StatusCode, the status code is still returned Times times.
+	CorruptDigest bool
+
+	// Times is the number of requests Latency, StatusCode and TruncateAfter are applied to.
+	// Subsequent requests are served normally. 0 means the fault is applied to every request.
+	Times int
+}
+
+// RequestRecord is a request received by the server.
+type RequestRecord struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Time       time.Time
+}
+
+// requestLog records requests and counts the number of times each fault has been applied.
+// It is shared between the handlers serving concurrent requests.
+type requestLog struct {
+	mu       sync.Mutex
+	records  []RequestRecord
+	injected map[string]int
+}
+
+func newRequestLog() *requestLog {
+	return &requestLog{
+		injected: map[string]int{},
+	}
+}
+
+func (l *requestLog) record(r RequestRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records = append(l.records, r)
+}
+
+// takeFault returns the fault to be applied to the current request to the path, if any.
+func (l *requestLog) takeFault(faults map[string]Fault, path string) (Fault, bool) {
+	f, ok := faults[path]
+	if !ok || !f.affectsRequests() {
+		return Fault{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if f.Times > 0 && l.injected[path] >= f.Times {
+		return Fault{}, false
+	}
+
+	l.injected[path]++
+
+	return f, true
+}
+
+// affectsRequests returns true when the fault changes how requests are served,
+// as opposed to CorruptDigest which only changes the index.
+func (f Fault) affectsRequests() bool {
+	return f.Latency > 0 || f.StatusCode != 0 || f.TruncateAfter > 0
+}
+
+func (l *requestLog) list() []RequestRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return append([]RequestRecord{}, l.records...)
+}
+
+// corruptDigest returns a digest that never matches the given digest.
+func corruptDigest(digest string) string {
+	if digest == "" {
+		return strings.Repeat("0", 64)
+	}
+
+	b := []byte(digest)
+	if b[0] == '0' {
+		b[0] = '1'
+	} else {
+		b[0] = '0'
+	}
+
+	return string(b)
+}
+
+// Requests returns the requests received by the server in the order of arrival.
+func (s *Server) Requests() []RequestRecord {
+	if s.requests == nil {
+		return nil
+	}
+
+	return s.requests.list()
+}
+
+// RequestsTo returns the requests received by the server for the path.
+func (s *Server) RequestsTo(path string) []RequestRecord {
+	var records []RequestRecord
+
+	for _, r := range s.Requests() {
+		if r.Path == path {
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
+
+// withFaults wraps the handler to record every request and inject the faults configured for the request path.
+func (s *Server) withFaults(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recordingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK, truncateAfter: -1}
+
+		defer func() {
+			s.requests.record(RequestRecord{
+				Method:     r.Method,
+				Path:       r.URL.Path,
+				StatusCode: rw.statusCode,
+				Time:       time.Now(),
+			})
+		}()
+
+		f, ok := s.requests.takeFault(s.Faults, r.URL.Path)
+		if !ok {
+			next.ServeHTTP(rw, r)
+			return
+		}
+
+		if f.Latency > 0 {
+			select {
+			case <-time.After(f.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if f.StatusCode != 0 {
+			rw.WriteHeader(f.StatusCode)
+			return
+		}
+
+		if f.TruncateAfter > 0 {
+			rw.truncateAfter = f.TruncateAfter
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// recordingResponseWriter records the status code of the response and
+// optionally truncates the response body.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+
+	statusCode  int
+	wroteHeader bool
+
+	// truncateAfter is the number of bytes written before the rest of the body is discarded.
+	// Negative means the body is never truncated.
+	truncateAfter int64
+	written       int64
+}
+
+func (w *recordingResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.statusCode = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if w.truncateAfter < 0 {
+		n, err := w.ResponseWriter.Write(b)
+		w.written += int64(n)
+		return n, err
+	}
+
+	remaining := w.truncateAfter - w.written
+	if remaining <= 0 {
+		// Pretend the write succeeded so that the handler does not try to write an error message
+		return len(b), nil
+	}
+
+	chunk := b
+	if int64(len(chunk)) > remaining {
+		chunk = chunk[:remaining]
+	}
+
+	n, err := w.ResponseWriter.Write(chunk)
+	w.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	return len(b), nil
+}
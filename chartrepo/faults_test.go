@@ -0,0 +1,180 @@
+package chartrepo
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func startTestServer(t *testing.T, faults map[string]Fault) (*Server, string) {
+	t.Helper()
+
+	helmBin := "helm"
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helmBin = h
+	}
+
+	s := &Server{
+		Port:      RandomPort,
+		ChartsDir: "../testdata/charts",
+		HelmBin:   helmBin,
+		Faults:    faults,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	serverURL, err := s.Start(ctx)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, s.Wait())
+	})
+
+	return s, serverURL
+}
+
+func get(t *testing.T, u string) (int, []byte, error) {
+	t.Helper()
+
+	res, err := http.Get(u)
+	require.NoError(t, err)
+	defer func() {
+		_ = res.Body.Close()
+	}()
+
+	body, err := io.ReadAll(res.Body)
+
+	return res.StatusCode, body, err
+}
+
+func TestServer_Requests(t *testing.T) {
+	s, serverURL := startTestServer(t, nil)
+
+	code, _, err := get(t, serverURL+"index.yaml")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, code)
+
+	code, _, err = get(t, serverURL+"db-0.1.0.tgz")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, code)
+
+	code, _, err = get(t, serverURL+"missing.txt")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, code)
+
+	reqs := s.Requests()
+	require.Len(t, reqs, 3)
+	require.Equal(t, "/index.yaml", reqs[0].Path)
+	require.Equal(t, http.MethodGet, reqs[0].Method)
+	require.Equal(t, http.StatusOK, reqs[0].StatusCode)
+	require.Equal(t, "/db-0.1.0.tgz", reqs[1].Path)
+	require.Equal(t, http.StatusNotFound, reqs[2].StatusCode)
+
+	require.Len(t, s.RequestsTo("/db-0.1.0.tgz"), 1)
+	require.Empty(t, s.RequestsTo("/log-0.1.0.tgz"))
+}
+
+func TestServer_Faults(t *testing.T) {
+	t.Run("status code", func(t *testing.T) {
+		s, serverURL := startTestServer(t, map[string]Fault{
+			"/db-0.1.0.tgz": {StatusCode: http.StatusServiceUnavailable, Times: 2},
+		})
+
+		for range 2 {
+			code, _, err := get(t, serverURL+"db-0.1.0.tgz")
+			require.NoError(t, err)
+			require.Equal(t, http.StatusServiceUnavailable, code)
+		}
+
+		code, _, err := get(t, serverURL+"db-0.1.0.tgz")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, code, "fault should be applied only twice")
+
+		reqs := s.RequestsTo("/db-0.1.0.tgz")
+		require.Len(t, reqs, 3)
+		require.Equal(t, http.StatusServiceUnavailable, reqs[0].StatusCode)
+		require.Equal(t, http.StatusOK, reqs[2].StatusCode)
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		_, serverURL := startTestServer(t, map[string]Fault{
+			"/index.yaml": {Latency: 300 * time.Millisecond},
+		})
+
+		client := &http.Client{Timeout: 100 * time.Millisecond}
+		_, err := client.Get(serverURL + "index.yaml")
+		require.Error(t, err, "request should time out")
+
+		start := time.Now()
+		code, _, err := get(t, serverURL+"index.yaml")
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, code)
+		require.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	})
+
+	t.Run("truncated body", func(t *testing.T) {
+		_, serverURL := startTestServer(t, map[string]Fault{
+			"/db-0.1.0.tgz": {TruncateAfter: 10},
+		})
+
+		code, body, err := get(t, serverURL+"db-0.1.0.tgz")
+		require.Equal(t, http.StatusOK, code)
+		require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		require.Len(t, body, 10)
+	})
+
+	t.Run("corrupt digest", func(t *testing.T) {
+		_, cleanURL := startTestServer(t, nil)
+		_, serverURL := startTestServer(t, map[string]Fault{
+			"/db-0.1.0.tgz": {CorruptDigest: true},
+		})
+
+		digestOf := func(u, chart string) string {
+			t.Helper()
+
+			_, body, err := get(t, u+"index.yaml")
+			require.NoError(t, err)
+
+			var index struct {
+				Entries map[string][]struct {
+					Digest string `yaml:"digest"`
+				} `yaml:"entries"`
+			}
+			require.NoError(t, yaml.Unmarshal(body, &index))
+			require.Len(t, index.Entries[chart], 1)
+
+			return index.Entries[chart][0].Digest
+		}
+
+		require.NotEqual(t, digestOf(cleanURL, "db"), digestOf(serverURL, "db"))
+		require.Equal(t, digestOf(cleanURL, "log"), digestOf(serverURL, "log"))
+
+		// The corrupted digest doesn't count towards Times of the other faults
+		_, combinedURL := startTestServer(t, map[string]Fault{
+			"/db-0.1.0.tgz": {CorruptDigest: true, StatusCode: http.StatusServiceUnavailable, Times: 2},
+		})
+
+		require.NotEqual(t, digestOf(cleanURL, "db"), digestOf(combinedURL, "db"))
+
+		for _, want := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK} {
+			code, _, err := get(t, combinedURL+"db-0.1.0.tgz")
+			require.NoError(t, err)
+			require.Equal(t, want, code)
+		}
+
+		require.NotEqual(t, digestOf(cleanURL, "db"), digestOf(combinedURL, "db"), "the digest must stay corrupted after Times requests")
+	})
+}
+
+func TestCorruptDigest(t *testing.T) {
+	require.NotEqual(t, "abc", corruptDigest("abc"))
+	require.NotEqual(t, "0bc", corruptDigest("0bc"))
+	require.Len(t, corruptDigest(""), 64)
+}
@@ -6,15 +6,18 @@ package chartrepo
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,6 +25,7 @@ import (
 	loaderv3 "helm.sh/helm/v3/pkg/chart/loader"
 	provenancev3 "helm.sh/helm/v3/pkg/provenance"
 	repov3 "helm.sh/helm/v3/pkg/repo"
+	chartv4 "helm.sh/helm/v4/pkg/chart"
 	loaderv4 "helm.sh/helm/v4/pkg/chart/loader"
 	v2 "helm.sh/helm/v4/pkg/chart/v2"
 	provenancev4 "helm.sh/helm/v4/pkg/provenance"
@@ -29,35 +33,94 @@ import (
 )
 
 type Server struct {
-	Port      int
+	// Port is the port the server listens on. Defaults to 18080.
+	// Set it to RandomPort to let the OS choose a free port, which ServerURL and Start report once started.
+	Port int
+	// Host is the host the server listens on, like "localhost" or "127.0.0.1".
+	// Defaults to all interfaces, in which case the chart repository URL is on localhost.
+	// Older versions required the port in Host, which is still accepted and takes precedence over Port.
 	Host      string
 	ChartsDir string
 	HelmBin   string
 	isHelm3   *bool
 	isHelm4   *bool
+
+	// SigningKey is the name of the key used to sign the chart packages.
+	// When set, every chart is packaged with `helm package --sign` and
+	// its provenance file is served at the chart package URL suffixed with `.prov`.
+	SigningKey string
+	// SigningKeyring is the path to the secret keyring containing SigningKey.
+	SigningKeyring string
+
+	// Faults maps request paths like "/index.yaml" and "/mychart-0.1.0.tgz"
+	// to faults injected while serving them.
+	Faults map[string]Fault
+
+	// requests records the requests received by the running server
+	requests *requestLog
+
+	// serverURL is the URL of the running server, set by Start
+	serverURL string
+	// done is closed once the HTTP server has stopped serving
+	done chan struct{}
+	// serveErr is the error returned by the HTTP server, if any
+	serveErr error
 }
 
+// ShutdownTimeout is the maximum duration the server waits for in-flight requests
+// to complete on shutdown.
+var ShutdownTimeout = 5 * time.Second
+
+// RandomPort is the Server.Port that lets the OS choose a free port.
+const RandomPort = -1
+
 func (s *Server) getPort() int {
-	port := s.Port
-	if port == 0 {
-		port = 18080
+	switch s.Port {
+	case 0:
+		return 18080
+	case RandomPort:
+		return 0
 	}
-	return port
+	return s.Port
 }
 
-func (s *Server) getHostport() string {
-	port := s.getPort()
-	hostport := s.Host
-	if hostport == "" {
-		hostport = fmt.Sprintf("localhost:%d", port)
+// getListenAddr returns the address the server listens on.
+func (s *Server) getListenAddr() string {
+	host, port := s.Host, strconv.Itoa(s.getPort())
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// getHostport returns the host and port of the chart repository URL for the server listening at addr.
+// The unspecified address of all interfaces is replaced with localhost.
+func getHostport(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return addr.String()
 	}
-	return hostport
+	if tcpAddr.IP == nil || tcpAddr.IP.IsUnspecified() {
+		return net.JoinHostPort("localhost", strconv.Itoa(tcpAddr.Port))
+	}
+	return tcpAddr.String()
 }
 
+// ServerURL returns the URL of the chart repository, with a trailing slash.
+// After Start, this is the URL of the running server.
+// It is empty before Start when Port is RandomPort, as the port is unknown until then.
 func (s *Server) ServerURL() string {
-	hostport := s.getHostport()
-	serverURL := fmt.Sprintf("http://%s/", hostport)
-	return serverURL
+	if s.serverURL != "" {
+		return s.serverURL
+	}
+	host, port, _ := net.SplitHostPort(s.getListenAddr())
+	if port == "0" {
+		return ""
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	return fmt.Sprintf("http://%s/", net.JoinHostPort(host, port))
 }
 
 func (s *Server) getHelmBin() string {
@@ -126,24 +189,115 @@ func (s *Server) IsHelm4() bool {
 	return v
 }
 
+// Run starts the server and blocks until ctx is canceled and the server has shut down.
 func (s *Server) Run(ctx context.Context) error {
-	serverURL := s.ServerURL()
+	if _, err := s.Start(ctx); err != nil {
+		return err
+	}
+
+	return s.Wait()
+}
+
+// Start packages the charts under ChartsDir, starts serving them along with the index.yaml,
+// and returns the URL of the chart repository once the server is ready to accept connections.
+//
+// The server is gracefully shut down when ctx is canceled. Use Wait to block until it has stopped.
+func (s *Server) Start(ctx context.Context) (string, error) {
 	chartsDir := s.ChartsDir
 	if chartsDir == "" {
-		return fmt.Errorf("ChartsDir is required")
+		return "", fmt.Errorf("ChartsDir is required")
 	}
 
 	worktree, err := os.MkdirTemp(os.TempDir(), "chartrepo")
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	defer func() {
+	removeWorktree := func() {
 		if err := os.RemoveAll(worktree); err != nil {
 			log.Printf("unable to remove worktree %s: %v", worktree, err)
 		}
+	}
+
+	if err := s.packageCharts(ctx, chartsDir, worktree); err != nil {
+		removeWorktree()
+		return "", err
+	}
+
+	ln, err := net.Listen("tcp", s.getListenAddr())
+	if err != nil {
+		removeWorktree()
+		return "", fmt.Errorf("starting server: %w", err)
+	}
+
+	serverURL := fmt.Sprintf("http://%s/", getHostport(ln.Addr()))
+
+	indexYamlPath := filepath.Join(worktree, "index.yaml")
+
+	if s.IsHelm3() {
+		err = s.indexHelm3(worktree, indexYamlPath, serverURL)
+	} else if s.IsHelm4() {
+		err = s.indexHelm4(worktree, indexYamlPath, serverURL)
+	} else {
+		err = fmt.Errorf("unsupported helm version")
+	}
+	if err != nil {
+		_ = ln.Close()
+		removeWorktree()
+		return "", err
+	}
+
+	s.requests = newRequestLog()
+
+	server := &http.Server{
+		Handler: s.withFaults(s.newHandler(worktree, indexYamlPath)),
+	}
+
+	s.serverURL = serverURL
+	s.done = make(chan struct{})
+	s.serveErr = nil
+
+	go func() {
+		defer close(s.done)
+		defer removeWorktree()
+
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.serveErr = fmt.Errorf("serving: %w", err)
+		}
 	}()
 
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.done:
+			return
+		}
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("unable to gracefully shut down chartrepo server: %v", err)
+			_ = server.Close()
+		}
+	}()
+
+	return serverURL, nil
+}
+
+// Wait blocks until the server started by Start has stopped, and returns
+// the error that caused the server to stop, if any.
+func (s *Server) Wait() error {
+	if s.done == nil {
+		return fmt.Errorf("server has not been started")
+	}
+
+	<-s.done
+
+	return s.serveErr
+}
+
+func (s *Server) packageCharts(ctx context.Context, chartsDir, worktree string) error {
 	dirEntries, err := os.ReadDir(chartsDir)
 	if err != nil {
 		return err
@@ -163,7 +317,17 @@ func (s *Server) Run(ctx context.Context) error {
 			return fmt.Errorf("unable to get abs path to %s: %w", chart, err)
 		}
 
-		cmd := exec.CommandContext(ctx, s.getHelmBin(), "package", abs)
+		args := []string{"package", abs}
+
+		if s.SigningKey != "" {
+			keyring, err := filepath.Abs(s.SigningKeyring)
+			if err != nil {
+				return fmt.Errorf("unable to get abs path to %s: %w", s.SigningKeyring, err)
+			}
+			args = append(args, "--sign", "--key", s.SigningKey, "--keyring", keyring)
+		}
+
+		cmd := exec.CommandContext(ctx, s.getHelmBin(), args...)
 		cmd.Dir = worktree
 		out, err := cmd.CombinedOutput()
 		if err != nil {
@@ -172,18 +336,10 @@ func (s *Server) Run(ctx context.Context) error {
 		}
 	}
 
-	indexYamlPath := filepath.Join(worktree, "index.yaml")
-
-	if s.IsHelm3() {
-		return s.runHelm3(ctx, worktree, indexYamlPath, serverURL)
-	} else if s.IsHelm4() {
-		return s.runHelm4(ctx, worktree, indexYamlPath, serverURL)
-	}
-
-	return fmt.Errorf("unsupported helm version")
+	return nil
 }
 
-func (s *Server) runHelm3(ctx context.Context, worktree, indexYamlPath, serverURL string) error {
+func (s *Server) indexHelm3(worktree, indexYamlPath, serverURL string) error {
 	var indexFile *repov3.IndexFile
 	_, err := os.Stat(indexYamlPath)
 	if err == nil {
@@ -202,7 +358,6 @@ func (s *Server) runHelm3(ctx context.Context, worktree, indexYamlPath, serverUR
 		return err
 	}
 
-	var update bool
 	for _, chartPackage := range chartPackages {
 		_, err := loaderv3.LoadFile(chartPackage)
 		if err != nil {
@@ -220,16 +375,19 @@ func (s *Server) runHelm3(ctx context.Context, worktree, indexYamlPath, serverUR
 			if err := addToIndexFileHelm3(worktree, indexFile, downloadUrl.String()); err != nil {
 				return err
 			}
-			update = true
+		}
+		if s.Faults[downloadUrl.Path].CorruptDigest {
+			cv, err := indexFile.Get(packageName, packageVersion)
+			if err != nil {
+				return err
+			}
+			cv.Digest = corruptDigest(cv.Digest)
 		}
 	}
 
-	if !update {
-		fmt.Printf("Index %s did not change\n", indexYamlPath)
-		return nil
-	}
-
-	fmt.Printf("Updating index %s\n", indexYamlPath)
+	// The index is written even when it did not change, so that the server can always serve it
+	// regardless of whether the charts directory contained any chart.
+	fmt.Printf("Writing index %s\n", indexYamlPath)
 	indexFile.SortEntries()
 
 	indexFile.Generated = time.Now()
@@ -238,10 +396,10 @@ func (s *Server) runHelm3(ctx context.Context, worktree, indexYamlPath, serverUR
 		return err
 	}
 
-	return s.startHTTPServer(ctx, worktree, indexYamlPath)
+	return nil
 }
 
-func (s *Server) runHelm4(ctx context.Context, worktree, indexYamlPath, serverURL string) error {
+func (s *Server) indexHelm4(worktree, indexYamlPath, serverURL string) error {
 	var indexFile *repov4.IndexFile
 	_, err := os.Stat(indexYamlPath)
 	if err == nil {
@@ -260,7 +418,6 @@ func (s *Server) runHelm4(ctx context.Context, worktree, indexYamlPath, serverUR
 		return err
 	}
 
-	var update bool
 	for _, chartPackage := range chartPackages {
 		_, err := loaderv4.LoadFile(chartPackage)
 		if err != nil {
@@ -278,16 +435,19 @@ func (s *Server) runHelm4(ctx context.Context, worktree, indexYamlPath, serverUR
 			if err := addToIndexFileHelm4(worktree, indexFile, downloadUrl.String()); err != nil {
 				return err
 			}
-			update = true
+		}
+		if s.Faults[downloadUrl.Path].CorruptDigest {
+			cv, err := indexFile.Get(packageName, packageVersion)
+			if err != nil {
+				return err
+			}
+			cv.Digest = corruptDigest(cv.Digest)
 		}
 	}
 
-	if !update {
-		fmt.Printf("Index %s did not change\n", indexYamlPath)
-		return nil
-	}
-
-	fmt.Printf("Updating index %s\n", indexYamlPath)
+	// The index is written even when it did not change, so that the server can always serve it
+	// regardless of whether the charts directory contained any chart.
+	fmt.Printf("Writing index %s\n", indexYamlPath)
 	indexFile.SortEntries()
 
 	indexFile.Generated = time.Now()
@@ -296,13 +456,11 @@ func (s *Server) runHelm4(ctx context.Context, worktree, indexYamlPath, serverUR
 		return err
 	}
 
-	return s.startHTTPServer(ctx, worktree, indexYamlPath)
+	return nil
 }
 
-func (s *Server) startHTTPServer(ctx context.Context, worktree, indexYamlPath string) error {
-	port := s.getPort()
-
-	var serveMux http.ServeMux
+func (s *Server) newHandler(worktree, indexYamlPath string) http.Handler {
+	serveMux := http.NewServeMux()
 
 	serveMux.HandleFunc("/index.yaml", func(w http.ResponseWriter, _ *http.Request) {
 		f, err := os.Open(indexYamlPath)
@@ -311,6 +469,11 @@ func (s *Server) startHTTPServer(ctx context.Context, worktree, indexYamlPath st
 			_, _ = w.Write([]byte(err.Error()))
 			return
 		}
+		defer func() {
+			_ = f.Close()
+		}()
+
+		setContentLength(w, f)
 
 		if _, err := io.Copy(w, f); err != nil {
 			_, _ = w.Write([]byte(err.Error()))
@@ -319,7 +482,7 @@ func (s *Server) startHTTPServer(ctx context.Context, worktree, indexYamlPath st
 	})
 
 	serveMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		if !strings.HasSuffix(r.URL.Path, ".tgz") {
+		if !strings.HasSuffix(r.URL.Path, ".tgz") && !strings.HasSuffix(r.URL.Path, ".tgz.prov") {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
@@ -329,11 +492,19 @@ func (s *Server) startHTTPServer(ctx context.Context, worktree, indexYamlPath st
 		pkgPath := filepath.Join(worktree, base)
 
 		f, err := os.Open(pkgPath)
-		if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
 			_, _ = w.Write([]byte(err.Error()))
 			return
 		}
+		defer func() {
+			_ = f.Close()
+		}()
+
+		setContentLength(w, f)
 
 		if _, err := io.Copy(w, f); err != nil {
 			_, _ = w.Write([]byte(err.Error()))
@@ -341,22 +512,15 @@ func (s *Server) startHTTPServer(ctx context.Context, worktree, indexYamlPath st
 		}
 	})
 
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
-		Handler: &serveMux,
-	}
-
-	go func() {
-		<-ctx.Done()
-
-		_ = server.Close()
-	}()
+	return serveMux
+}
 
-	if err := server.ListenAndServe(); err != nil {
-		return fmt.Errorf("starting server: %w", err)
+// setContentLength advertises the size of the file being served,
+// so that clients are able to detect truncated responses.
+func setContentLength(w http.ResponseWriter, f *os.File) {
+	if info, err := f.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
 	}
-
-	return nil
 }
 
 func splitPackageNameAndVersion(pkg string) []string {
@@ -394,6 +558,33 @@ func addToIndexFileHelm3(worktree string, indexFile *repov3.IndexFile, url strin
 	return nil
 }
 
+// chartMetadataHelm4 returns the metadata of the chart in the form Helm 4's index file accepts.
+// Charts of the apiVersions other than v1 and v2, like v3 charts introduced by Helm 4, are loaded into
+// types internal to Helm. Their metadata is read via the accessor and converted, as the repository index
+// uses the same set of fields for every apiVersion.
+func chartMetadataHelm4(charter chartv4.Charter) (*v2.Metadata, error) {
+	if c, ok := charter.(*v2.Chart); ok {
+		return c.Metadata, nil
+	}
+
+	accessor, err := chartv4.NewAccessor(charter)
+	if err != nil {
+		return nil, fmt.Errorf("reading chart metadata: %w", err)
+	}
+
+	data, err := json.Marshal(accessor.MetadataAsMap())
+	if err != nil {
+		return nil, fmt.Errorf("reading chart metadata: %w", err)
+	}
+
+	var md v2.Metadata
+	if err := json.Unmarshal(data, &md); err != nil {
+		return nil, fmt.Errorf("reading chart metadata: %w", err)
+	}
+
+	return &md, nil
+}
+
 func addToIndexFileHelm4(worktree string, indexFile *repov4.IndexFile, url string) error {
 	arch := filepath.Join(worktree, filepath.Base(url))
 
@@ -404,10 +595,9 @@ func addToIndexFileHelm4(worktree string, indexFile *repov4.IndexFile, url strin
 		return fmt.Errorf("%s is not a helm chart package: %w", arch, err)
 	}
 
-	// Convert Charter to v2.Chart to access metadata
-	c, ok := charter.(*v2.Chart)
-	if !ok {
-		return fmt.Errorf("chart is not a v2 chart")
+	md, err := chartMetadataHelm4(charter)
+	if err != nil {
+		return fmt.Errorf("%s: %w", arch, err)
 	}
 
 	// calculate hash
@@ -424,7 +614,7 @@ func addToIndexFileHelm4(worktree string, indexFile *repov4.IndexFile, url strin
 	s = s[:len(s)-1]
 
 	// Add to index
-	if err := indexFile.MustAdd(c.Metadata, filepath.Base(arch), strings.Join(s, "/"), hash); err != nil {
+	if err := indexFile.MustAdd(md, filepath.Base(arch), strings.Join(s, "/"), hash); err != nil {
 		return err
 	}
 	return nil
@@ -1,12 +1,19 @@
 package chartrepo
 
 import (
+	"archive/tar"
+	"compress/gzip"
 	"context"
+	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	repov4 "helm.sh/helm/v4/pkg/repo/v1"
 )
 
 func TestServer_detectHelmVersion(t *testing.T) {
@@ -87,7 +94,7 @@ func TestServer_Run_Helm3And4(t *testing.T) {
 	chartsDir := "../testdata/charts"
 
 	s := &Server{
-		Port:      0, // Use default port
+		Port:      RandomPort,
 		ChartsDir: chartsDir,
 		HelmBin:   helmBin,
 	}
@@ -111,14 +118,96 @@ func TestServer_Run_Helm3And4(t *testing.T) {
 	select {
 	case err := <-errChan:
 		// Server should stop gracefully when context is canceled
-		if err != nil && err.Error() != "starting server: http: Server closed" {
-			t.Logf("Server stopped with: %v", err)
-		}
+		require.NoError(t, err)
 	case <-time.After(3 * time.Second):
 		t.Fatal("Server did not stop in time")
 	}
 }
 
+func TestServer_Start(t *testing.T) {
+	helmBin := "helm"
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helmBin = h
+	}
+
+	tests := []struct {
+		name      string
+		chartsDir string
+		wantChart string
+	}{
+		{
+			name:      "charts dir with charts",
+			chartsDir: "../testdata/charts",
+			wantChart: "db-0.1.0.tgz",
+		},
+		{
+			name:      "empty charts dir",
+			chartsDir: t.TempDir(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				Port:      RandomPort,
+				ChartsDir: tt.chartsDir,
+				HelmBin:   helmBin,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			serverURL, err := s.Start(ctx)
+			require.NoError(t, err)
+			require.Equal(t, serverURL, s.ServerURL())
+
+			u, err := url.Parse(serverURL)
+			require.NoError(t, err)
+			require.NotEqual(t, "0", u.Port(), "should listen on a random free port")
+
+			// The server must be ready to serve as soon as Start returns
+			res, err := http.Get(serverURL + "index.yaml")
+			require.NoError(t, err)
+			index, err := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			if tt.wantChart != "" {
+				require.Contains(t, string(index), serverURL+tt.wantChart)
+
+				res, err := http.Get(serverURL + tt.wantChart)
+				require.NoError(t, err)
+				_ = res.Body.Close()
+				require.Equal(t, http.StatusOK, res.StatusCode)
+			}
+
+			cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- s.Wait()
+			}()
+
+			select {
+			case err := <-done:
+				require.NoError(t, err)
+			case <-time.After(ShutdownTimeout + time.Second):
+				t.Fatal("Server did not stop in time")
+			}
+
+			_, err = http.Get(serverURL + "index.yaml")
+			require.Error(t, err, "server should not accept connections after shutdown")
+		})
+	}
+}
+
+func TestServer_Wait_NotStarted(t *testing.T) {
+	s := &Server{}
+
+	require.Error(t, s.Wait())
+}
+
 func TestServer_EnvVarOverride(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -202,3 +291,153 @@ func TestSplitPackageNameAndVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_Start_Signed(t *testing.T) {
+	helmBin := "helm"
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helmBin = h
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		keyring  string
+		wantCode int
+	}{
+		{
+			name:     "signed",
+			key:      "chartify-test",
+			keyring:  "../testdata/keys/secring.gpg",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "unsigned",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{
+				Port:           RandomPort,
+				ChartsDir:      "../testdata/charts",
+				HelmBin:        helmBin,
+				SigningKey:     tt.key,
+				SigningKeyring: tt.keyring,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			serverURL, err := s.Start(ctx)
+			require.NoError(t, err)
+
+			res, err := http.Get(serverURL + "log-0.1.0.tgz.prov")
+			require.NoError(t, err)
+			prov, err := io.ReadAll(res.Body)
+			_ = res.Body.Close()
+			require.NoError(t, err)
+			require.Equal(t, tt.wantCode, res.StatusCode)
+
+			if tt.wantCode == http.StatusOK {
+				require.Contains(t, string(prov), "-----BEGIN PGP SIGNATURE-----")
+			}
+
+			cancel()
+			require.NoError(t, s.Wait())
+		})
+	}
+}
+
+func TestAddToIndexFileHelm4_ChartAPIVersions(t *testing.T) {
+	for _, apiVersion := range []string{"v1", "v2", "v3"} {
+		t.Run(apiVersion, func(t *testing.T) {
+			worktree := t.TempDir()
+
+			writeChartArchive(t, filepath.Join(worktree, "mychart-0.1.0.tgz"), map[string]string{
+				"mychart/Chart.yaml":        "apiVersion: " + apiVersion + "\nname: mychart\nversion: 0.1.0\nappVersion: 1.2.3\n",
+				"mychart/templates/cm.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\n",
+			})
+
+			indexFile := repov4.NewIndexFile()
+			require.NoError(t, addToIndexFileHelm4(worktree, indexFile, "http://localhost:18080/mychart-0.1.0.tgz"))
+
+			cv, err := indexFile.Get("mychart", "0.1.0")
+			require.NoError(t, err)
+			require.Equal(t, apiVersion, cv.APIVersion)
+			require.Equal(t, "1.2.3", cv.AppVersion)
+			require.Equal(t, []string{"http://localhost:18080/mychart-0.1.0.tgz"}, cv.URLs)
+		})
+	}
+}
+
+func writeChartArchive(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, f.Close())
+	}()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
+
+func TestServer_ServerURL(t *testing.T) {
+	for _, tc := range []struct {
+		server Server
+		want   string
+	}{
+		{Server{}, "http://localhost:18080/"},
+		{Server{Port: 8080}, "http://localhost:8080/"},
+		{Server{Host: "charts.example.com"}, "http://charts.example.com:18080/"},
+		{Server{Host: "charts.example.com:8080", Port: 9090}, "http://charts.example.com:8080/"},
+		{Server{Port: RandomPort}, ""},
+		{Server{Host: "127.0.0.1", Port: RandomPort}, ""},
+	} {
+		require.Equal(t, tc.want, tc.server.ServerURL(), "%+v", tc.server)
+	}
+}
+
+func TestServer_Start_Host(t *testing.T) {
+	helmBin := "helm"
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helmBin = h
+	}
+
+	s := &Server{
+		Port:      RandomPort,
+		Host:      "127.0.0.1",
+		ChartsDir: t.TempDir(),
+		HelmBin:   helmBin,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	serverURL, err := s.Start(ctx)
+	require.NoError(t, err)
+	require.Equal(t, serverURL, s.ServerURL())
+
+	u, err := url.Parse(serverURL)
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1", u.Hostname(), "the URL must point to the address the server listens on")
+
+	res, err := http.Get(serverURL + "index.yaml")
+	require.NoError(t, err)
+	_ = res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	cancel()
+	require.NoError(t, s.Wait())
+}
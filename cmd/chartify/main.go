@@ -23,6 +23,7 @@ func main() {
 	var (
 		file                string
 		outDir              string
+		crdsOutDir          string
 		strategicMergePatch string
 	)
 
@@ -47,16 +48,51 @@ func main() {
 	deps := stringSlice{}
 	kustomizeBuildArgs := stringSlice{}
 	patches := stringSlice{}
+	hookPatches := stringSlice{}
+	exposedValues := stringSlice{}
+	mergePatches := stringSlice{}
+	fieldSetters := stringSlice{}
 
 	flag.StringVar(&file, "f", "-", "The path to the input file or stdout(-)")
 	flag.StringVar(&outDir, "o", "", "The path to the output directory")
+	flag.StringVar(&crdsOutDir, "crds-o", "", "The path to the output directory for the companion chart containing only CRDs. When set, CRDs are moved out of the chart written to -o")
 	flag.Var(&deps, "d", "one or more \"alias=chart:version\" to add adhoc chart dependencies")
 	flag.BoolVar(&opts.IncludeCRDs, "include-crds", false, "Whether to render CRDs contained in the chart and include the results into the output")
+	flag.BoolVar(&opts.Verify, "verify", false, "Verify the provenance of the chart and its adhoc dependencies before chartifying it")
+	flag.StringVar(&opts.Keyring, "keyring", "", "The path to the keyring containing public keys used to verify charts. Defaults to helm's default keyring")
+	flag.StringVar((*string)(&opts.CRDPlacement), "crd-placement", "", "Where to put CRDs after patching. One of preserve, crds-dir, templates, or separate-chart")
+	flag.BoolVar(&opts.PreserveFileLayout, "preserve-file-layout", false, "Write patched resources back to the chart files they were rendered from, instead of merging them into a single file")
 	flag.StringVar(&strategicMergePatch, "strategic-merge-patch", "", "Path to a kustomize strategic merge patch file")
 	flag.Var(&kustomizeBuildArgs, "kustomize-build-arg", "Extra arguments to pass to 'kustomize build' command (e.g. --enable-exec). Can be specified multiple times.")
 	flag.Var(&patches, "patch", "Path to a kustomize unified \"patches:\" entry file. Each file may contain a single patch document or a list of patch documents (inline \"patch:\" content or external \"path:\" reference). See https://github.com/kubernetes-sigs/kustomize/blob/master/examples/inlinePatch.md. Can be specified multiple times.")
 
-	flag.Parse()
+	flag.Var(&mergePatches, "merge-patch", "Path to a file containing a JSON merge patch (RFC 7386) under \"patch:\" and its \"target:\". Can be specified multiple times.")
+	flag.Var(&fieldSetters, "set-field", "\"[KIND[/NAME]:]PATH=VALUE\" to set the field at PATH of the matching resources to the YAML VALUE, like \"Deployment/db:spec.template.spec.containers[0].image=postgres:16\". Sequence items can also be selected by a field, like \"Deployment/db:spec.template.spec.containers[?(@.name=='db')].image=postgres:16\". Can be specified multiple times.")
+	flag.Var(&hookPatches, "hook-patch", "Like -patch, but the patches are applied only to Helm hooks. Every patch must have a target. Can be specified multiple times.")
+	flag.StringVar(&opts.ChartAPIVersion, "chart-api-version", "", "The apiVersion of Chart.yaml of the generated chart, like v2 or v3. Defaults to the one of the input chart, or v2")
+	flag.BoolVar(&opts.EscapeTemplates, "escape-templates", false, "Keep rendered manifests in templates/ with Go template delimiters escaped, instead of moving them under files/ and reading them with .Files.Get")
+	flag.BoolVar(&opts.PermissiveValuesSchema, "permissive-values-schema", false, "Make the values.schema.json of the chart generated from K8s manifests or kustomizations accept values other than the ones given to chartify")
+	flag.BoolVar(&opts.DropTestHooks, "drop-test-hooks", false, "Remove Helm test hooks from the generated chart")
+	flag.BoolVar(&opts.SeparateHookFiles, "separate-hook-files", false, "Write patched Helm hooks and test hooks to their own files, instead of merging them into a single file with the other resources")
+	flag.BoolVar(&opts.FailOnUnmatchedPatch, "fail-on-unmatched-patch", false, "Fail when any patch or transformer with a target matches no resources")
+	flag.StringVar((*string)(&opts.PatchEngine), "patch-engine", "", "What applies the patches, either kustomize or native. native applies JSON and strategic merge patches without kustomize")
+	flag.StringVar((*string)(&opts.InputKind), "input-kind", "", "Treat the input as the kind instead of detecting it, one of chart, kustomize, jsonnet and manifests")
+	flag.Var(&exposedValues, "expose-value", "\"KEY=[KIND[/NAME]:]PATH\" to keep the field at PATH of the rendered resources overridable as the value KEY of the generated chart, like \"db.replicas=Deployment/db:spec.replicas\". Can be specified multiple times.")
+
+	// `chartify render [flags] RELEASE CHART` writes the rendered resources to stdout instead of generating a chart,
+	// and `chartify diff [flags] RELEASE CHART` writes the changes chartify makes to the resources rendered from the input.
+	args := os.Args[1:]
+	var mode string
+	if len(args) > 0 && (args[0] == "render" || args[0] == "diff") {
+		mode, args = args[0], args[1:]
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage:\n  %[1]s [flags] -o OUTPUT_DIR RELEASE CHART\n  %[1]s render [flags] RELEASE CHART\n  %[1]s diff [flags] RELEASE CHART\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	_ = flag.CommandLine.Parse(args)
 
 	if file != "" {
 		opts.ValuesFiles = append(opts.ValuesFiles, file)
@@ -69,10 +105,30 @@ func main() {
 	opts.DeprecatedAdhocChartDependencies = deps
 	opts.KustomizeBuildArgs = kustomizeBuildArgs
 	opts.Patches = patches
+	opts.HookPatches = hookPatches
+	opts.MergePatches = mergePatches
+
+	for _, s := range fieldSetters {
+		f, err := chartify.ParseFieldSetter(s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.FieldSetters = append(opts.FieldSetters, f)
+	}
+
+	for _, v := range exposedValues {
+		e, err := chartify.ParseExposedValue(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.ExposedValues = append(opts.ExposedValues, e)
+	}
 
 	c := chartify.New(chartify.HelmBin("helm"))
 
-	args := flag.Args()
+	args = flag.Args()
 
 	if len(args) != 2 {
 		fmt.Fprintf(os.Stderr, "Error: exactly 2 arguments has been expected. Got %d (%+v)\n", len(args), args)
@@ -80,12 +136,63 @@ func main() {
 		os.Exit(1)
 	}
 
+	switch mode {
+	case "render":
+		resources, err := c.Render(args[0], args[1], chartify.WithChartifyOpts(&opts))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := chartify.WriteResources(os.Stdout, resources); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	case "diff":
+		diff, err := c.Diff(args[0], args[1], chartify.WithChartifyOpts(&opts))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := diff.Write(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	if outDir == "" {
 		fmt.Fprintf(os.Stderr, "Error: -o OUTPUT_DIR is required but missing\n")
 
 		os.Exit(1)
 	}
 
+	if crdsOutDir != "" {
+		charts, err := c.ChartifySplit(args[0], args[1], chartify.WithChartifyOpts(&opts))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if charts.CRDsChart != "" {
+			if err := os.Rename(charts.CRDsChart, crdsOutDir); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: moving %s to %s: %v\n", charts.CRDsChart, crdsOutDir, err)
+				os.Exit(1)
+			}
+		}
+
+		if err := os.Rename(charts.Chart, outDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: moving %s to %s: %v\n", charts.Chart, outDir, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	generatedDir, err := c.Chartify(args[0], args[1], chartify.WithChartifyOpts(&opts))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
@@ -0,0 +1,227 @@
+package chartify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CRDPlacement determines where chartify puts CustomResourceDefinitions after patching.
+//
+// Helm treats CRDs differently depending on where they are:
+// - crds/: Install-only, immutable on upgrade, not deleted on uninstall
+// - templates/: Regular resources, updated on upgrade, deleted on uninstall
+type CRDPlacement string
+
+const (
+	// CRDPlacementPreserve keeps every CRD where it came from, so that CRDs rendered from templates/
+	// (e.g. templates/crds/) stay in templates/ and CRDs from crds/ go to crds/.
+	// CRDs that weren't rendered from the chart, like ones generated by transformers, go to crds/.
+	// CRDs renamed by the patches stay where they came from, as chartify tracks them with the OriginAnnotation.
+	// This is the default.
+	// See https://github.com/helmfile/helmfile/issues/2291
+	CRDPlacementPreserve CRDPlacement = "preserve"
+
+	// CRDPlacementCRDsDir puts all the CRDs into crds/, making them install-only.
+	CRDPlacementCRDsDir CRDPlacement = "crds-dir"
+
+	// CRDPlacementTemplates puts all the CRDs into templates/crds/, so that they are upgraded along with the release.
+	CRDPlacementTemplates CRDPlacement = "templates"
+
+	// CRDPlacementSeparateChart moves all the CRDs out of the chart into a companion chart,
+	// so that they can be installed as a dedicated release before the main one.
+	// See CRDsChartPath for where the companion chart is written.
+	CRDPlacementSeparateChart CRDPlacement = "separate-chart"
+)
+
+// Validate returns an error if the placement is not one of the known values.
+func (p CRDPlacement) Validate() error {
+	switch p {
+	case "", CRDPlacementPreserve, CRDPlacementCRDsDir, CRDPlacementTemplates, CRDPlacementSeparateChart:
+		return nil
+	default:
+		return fmt.Errorf("unsupported CRDPlacement %q: it must be one of %q, %q, %q, or %q",
+			string(p), CRDPlacementPreserve, CRDPlacementCRDsDir, CRDPlacementTemplates, CRDPlacementSeparateChart)
+	}
+}
+
+// crdsChartDirName is the name of the directory within the generated chart to which the companion chart is written.
+const crdsChartDirName = ".crds-chart"
+
+// CRDsChartPath returns the path to the companion chart that contains the CRDs moved out of the chart at chartPath,
+// when the chart was generated with CRDPlacementSeparateChart.
+// The companion chart lives within the chart, so that removing the chart removes it too,
+// and is listed in the .helmignore of the chart, so that Helm never installs it along with the chart.
+func CRDsChartPath(chartPath string) string {
+	return filepath.Join(chartPath, crdsChartDirName)
+}
+
+// crdDir returns the directory to which a patched CRD rendered from origin is written.
+// origin is the path to the file the CRD was rendered from, relative to chartDir,
+// or empty if the CRD wasn't rendered from the chart.
+func (r *Runner) crdDir(chartDir, origin string, placement CRDPlacement) string {
+	switch placement {
+	case CRDPlacementCRDsDir:
+		return r.HelmAdapter().CRDsDir(chartDir)
+	case CRDPlacementTemplates:
+		return filepath.Join(chartDir, "templates", "crds")
+	case CRDPlacementSeparateChart:
+		return filepath.Join(CRDsChartPath(chartDir), "templates")
+	}
+
+	if isTemplateOrigin(origin) {
+		// Preserve the original location to maintain the chart author's intent.
+		// CRDs in templates/ are likely placed there intentionally for:
+		// - Conditional rendering with {{- if .Values.crds.install }}
+		// - Using template features like .Release.Namespace
+		// - Requiring CRD updates during helm upgrade
+		return filepath.Join(chartDir, filepath.Dir(filepath.FromSlash(origin)))
+	}
+
+	return r.HelmAdapter().CRDsDir(chartDir)
+}
+
+// isTemplateOrigin returns true when the origin is a file under the templates/ directory of the chart
+// or any of its subcharts, like templates/crds/foo.yaml or charts/SUBCHART/templates/foo.yaml.
+func isTemplateOrigin(origin string) bool {
+	for {
+		if strings.HasPrefix(origin, "templates/") {
+			return true
+		}
+
+		rest, ok := strings.CutPrefix(origin, "charts/")
+		if !ok {
+			return false
+		}
+
+		_, origin, ok = strings.Cut(rest, "/")
+		if !ok {
+			return false
+		}
+	}
+}
+
+// writeCRDsChart turns the directory containing the CRDs moved out of the chart at chartPath
+// into a chart on its own, named after the original chart with the "-crds" suffix.
+// It does nothing when no CRDs were moved out of the chart.
+// escape is the same as ChartifyOpts.EscapeTemplates, and strict is the negation of ChartifyOpts.PermissiveValuesSchema.
+func (r *Runner) writeCRDsChart(chartPath string, escape, strict bool) error {
+	crdsChartPath := CRDsChartPath(chartPath)
+
+	if _, err := os.Stat(crdsChartPath); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	chartMeta, err := r.readChartMetadata(filepath.Join(chartPath, "Chart.yaml"))
+	if err != nil {
+		return err
+	}
+
+	name, _ := chartMeta.Data["name"].(string)
+	version := fmt.Sprint(chartMeta.Data["version"])
+	if chartMeta.Data["version"] == nil {
+		version = "1.0.0"
+	}
+
+	// The companion chart is in the same format as the chart
+	apiVersion := chartMeta.chartAPIVersion()
+
+	chartYamlContent := fmt.Sprintf("name: %q\nversion: %s\napiVersion: %s\ndescription: %q\n", name+"-crds", version, apiVersion, "CustomResourceDefinitions of the "+name+" chart")
+	if err := r.WriteFile(filepath.Join(crdsChartPath, "Chart.yaml"), []byte(chartYamlContent), 0644); err != nil {
+		return err
+	}
+
+	if err := r.writeValuesSchema(crdsChartPath, true, strict, nil, nil, nil); err != nil {
+		return err
+	}
+
+	if err := r.preventDoubleRendering(crdsChartPath, escape, nil); err != nil {
+		return err
+	}
+
+	return r.helmIgnore(chartPath, crdsChartDirName+"/")
+}
+
+// helmIgnore appends the pattern to the .helmignore of the chart at chartPath unless it is already there,
+// creating the .helmignore when missing.
+func (r *Runner) helmIgnore(chartPath, pattern string) error {
+	helmignore := filepath.Join(chartPath, ".helmignore")
+
+	exists, err := r.Exists(helmignore)
+	if err != nil {
+		return err
+	}
+
+	var content []byte
+	if exists {
+		content, err = r.ReadFile(helmignore)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.TrimSpace(line) == pattern {
+			return nil
+		}
+	}
+
+	if len(content) > 0 && !bytes.HasSuffix(content, []byte("\n")) {
+		content = append(content, '\n')
+	}
+
+	content = append(content, pattern+"\n"...)
+
+	return r.WriteFile(helmignore, content, 0644)
+}
+
+// SplitCharts is the pair of charts generated by ChartifySplit.
+type SplitCharts struct {
+	// Chart is the path to the chart containing everything but CustomResourceDefinitions.
+	Chart string
+
+	// CRDsChart is the path to the companion chart containing only CustomResourceDefinitions.
+	// It is empty when the input contained no CRDs.
+	// It is within Chart and ignored by Helm when installing Chart, so removing Chart removes it too.
+	CRDsChart string
+}
+
+// ChartifySplit is like Chartify, but emits two charts from the input. One contains only the CustomResourceDefinitions
+// from crds/, templates/ and subcharts of the input, and the other contains everything else.
+// This allows installing CRDs as a dedicated release before the main one.
+//
+// It always renders CRDs as if ChartifyOpts.IncludeCRDs were set, and ignores ChartifyOpts.CRDPlacement.
+func (r *Runner) ChartifySplit(release, dirOrChart string, opts ...ChartifyOption) (*SplitCharts, error) {
+	u := &ChartifyOpts{}
+
+	for i := range opts {
+		if err := opts[i].SetChartifyOption(u); err != nil {
+			return nil, err
+		}
+	}
+
+	u.IncludeCRDs = true
+	u.CRDPlacement = CRDPlacementSeparateChart
+
+	chart, err := r.Chartify(release, dirOrChart, WithChartifyOpts(u))
+	if err != nil {
+		return nil, err
+	}
+
+	charts := &SplitCharts{Chart: chart}
+
+	if crdsChart := CRDsChartPath(chart); r.dirExists(crdsChart) {
+		charts.CRDsChart = crdsChart
+	}
+
+	return charts, nil
+}
+
+func (r *Runner) dirExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
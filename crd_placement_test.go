@@ -0,0 +1,330 @@
+package chartify
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsTemplateOrigin(t *testing.T) {
+	require.True(t, isTemplateOrigin("templates/crds/foo.yaml"))
+	require.True(t, isTemplateOrigin("templates/foo.yaml"))
+	require.True(t, isTemplateOrigin("charts/sub/templates/foo.yaml"))
+	require.True(t, isTemplateOrigin("charts/sub/charts/subsub/templates/crds/foo.yaml"))
+	require.False(t, isTemplateOrigin(""))
+	require.False(t, isTemplateOrigin("crds/foo.yaml"))
+	require.False(t, isTemplateOrigin("charts/sub/crds/foo.yaml"))
+	require.False(t, isTemplateOrigin("charts/templates"))
+}
+
+func TestCRDPlacement_Validate(t *testing.T) {
+	for _, p := range []CRDPlacement{"", CRDPlacementPreserve, CRDPlacementCRDsDir, CRDPlacementTemplates, CRDPlacementSeparateChart} {
+		require.NoError(t, p.Validate())
+	}
+
+	err := CRDPlacement("crds").Validate()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `unsupported CRDPlacement "crds"`)
+}
+
+func TestRunner_crdDir(t *testing.T) {
+	r := New(UseHelm3(true))
+
+	tests := []struct {
+		placement CRDPlacement
+		origin    string
+		want      string
+	}{
+		{"", "templates/crds/a.yaml", filepath.Join("chart", "templates", "crds")},
+		{CRDPlacementPreserve, "charts/sub/templates/a.yaml", filepath.Join("chart", "charts", "sub", "templates")},
+		{CRDPlacementPreserve, "crds/a.yaml", filepath.Join("chart", "crds")},
+		{CRDPlacementPreserve, "", filepath.Join("chart", "crds")},
+		{CRDPlacementCRDsDir, "templates/crds/a.yaml", filepath.Join("chart", "crds")},
+		{CRDPlacementTemplates, "crds/a.yaml", filepath.Join("chart", "templates", "crds")},
+		{CRDPlacementSeparateChart, "crds/a.yaml", filepath.Join("chart", ".crds-chart", "templates")},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, r.crdDir("chart", tt.origin, tt.placement), "placement=%q origin=%q", tt.placement, tt.origin)
+	}
+}
+
+func TestChartify_CRDPlacement(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	chart := t.TempDir()
+	writeTestFiles(t, chart, map[string]string{
+		"Chart.yaml":               "apiVersion: v2\nname: mixed\nversion: 0.2.0\n",
+		"crds/a.yaml":              testCRD("as.example.com"),
+		"templates/crds/b.yaml":    testCRD("bs.example.com"),
+		"templates/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\ndata:\n  patched: \"false\"\n",
+	})
+
+	patch := filepath.Join(t.TempDir(), "patch.yaml")
+	require.NoError(t, os.WriteFile(patch, []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\ndata:\n  patched: \"true\"\n"), 0644))
+
+	tests := []struct {
+		placement CRDPlacement
+		// want maps the paths to the patched CRDs files to the CRDs they should contain
+		want map[string][]string
+		// wantCRDsChart maps the paths in the companion chart to the CRDs they should contain
+		wantCRDsChart map[string][]string
+	}{
+		{
+			placement: CRDPlacementPreserve,
+			want: map[string][]string{
+				"crds/patched_crds.yaml":                 {"as.example.com"},
+				"files/templates/crds/patched_crds.yaml": {"bs.example.com"},
+			},
+		},
+		{
+			placement: CRDPlacementCRDsDir,
+			want: map[string][]string{
+				"crds/patched_crds.yaml": {"as.example.com", "bs.example.com"},
+			},
+		},
+		{
+			placement: CRDPlacementTemplates,
+			want: map[string][]string{
+				"files/templates/crds/patched_crds.yaml": {"as.example.com", "bs.example.com"},
+			},
+		},
+		{
+			placement: CRDPlacementSeparateChart,
+			want:      map[string][]string{},
+			wantCRDsChart: map[string][]string{
+				"files/templates/patched_crds.yaml": {"as.example.com", "bs.example.com"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.placement), func(t *testing.T) {
+			r := New(HelmBin(helm), WithLogf(t.Logf))
+
+			tmpDir, err := r.Chartify("myapp", chart, WithChartifyOpts(&ChartifyOpts{
+				IncludeCRDs:           true,
+				StrategicMergePatches: []string{patch},
+				CRDPlacement:          tt.placement,
+			}))
+			t.Cleanup(func() {
+				_ = os.RemoveAll(tmpDir)
+			})
+			require.NoError(t, err)
+
+			require.Equal(t, tt.want, findCRDs(t, tmpDir))
+
+			if tt.wantCRDsChart == nil {
+				require.NoDirExists(t, CRDsChartPath(tmpDir))
+				return
+			}
+
+			require.Equal(t, tt.wantCRDsChart, findCRDs(t, CRDsChartPath(tmpDir)))
+
+			chartYaml, err := os.ReadFile(filepath.Join(CRDsChartPath(tmpDir), "Chart.yaml"))
+			require.NoError(t, err)
+			require.Contains(t, string(chartYaml), `name: "mixed-crds"`)
+			require.Contains(t, string(chartYaml), "version: 0.2.0")
+
+			template, err := os.ReadFile(filepath.Join(CRDsChartPath(tmpDir), "templates", "patched_crds.yaml"))
+			require.NoError(t, err)
+			require.Equal(t, `{{ .Files.Get "files/templates/patched_crds.yaml" }}`, string(template))
+
+			helmignore, err := os.ReadFile(filepath.Join(tmpDir, ".helmignore"))
+			require.NoError(t, err)
+			require.Contains(t, string(helmignore), ".crds-chart/\n")
+		})
+	}
+}
+
+func TestChartify_CRDPlacement_RenamedCRD(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	chart := t.TempDir()
+	writeTestFiles(t, chart, map[string]string{
+		"Chart.yaml":            "apiVersion: v2\nname: renamed\nversion: 0.1.0\n",
+		"templates/crds/b.yaml": testCRD("bs.example.com"),
+	})
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	// The CRD stays in templates/ even though the field setter changes its name, and thus its ID
+	tmpDir, err := r.Chartify("myapp", chart, WithChartifyOpts(&ChartifyOpts{
+		FieldSetters: []FieldSetter{{Target: PatchTarget{Kind: "CustomResourceDefinition"}, Path: "metadata.name", Value: "cs.example.com"}},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, map[string][]string{
+		"files/templates/crds/patched_crds.yaml": {"cs.example.com"},
+	}, findCRDs(t, tmpDir))
+
+	crds, err := os.ReadFile(filepath.Join(tmpDir, "files", "templates", "crds", "patched_crds.yaml"))
+	require.NoError(t, err)
+	require.NotContains(t, string(crds), OriginAnnotation)
+}
+
+func TestChartify_InvalidCRDPlacement(t *testing.T) {
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	_, err := r.Chartify("myapp", "testdata/charts/db", WithChartifyOpts(&ChartifyOpts{CRDPlacement: "nowhere"}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `unsupported CRDPlacement "nowhere"`)
+}
+
+func testCRD(name string) string {
+	return `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: ` + name + `
+spec:
+  group: example.com
+  names:
+    kind: Example
+    plural: examples
+  scope: Namespaced
+  versions:
+  - name: v1
+    served: true
+    storage: true
+`
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for path, content := range files {
+		f := filepath.Join(dir, filepath.FromSlash(path))
+		require.NoError(t, os.MkdirAll(filepath.Dir(f), 0755))
+		require.NoError(t, os.WriteFile(f, []byte(content), 0644))
+	}
+}
+
+// findCRDs returns the names of the CRDs contained in every file under dir, keyed by the path relative to dir.
+func findCRDs(t *testing.T, dir string) map[string][]string {
+	t.Helper()
+
+	found := map[string][]string{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// The companion chart is inspected separately
+		if info.IsDir() && path != dir && info.Name() == crdsChartDirName {
+			return filepath.SkipDir
+		}
+
+		if info.IsDir() || filepath.Ext(path) != ".yaml" || info.Name() == "Chart.yaml" {
+			return nil
+		}
+
+		resources, err := ReadResourcesFromFile(path)
+		if err != nil {
+			// e.g. templates containing {{ .Files.Get }}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		for _, res := range resources {
+			if res.Kind() == "CustomResourceDefinition" {
+				name := mappingValue(mappingValue(documentRoot(res.Node), "metadata"), "name").Value
+				found[filepath.ToSlash(rel)] = append(found[filepath.ToSlash(rel)], name)
+			}
+		}
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	return found
+}
+
+func TestChartifySplit(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	chart := t.TempDir()
+	writeTestFiles(t, chart, map[string]string{
+		"Chart.yaml":                        "apiVersion: v2\nname: app\nversion: 1.2.3\n",
+		"crds/a.yaml":                       testCRD("as.example.com"),
+		"templates/crds/b.yaml":             testCRD("bs.example.com"),
+		"templates/configmap.yaml":          "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app\n",
+		"charts/sub/Chart.yaml":             "apiVersion: v2\nname: sub\nversion: 0.1.0\n",
+		"charts/sub/crds/c.yaml":            testCRD("cs.example.com"),
+		"charts/sub/templates/d.yaml":       testCRD("ds.example.com"),
+		"charts/sub/templates/cm.yaml":      "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: sub\n",
+		"charts/sub/templates/_helpers.tpl": "{{- define \"sub.name\" -}}sub{{- end -}}\n",
+	})
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	charts, err := r.ChartifySplit("myapp", chart, WithChartifyOpts(&ChartifyOpts{}))
+	if charts != nil {
+		t.Cleanup(func() {
+			_ = os.RemoveAll(charts.Chart)
+			_ = os.RemoveAll(charts.CRDsChart)
+		})
+	}
+	require.NoError(t, err)
+	require.Equal(t, CRDsChartPath(charts.Chart), charts.CRDsChart)
+
+	require.Empty(t, findCRDs(t, charts.Chart))
+	require.Equal(t, map[string][]string{
+		"files/templates/patched_crds.yaml": {"as.example.com", "bs.example.com", "cs.example.com", "ds.example.com"},
+	}, sortedCRDs(findCRDs(t, charts.CRDsChart)))
+
+	out, err := exec.Command(helm, "template", "myapp", charts.Chart).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), "name: app")
+	require.Contains(t, string(out), "name: sub")
+	require.NotContains(t, string(out), "CustomResourceDefinition")
+
+	out, err = exec.Command(helm, "template", "myapp-crds", charts.CRDsChart).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Equal(t, 4, strings.Count(string(out), "kind: CustomResourceDefinition"))
+}
+
+func TestChartifySplit_NoCRDs(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	charts, err := r.ChartifySplit("myapp", "testdata/charts/db", WithChartifyOpts(&ChartifyOpts{}))
+	if charts != nil {
+		t.Cleanup(func() {
+			_ = os.RemoveAll(charts.Chart)
+		})
+	}
+	require.NoError(t, err)
+	require.Empty(t, charts.CRDsChart)
+	require.FileExists(t, filepath.Join(charts.Chart, "files", "templates", "patched_resources.yaml"))
+}
+
+func sortedCRDs(found map[string][]string) map[string][]string {
+	for _, names := range found {
+		sort.Strings(names)
+	}
+	return found
+}
@@ -0,0 +1,171 @@
+package chartify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// CueValuesField is the top-level field of CUE packages the values given to chartify are unified into.
+	// It is excluded from the exported manifests.
+	CueValuesField = "values"
+
+	// cueValuesFile is the file chartify writes into each CUE package to unify the values into it.
+	cueValuesFile = "chartify_values.cue"
+
+	// cueExportFile is the file each CUE package is exported to.
+	cueExportFile = "cue_export.yaml"
+)
+
+var cuePackageClause = regexp.MustCompile(`(?m)^package\s+([A-Za-z_#$][A-Za-z0-9_#$]*)\s*$`)
+
+type CueExportOpts struct {
+	ValuesFiles []string
+	SetValues   []string
+	SetFlags    []string
+}
+
+func (o *CueExportOpts) SetCueExportOption(opts *CueExportOpts) error {
+	*opts = *o
+	return nil
+}
+
+type CueExportOption interface {
+	SetCueExportOption(opts *CueExportOpts) error
+}
+
+// CueExport exports every CUE package found under dir to K8s manifests with `cue export`,
+// and returns the paths to the exported files, which are written as cue_export.yaml next to the packages.
+// The CUE files and cue.mod are removed afterwards, so that they don't end up in the chart.
+//
+// The values given via the values files and the set flags are merged like helm does, and unified into the `values` field of
+// each package, so that the package can refer to them like `replicas: values.replicas`, and even constrain them like
+// `values: replicas: int & >0`.
+//
+// Each package may evaluate to K8s resources, Lists of resources, or arrays and structs of them nested at any depth,
+// like `deployment: myapp: {...}`. Resources in structs are written in the order of their field names.
+func (r *Runner) CueExport(dir string, opts ...CueExportOption) ([]string, error) {
+	u := &CueExportOpts{}
+
+	for i := range opts {
+		if err := opts[i].SetCueExportOption(u); err != nil {
+			return nil, err
+		}
+	}
+
+	cueFiles, err := r.SearchFiles(SearchFileOpts{
+		basePath: dir,
+		fileType: []string{".cue"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var packageDirs []string
+
+	// Package names by package directory
+	packages := map[string]string{}
+
+	for _, f := range cueFiles {
+		if strings.Contains(filepath.ToSlash(f), "/cue.mod/") {
+			continue
+		}
+
+		d := filepath.Dir(f)
+		if _, ok := packages[d]; !ok {
+			packageDirs = append(packageDirs, d)
+			packages[d] = ""
+		}
+
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		if m := cuePackageClause.FindSubmatch(content); m != nil {
+			packages[d] = string(m[1])
+		}
+	}
+
+	if len(packageDirs) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(packageDirs)
+
+	vals, err := mergeGivenValues(u.ValuesFiles, append(setValuesFlags(u.SetValues), parseSetFlags(u.SetFlags)...))
+	if err != nil {
+		return nil, fmt.Errorf("merging values for CUE packages: %w", err)
+	}
+
+	valuesJSON, err := json.Marshal(vals)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling values for CUE packages: %w", err)
+	}
+
+	var exported []string
+
+	for _, d := range packageDirs {
+		// JSON is valid CUE
+		valuesCue := fmt.Sprintf("%s: %s\n", CueValuesField, valuesJSON)
+		if pkg := packages[d]; pkg != "" {
+			valuesCue = fmt.Sprintf("package %s\n\n%s", pkg, valuesCue)
+		}
+
+		if err := r.WriteFile(filepath.Join(d, cueValuesFile), []byte(valuesCue), 0644); err != nil {
+			return nil, err
+		}
+
+		r.Logf("Exporting CUE package in %s", d)
+
+		out, err := r.runInDir(d, r.cueBin(), "export", "--out", "json", ".")
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := decodeJSON([]byte(out))
+		if err != nil {
+			return nil, fmt.Errorf("parsing the output of cue export in %s: %w", d, err)
+		}
+
+		if m, ok := v.(map[string]interface{}); ok {
+			delete(m, CueValuesField)
+		}
+
+		resources, err := flattenJSONResources(v, "$")
+		if err != nil {
+			return nil, fmt.Errorf("exporting CUE package in %s: %w", d, err)
+		}
+
+		outputFile := filepath.Join(d, cueExportFile)
+
+		if err := r.writeResources(outputFile, resources); err != nil {
+			return nil, err
+		}
+
+		exported = append(exported, outputFile)
+	}
+
+	for _, f := range cueFiles {
+		if err := os.RemoveAll(f); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, d := range packageDirs {
+		if err := os.RemoveAll(filepath.Join(d, cueValuesFile)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, "cue.mod")); err != nil {
+		return nil, err
+	}
+
+	return exported, nil
+}
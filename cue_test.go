@@ -0,0 +1,144 @@
+package chartify
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCue is a cue command that exports a ConfigMap whose data is the values unified into the package,
+// and records the args and the values file to args.txt and values.cue next to itself.
+const fakeCue = `#!/bin/sh
+dir=$(dirname "$0")
+echo "$@" > "$dir/args.txt"
+cp chartify_values.cue "$dir/values.cue"
+values=$(sed -n 's/^values: //p' chartify_values.cue)
+printf '{"values": %s, "configMap": {"app": {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "%s"}, "data": %s}}}' "$values" "$(basename "$PWD")" "$values"
+`
+
+func TestRunner_CueExport(t *testing.T) {
+	bin := filepath.Join(t.TempDir(), "cue")
+	require.NoError(t, os.WriteFile(bin, []byte(fakeCue), 0755))
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"cue.mod/module.cue":    "module: \"example.com/app\"\n",
+		"cue.mod/pkg/lib/x.cue": "package lib\n",
+		"myapp/app.cue":         "// comment\npackage myapp\n\nconfigMap: app: {}\n",
+		"myapp/values.cue":      "package myapp\n\nvalues: foo: string\n",
+		"myapp/cm.yaml":         "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: plain\n",
+		"values/prod.yaml":      "foo: prod\n",
+	})
+
+	r := New(CueBin(bin), WithLogf(t.Logf))
+
+	exported, err := r.CueExport(dir, &CueExportOpts{
+		ValuesFiles: []string{filepath.Join(dir, "values", "prod.yaml")},
+		SetFlags:    []string{"--set bar=1"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "myapp", "cue_export.yaml")}, exported)
+
+	content, err := os.ReadFile(exported[0])
+	require.NoError(t, err)
+	require.Equal(t, `apiVersion: v1
+data:
+  bar: 1
+  foo: prod
+kind: ConfigMap
+metadata:
+  name: myapp
+`, string(content))
+
+	args, err := os.ReadFile(filepath.Join(filepath.Dir(bin), "args.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "export --out json .\n", string(args))
+
+	values, err := os.ReadFile(filepath.Join(filepath.Dir(bin), "values.cue"))
+	require.NoError(t, err)
+	require.Equal(t, "package myapp\n\nvalues: {\"bar\":1,\"foo\":\"prod\"}\n", string(values))
+
+	require.NoFileExists(t, filepath.Join(dir, "myapp", "app.cue"))
+	require.NoFileExists(t, filepath.Join(dir, "myapp", cueValuesFile))
+	require.NoDirExists(t, filepath.Join(dir, "cue.mod"))
+	require.FileExists(t, filepath.Join(dir, "myapp", "cm.yaml"))
+}
+
+func TestChartify_Cue(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	bin := filepath.Join(t.TempDir(), "cue")
+	require.NoError(t, os.WriteFile(bin, []byte(fakeCue), 0755))
+
+	srcDir := filepath.Join(t.TempDir(), "myapp")
+	writeTestFiles(t, srcDir, map[string]string{
+		"app.cue": "package myapp\n",
+		"cm.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: plain\n",
+	})
+
+	r := New(HelmBin(helm), CueBin(bin), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", srcDir, WithChartifyOpts(&ChartifyOpts{
+		Namespace: "ns",
+		SetFlags:  []string{"--set", "foo=bar"},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	out, err := exec.Command(helm, "template", "myapp", tmpDir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), "name: plain")
+	require.Contains(t, string(out), "foo: bar")
+	require.Contains(t, string(out), "namespace: ns")
+}
+
+func TestChartify_CueBinary(t *testing.T) {
+	cue, err := exec.LookPath("cue")
+	if err != nil {
+		t.Skip("cue is not installed")
+	}
+
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	srcDir := filepath.Join(t.TempDir(), "myapp")
+	writeTestFiles(t, srcDir, map[string]string{
+		"app.cue": `package myapp
+
+values: replicas: int | *1
+
+configMap: myapp: {
+	apiVersion: "v1"
+	kind:       "ConfigMap"
+	metadata: name: "myapp"
+	data: replicas: "\(values.replicas)"
+}
+`,
+	})
+
+	r := New(HelmBin(helm), CueBin(cue), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", srcDir, WithChartifyOpts(&ChartifyOpts{
+		SetFlags: []string{"--set", "replicas=3"},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	out, err := exec.Command(helm, "template", "myapp", tmpDir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), `replicas: "3"`)
+}
@@ -0,0 +1,565 @@
+package chartify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ResourceID identifies a K8s resource by its group, version, kind, namespace and name.
+type ResourceID struct {
+	APIVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+}
+
+func (id ResourceID) String() string {
+	name := id.Name
+	if id.Namespace != "" {
+		name = id.Namespace + "/" + name
+	}
+
+	return fmt.Sprintf("%s %s %s", id.APIVersion, id.Kind, name)
+}
+
+// ID returns the ID of the resource.
+func (r *Resource) ID() ResourceID {
+	root := documentRoot(r.Node)
+
+	id := ResourceID{Kind: r.Kind()}
+
+	if v := mappingValue(root, "apiVersion"); v != nil {
+		id.APIVersion = v.Value
+	}
+
+	if metadata := mappingValue(root, "metadata"); metadata != nil {
+		if v := mappingValue(metadata, "namespace"); v != nil {
+			id.Namespace = v.Value
+		}
+		if v := mappingValue(metadata, "name"); v != nil {
+			id.Name = v.Value
+		}
+	}
+
+	return id
+}
+
+// ChangeType is the type of the change made to a field.
+type ChangeType string
+
+const (
+	ChangeAdded    ChangeType = "+"
+	ChangeRemoved  ChangeType = "-"
+	ChangeModified ChangeType = "~"
+)
+
+// FieldChange is a change made to a field of a resource.
+type FieldChange struct {
+	Type ChangeType
+
+	// Path is the path to the field, like `spec.template.spec.containers[0].image`.
+	// Keys containing dots are quoted, like `metadata.annotations["helm.sh/hook"]`.
+	Path string
+
+	// Old is the value before the change. It is nil when the field is added.
+	Old interface{}
+
+	// New is the value after the change. It is nil when the field is removed.
+	New interface{}
+}
+
+// StageDiff is the changes a stage of chartify made to a resource.
+type StageDiff struct {
+	// Stage describes the stage, like `json patch path/to/patch.yaml` or `namespace override`.
+	Stage string
+
+	// Added is true when the resource is added by the stage.
+	Added bool
+
+	// Removed is true when the resource is removed by the stage.
+	Removed bool
+
+	// Changes are the changes made to the fields of the resource, in the order of the paths.
+	// It is empty when the resource is added or removed.
+	Changes []FieldChange
+}
+
+// ResourceDiff is the changes chartify made to a resource.
+type ResourceDiff struct {
+	// ID is the ID of the resource in the chartified output, or in the last stage before it is removed.
+	ID ResourceID
+
+	// Stages are the stages that changed the resource, in the order they are applied.
+	Stages []StageDiff
+}
+
+// Diff is the difference between the resources rendered from the input as-is and the ones rendered from the chartified result.
+type Diff struct {
+	// Resources are the resources changed by chartify, in the order they are rendered from the input,
+	// followed by the resources added by chartify.
+	Resources []ResourceDiff
+}
+
+// Write writes the diff in a human-readable format, like:
+//
+//	apps/v1 Deployment ns/myapp:
+//	  namespace override:
+//	    ~ metadata.namespace: "default" -> "ns"
+//	  json patch patch.yaml:
+//	    ~ spec.replicas: 1 -> 3
+//	v1 Pod ns/myapp-test:
+//	  drop test hooks:
+//	    removed
+func (d *Diff) Write(w io.Writer) error {
+	var b strings.Builder
+
+	for _, res := range d.Resources {
+		fmt.Fprintf(&b, "%s:\n", res.ID)
+
+		for _, s := range res.Stages {
+			fmt.Fprintf(&b, "  %s:\n", s.Stage)
+
+			switch {
+			case s.Added:
+				b.WriteString("    added\n")
+			case s.Removed:
+				b.WriteString("    removed\n")
+			}
+
+			for _, c := range s.Changes {
+				switch c.Type {
+				case ChangeAdded:
+					fmt.Fprintf(&b, "    + %s: %s\n", c.Path, formatDiffValue(c.New))
+				case ChangeRemoved:
+					fmt.Fprintf(&b, "    - %s: %s\n", c.Path, formatDiffValue(c.Old))
+				default:
+					fmt.Fprintf(&b, "    ~ %s: %s -> %s\n", c.Path, formatDiffValue(c.Old), formatDiffValue(c.New))
+				}
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+// diffStage is a stage of the chartify pipeline, rendered with the options enabling every stage up to it.
+type diffStage struct {
+	name string
+	opts *ChartifyOpts
+}
+
+// Diff renders the input as-is and the chartified result with Render, and returns the changes chartify made
+// to every resource, matched by their group, version, kind, namespace and name.
+//
+// To attribute each change to what made it, the input is rendered once per stage of the pipeline, enabling the adhoc
+// chart dependencies, dropping test hooks, the namespace override, each patch, transformer and injector, and exposing values
+// one by one in the order chartify applies them.
+// Remote inputs are fetched only once, and every stage is rendered from the fetched copy.
+// Resources without namespaces are considered to be in ChartifyOpts.Namespace, and resources whose namespace is changed
+// by a stage, like the namespace override, are matched by their group, version, kind and name alone.
+func (r *Runner) Diff(release, dirOrChart string, opts ...ChartifyOption) (*Diff, error) {
+	u := &ChartifyOpts{}
+
+	for i := range opts {
+		if err := opts[i].SetChartifyOption(u); err != nil {
+			return nil, err
+		}
+	}
+
+	fetchDir, err := os.MkdirTemp("", "chartify-diff")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(fetchDir)
+	}()
+
+	input, err := r.fetchDiffInput(dirOrChart, fetchDir, u)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", dirOrChart, err)
+	}
+
+	var (
+		// all are the diffs of every resource found in any stage, in the order they are found
+		all []*ResourceDiff
+
+		prev []*Resource
+		// lineages are the diffs of the resources rendered in the previous stage
+		lineages []*ResourceDiff
+	)
+
+	for i, s := range diffStages(u) {
+		resources, err := r.Render(release, input, WithChartifyOpts(s.opts))
+		if err != nil {
+			return nil, fmt.Errorf("rendering %s: %w", s.name, err)
+		}
+
+		cur := make([]*ResourceDiff, len(resources))
+
+		if i == 0 {
+			for j, res := range resources {
+				cur[j] = &ResourceDiff{ID: res.ID()}
+				all = append(all, cur[j])
+			}
+
+			prev, lineages = resources, cur
+
+			continue
+		}
+
+		for _, m := range matchResources(prev, resources, u.Namespace) {
+			switch {
+			case m.before >= 0 && m.after >= 0:
+				d := lineages[m.before]
+				d.ID = resources[m.after].ID()
+
+				changes, err := diffResources(prev[m.before], resources[m.after])
+				if err != nil {
+					return nil, fmt.Errorf("rendering %s: %w", s.name, err)
+				}
+
+				if len(changes) > 0 {
+					d.Stages = append(d.Stages, StageDiff{Stage: s.name, Changes: changes})
+				}
+
+				cur[m.after] = d
+			case m.before >= 0:
+				d := lineages[m.before]
+				d.Stages = append(d.Stages, StageDiff{Stage: s.name, Removed: true})
+			default:
+				cur[m.after] = &ResourceDiff{ID: resources[m.after].ID(), Stages: []StageDiff{{Stage: s.name, Added: true}}}
+				all = append(all, cur[m.after])
+			}
+		}
+
+		prev, lineages = resources, cur
+	}
+
+	diff := &Diff{}
+	for _, d := range all {
+		if len(d.Stages) > 0 {
+			diff.Resources = append(diff.Resources, *d)
+		}
+	}
+
+	return diff, nil
+}
+
+// fetchDiffInput fetches the remote source or chart to dir, so that Diff renders every stage without fetching it again,
+// and returns the path to the fetched copy. Local inputs are returned as-is.
+func (r *Runner) fetchDiffInput(dirOrChart, dir string, u *ChartifyOpts) (string, error) {
+	if isRemoteSource(dirOrChart) {
+		// Chart archives are verified on rendering, along with the provenance files fetched next to them
+		return r.fetchRemoteSource(dirOrChart, dir, u)
+	}
+
+	if exists, err := r.Exists(dirOrChart); err != nil {
+		return "", err
+	} else if exists {
+		return dirOrChart, nil
+	}
+
+	chart, err := r.fetchAndUntarUnderDir(dirOrChart, dir, u)
+	if err != nil {
+		return "", err
+	}
+
+	// The chart is verified by fetching it, whereas the unpacked copy can no longer be verified on rendering
+	u.Verify = false
+
+	return chart, nil
+}
+
+// diffStages returns the stages of the pipeline to render for Diff.
+// The first stage renders the input as-is, and the last one renders it with every option in u.
+// Options that don't change the rendered resources, like CRDPlacement, are left disabled.
+func diffStages(u *ChartifyOpts) []diffStage {
+	base := *u
+	base.AdhocChartDependencies = nil
+	base.DeprecatedAdhocChartDependencies = nil
+	base.OverrideNamespace = ""
+	base.JsonPatches = nil
+	base.StrategicMergePatches = nil
+	base.Patches = nil
+	base.HookPatches = nil
+	base.Transformers = nil
+	base.MergePatches = nil
+	base.FieldSetters = nil
+	base.Injectors = nil
+	base.Injects = nil
+	base.DropTestHooks = false
+	base.ExposedValues = nil
+	base.CRDPlacement = ""
+	base.PreserveFileLayout = false
+	base.EscapeTemplates = false
+	base.ChartAPIVersion = ""
+
+	stages := []diffStage{{name: "input", opts: &base}}
+
+	add := func(name string, enable func(o *ChartifyOpts)) {
+		o := *stages[len(stages)-1].opts
+		enable(&o)
+		stages = append(stages, diffStage{name: name, opts: &o})
+	}
+
+	if len(u.AdhocChartDependencies) > 0 || len(u.DeprecatedAdhocChartDependencies) > 0 {
+		add("adhoc chart dependencies", func(o *ChartifyOpts) {
+			o.AdhocChartDependencies = u.AdhocChartDependencies
+			o.DeprecatedAdhocChartDependencies = u.DeprecatedAdhocChartDependencies
+		})
+	}
+
+	// Chartify drops test hooks right after rendering the chart, before overriding the namespace
+	if u.DropTestHooks {
+		add("drop test hooks", func(o *ChartifyOpts) {
+			o.DropTestHooks = true
+		})
+	}
+
+	if u.OverrideNamespace != "" {
+		add("namespace override", func(o *ChartifyOpts) {
+			o.OverrideNamespace = u.OverrideNamespace
+		})
+	}
+
+	for _, f := range u.JsonPatches {
+		add("json patch "+f, func(o *ChartifyOpts) {
+			o.JsonPatches = append(o.JsonPatches[:len(o.JsonPatches):len(o.JsonPatches)], f)
+		})
+	}
+
+	for _, f := range u.StrategicMergePatches {
+		add("strategic merge patch "+f, func(o *ChartifyOpts) {
+			o.StrategicMergePatches = append(o.StrategicMergePatches[:len(o.StrategicMergePatches):len(o.StrategicMergePatches)], f)
+		})
+	}
+
+	for _, f := range u.Patches {
+		add("patch "+f, func(o *ChartifyOpts) {
+			o.Patches = append(o.Patches[:len(o.Patches):len(o.Patches)], f)
+		})
+	}
+
+	for _, f := range u.HookPatches {
+		add("hook patch "+f, func(o *ChartifyOpts) {
+			o.HookPatches = append(o.HookPatches[:len(o.HookPatches):len(o.HookPatches)], f)
+		})
+	}
+
+	for _, f := range u.Transformers {
+		add("transformer "+f, func(o *ChartifyOpts) {
+			o.Transformers = append(o.Transformers[:len(o.Transformers):len(o.Transformers)], f)
+		})
+	}
+
+	for _, f := range u.MergePatches {
+		add("merge patch "+f, func(o *ChartifyOpts) {
+			o.MergePatches = append(o.MergePatches[:len(o.MergePatches):len(o.MergePatches)], f)
+		})
+	}
+
+	for i, s := range u.FieldSetters {
+		add(PatchReport{Type: PatchTypeFieldSetter, Source: s.Path, Index: i}.String(), func(o *ChartifyOpts) {
+			o.FieldSetters = append(o.FieldSetters[:len(o.FieldSetters):len(o.FieldSetters)], s)
+		})
+	}
+
+	for _, i := range u.Injectors {
+		add("injector "+i, func(o *ChartifyOpts) {
+			o.Injectors = append(o.Injectors[:len(o.Injectors):len(o.Injectors)], i)
+		})
+	}
+
+	for _, i := range u.Injects {
+		add("inject "+i, func(o *ChartifyOpts) {
+			o.Injects = append(o.Injects[:len(o.Injects):len(o.Injects)], i)
+		})
+	}
+
+	if len(u.ExposedValues) > 0 {
+		add("exposed values", func(o *ChartifyOpts) {
+			o.ExposedValues = u.ExposedValues
+		})
+	}
+
+	return stages
+}
+
+// resourceMatch is a pair of the indices of the same resource rendered in two stages.
+// Either index is -1 when the resource is missing in the stage.
+type resourceMatch struct {
+	before, after int
+}
+
+// matchResources matches the resources rendered in two consecutive stages.
+// Resources are matched by their group, version, kind, namespace and name first, considering ones without namespaces
+// to be in the namespace, and then by their group, version, kind and name if it's unique among the unmatched resources.
+// The matches are in the order of the resources after the stage, followed by the resources removed by the stage.
+func matchResources(before, after []*Resource, namespace string) []resourceMatch {
+	key := func(res *Resource, withNamespace bool) ResourceID {
+		id := res.ID()
+		if !withNamespace {
+			id.Namespace = ""
+		} else if id.Namespace == "" {
+			id.Namespace = namespace
+		}
+		return id
+	}
+
+	matches := make([]resourceMatch, len(after))
+	matched := make([]bool, len(before))
+
+	for _, withNamespace := range []bool{true, false} {
+		unmatched := map[ResourceID][]int{}
+		for i, res := range before {
+			if !matched[i] {
+				k := key(res, withNamespace)
+				unmatched[k] = append(unmatched[k], i)
+			}
+		}
+
+		candidates := map[ResourceID]int{}
+		for j, res := range after {
+			if withNamespace || matches[j].before < 0 {
+				candidates[key(res, withNamespace)]++
+			}
+		}
+
+		for j, res := range after {
+			if withNamespace {
+				matches[j] = resourceMatch{before: -1, after: j}
+			} else if matches[j].before >= 0 {
+				continue
+			}
+
+			k := key(res, withNamespace)
+
+			is := unmatched[k]
+			if len(is) == 0 || (!withNamespace && (len(is) > 1 || candidates[k] > 1)) {
+				continue
+			}
+
+			matches[j].before = is[0]
+			matched[is[0]] = true
+			unmatched[k] = is[1:]
+		}
+	}
+
+	for i := range before {
+		if !matched[i] {
+			matches = append(matches, resourceMatch{before: i, after: -1})
+		}
+	}
+
+	return matches
+}
+
+// diffResources returns the changes made to the fields of the resource.
+func diffResources(before, after *Resource) ([]FieldChange, error) {
+	var b, a interface{}
+
+	if err := before.Node.Decode(&b); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", before.ID(), err)
+	}
+
+	if err := after.Node.Decode(&a); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", after.ID(), err)
+	}
+
+	return diffValues("", b, a, nil), nil
+}
+
+func diffValues(path string, before, after interface{}, changes []FieldChange) []FieldChange {
+	switch b := before.(type) {
+	case map[string]interface{}:
+		a, ok := after.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(b)+len(a))
+		for k := range b {
+			keys = append(keys, k)
+		}
+		for k := range a {
+			if _, ok := b[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			p := appendFieldPath(path, k)
+
+			bv, inBefore := b[k]
+			av, inAfter := a[k]
+
+			switch {
+			case !inBefore:
+				changes = append(changes, FieldChange{Type: ChangeAdded, Path: p, New: av})
+			case !inAfter:
+				changes = append(changes, FieldChange{Type: ChangeRemoved, Path: p, Old: bv})
+			default:
+				changes = diffValues(p, bv, av, changes)
+			}
+		}
+
+		return changes
+	case []interface{}:
+		a, ok := after.([]interface{})
+		if !ok {
+			break
+		}
+
+		for i := 0; i < len(b) || i < len(a); i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+
+			switch {
+			case i >= len(b):
+				changes = append(changes, FieldChange{Type: ChangeAdded, Path: p, New: a[i]})
+			case i >= len(a):
+				changes = append(changes, FieldChange{Type: ChangeRemoved, Path: p, Old: b[i]})
+			default:
+				changes = diffValues(p, b[i], a[i], changes)
+			}
+		}
+
+		return changes
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		changes = append(changes, FieldChange{Type: ChangeModified, Path: path, Old: before, New: after})
+	}
+
+	return changes
+}
+
+// appendFieldPath returns the path to the key of the mapping at path, quoting the key when it contains dots or brackets,
+// like `metadata.annotations["helm.sh/hook"]`.
+func appendFieldPath(path, key string) string {
+	if strings.ContainsAny(key, ".[]") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// formatDiffValue formats the value as a single-line JSON.
+func formatDiffValue(v interface{}) string {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(bs)
+}
@@ -0,0 +1,192 @@
+package chartify
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/helmfile/chartify/chartrepo"
+	"github.com/helmfile/chartify/helmtesting"
+)
+
+func TestDiffValues(t *testing.T) {
+	before := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":        "a",
+			"annotations": map[string]interface{}{"helm.sh/hook": "test"},
+		},
+		"spec": map[string]interface{}{
+			"replicas": 1,
+			"ports":    []interface{}{80, 443},
+		},
+	}
+	after := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      "a",
+			"namespace": "ns",
+		},
+		"spec": map[string]interface{}{
+			"replicas": 3,
+			"ports":    []interface{}{80},
+		},
+	}
+
+	require.Equal(t, []FieldChange{
+		{Type: ChangeRemoved, Path: `metadata.annotations`, Old: map[string]interface{}{"helm.sh/hook": "test"}},
+		{Type: ChangeAdded, Path: "metadata.namespace", New: "ns"},
+		{Type: ChangeRemoved, Path: "spec.ports[1]", Old: 443},
+		{Type: ChangeModified, Path: "spec.replicas", Old: 1, New: 3},
+	}, diffValues("", before, after, nil))
+
+	require.Equal(t, []FieldChange{
+		{Type: ChangeModified, Path: `metadata.annotations["helm.sh/hook"]`, Old: "test", New: "pre-install"},
+	}, diffValues("metadata", before["metadata"], map[string]interface{}{
+		"name":        "a",
+		"annotations": map[string]interface{}{"helm.sh/hook": "pre-install"},
+	}, nil))
+}
+
+func TestMatchResources(t *testing.T) {
+	read := func(s string) []*Resource {
+		t.Helper()
+
+		resources, err := ReadResources(strings.NewReader(s))
+		require.NoError(t, err)
+
+		return resources
+	}
+
+	before := read(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+  namespace: other
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: test
+`)
+	after := read(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+  namespace: ns
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+  namespace: default
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: a
+`)
+
+	require.Equal(t, []resourceMatch{
+		{before: 1, after: 0},
+		{before: 0, after: 1},
+		{before: -1, after: 2},
+		{before: 2, after: -1},
+	}, matchResources(before, after, "default"))
+}
+
+func TestDiff(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	patch := filepath.Join(t.TempDir(), "patch.yaml")
+	require.NoError(t, os.WriteFile(patch, []byte(`target:
+  kind: Deployment
+patch:
+- op: replace
+  path: /spec/replicas
+  value: 3
+`), 0644))
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	diff, err := r.Diff("myapp", "testdata/charts/log", WithChartifyOpts(&ChartifyOpts{
+		OverrideNamespace: "ns",
+		JsonPatches:       []string{patch},
+		DropTestHooks:     true,
+	}))
+	require.NoError(t, err)
+
+	require.Equal(t, []ResourceDiff{
+		{
+			ID: ResourceID{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "ns", Name: "myapp-log"},
+			Stages: []StageDiff{
+				{Stage: "namespace override", Changes: []FieldChange{{Type: ChangeAdded, Path: "metadata.namespace", New: "ns"}}},
+				{Stage: "json patch " + patch, Changes: []FieldChange{{Type: ChangeModified, Path: "spec.replicas", Old: 1, New: 3}}},
+			},
+		},
+		{
+			// Test hooks are dropped before the namespace is overridden, like Chartify does
+			ID: ResourceID{APIVersion: "v1", Kind: "Pod", Name: "myapp-log-test-connection"},
+			Stages: []StageDiff{
+				{Stage: "drop test hooks", Removed: true},
+			},
+		},
+	}, diff.Resources)
+
+	var buf bytes.Buffer
+	require.NoError(t, diff.Write(&buf))
+	require.Equal(t, `apps/v1 Deployment ns/myapp-log:
+  namespace override:
+    + metadata.namespace: "ns"
+  json patch `+patch+`:
+    ~ spec.replicas: 1 -> 3
+v1 Pod myapp-log-test-connection:
+  drop test hooks:
+    removed
+`, buf.String())
+}
+
+func TestDiff_FetchesOnce(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	srv := helmtesting.StartChartRepoServer(t, helmtesting.ChartRepoServerConfig{
+		Port:      chartrepo.RandomPort,
+		ChartsDir: "testdata/charts",
+	})
+	helmtesting.AddChartRepo(t, helm, "diffrepo", srv)
+
+	var pulls int
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+	runCommand := r.RunCommand
+	r.RunCommand = func(name string, args []string, dir string, stdout, stderr io.Writer, env map[string]string) error {
+		if len(args) > 0 && args[0] == "pull" {
+			pulls++
+		}
+		return runCommand(name, args, dir, stdout, stderr, env)
+	}
+
+	diff, err := r.Diff("myapp", "diffrepo/log", WithChartifyOpts(&ChartifyOpts{
+		OverrideNamespace: "ns",
+		DropTestHooks:     true,
+	}))
+	require.NoError(t, err)
+	require.Len(t, diff.Resources, 2)
+	require.Equal(t, 1, pulls, "the chart must be fetched only once for all the stages")
+}
@@ -0,0 +1,89 @@
+package chartify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// templateEscaper replaces every `{{` and `}}` with a template action that renders it as-is.
+// strings.Replacer replaces in a single pass, so the braces it writes are never escaped again.
+var templateEscaper = strings.NewReplacer("{{", `{{"{{"}}`, "}}", `{{"}}"}}`)
+
+// EscapeTemplateSyntax escapes every Go template delimiter in the content, so that rendering the result as
+// a Go template produces the original content.
+func EscapeTemplateSyntax(content string) string {
+	return templateEscaper.Replace(content)
+}
+
+// EscapeChartToPreventDoubleRendering is an alternative to RewriteChartToPreventDoubleRendering that
+// keeps the rendered files in templates/, escaping every `{{` and `}}` in them instead.
+// Unlike the `.Files.Get` stubs, the resulting chart contains every file only once and its resources
+// can be read by tools that look into templates/ directly.
+func (r *Runner) EscapeChartToPreventDoubleRendering(tempDir string) error {
+	for _, d := range ContentDirs {
+		if d == "crds" {
+			// helm never renders crds/*.yaml as templates
+			continue
+		}
+
+		dir := filepath.Join(tempDir, d)
+		if !r.dirExists(dir) {
+			continue
+		}
+
+		if err := r.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			content, err := r.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			escaped := EscapeTemplateSyntax(string(content))
+			if escaped == string(content) {
+				return nil
+			}
+
+			return r.WriteFile(path, []byte(escaped), info.Mode().Perm())
+		}); err != nil {
+			return err
+		}
+
+		if d == "charts" {
+			if err := moveChartsUnderTemplates(tempDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// preventDoubleRendering makes the rendered files in the chart at chartDir render as-is,
+// either by escaping them or by moving them under files/ and replacing them with `.Files.Get` stubs.
+// The placeholders of exposed values contained in the files are then bound to the values of the chart.
+func (r *Runner) preventDoubleRendering(chartDir string, escape bool, placeholders map[string][]exposedValuePlaceholder) error {
+	if escape {
+		if err := r.EscapeChartToPreventDoubleRendering(chartDir); err != nil {
+			return err
+		}
+	} else {
+		filesDir, err := r.EnsureFilesDir(chartDir)
+		if err != nil {
+			return err
+		}
+
+		if err := r.RewriteChartToPreventDoubleRendering(chartDir, filesDir); err != nil {
+			return err
+		}
+	}
+
+	return r.bindExposedValues(chartDir, escape, placeholders)
+}
@@ -0,0 +1,91 @@
+package chartify
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscapeTemplateSyntax(t *testing.T) {
+	for _, content := range []string{
+		"",
+		"no delimiters",
+		`description: "{{ $labels.instance }} is down"`,
+		"{{{ nested }}}",
+		"}} unbalanced {{",
+		`{{"{{"}} already escaped`,
+	} {
+		escaped := EscapeTemplateSyntax(content)
+
+		tmpl, err := template.New("test").Parse(escaped)
+		require.NoError(t, err, escaped)
+
+		var buf bytes.Buffer
+		require.NoError(t, tmpl.Execute(&buf, nil))
+		require.Equal(t, content, buf.String())
+	}
+}
+
+func TestChartify_EscapeTemplates(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	chartDir := filepath.Join(t.TempDir(), "alerts")
+	writeTestFiles(t, chartDir, map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: alerts\nversion: 0.1.0\n",
+		"values.yaml": "for: 5m\n",
+		"templates/rule.yaml": `apiVersion: monitoring.coreos.com/v1
+kind: PrometheusRule
+metadata:
+  name: {{ .Release.Name }}
+spec:
+  groups:
+  - name: alerts
+    rules:
+    - alert: InstanceDown
+      expr: up == 0
+      for: {{ .Values.for }}
+      annotations:
+        description: '{{"{{"}} $labels.instance {{"}}"}} is down'
+`,
+	})
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", chartDir, WithChartifyOpts(&ChartifyOpts{
+		OverrideNamespace: "monitoring",
+		EscapeTemplates:   true,
+		ExposedValues: []ExposedValue{
+			{Target: PatchTarget{Kind: "PrometheusRule"}, Path: "spec.groups[0].rules[0].for", Key: "for"},
+		},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	require.NoDirExists(t, filepath.Join(tmpDir, "files"))
+
+	rule, err := os.ReadFile(filepath.Join(tmpDir, "templates", "rule.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(rule), `{{"{{"}} $labels.instance {{"}}"}} is down`)
+	require.Contains(t, string(rule), `for: {{ index .Values "for" | toJson }}`)
+
+	out, err := exec.Command(helm, "template", "myapp", tmpDir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), "description: '{{ $labels.instance }} is down'")
+	require.Contains(t, string(out), `for: "5m"`)
+	require.Contains(t, string(out), "namespace: monitoring")
+
+	out, err = exec.Command(helm, "template", "myapp", tmpDir, "--set", "for=10m").CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), `for: "10m"`)
+}
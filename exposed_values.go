@@ -0,0 +1,583 @@
+package chartify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ExposedValue re-exposes a field of the rendered resources as a value of the generated chart.
+//
+// Every template of the generated chart is a `{{ .Files.Get "files/..." }}` stub so that the patched resources
+// aren't rendered twice, which means values of the original chart no longer take effect once chartified.
+// An exposed field is replaced with a placeholder that the stub fills with the value at render time,
+// so that it can still be changed with e.g. `helm upgrade --set KEY=VALUE` on the generated chart.
+type ExposedValue struct {
+	// Target selects the resources whose field is exposed, in the same format as the target of JsonPatches.
+	// An empty Target selects every resource.
+	Target PatchTarget `yaml:"target,omitempty"`
+
+	// Path is the path to the field within the resource, like `spec.replicas` or `spec.template.spec.containers[0].image`.
+	// Sequence items can be selected either by `[N]`, `.N`, or a filter like `[?(@.name=="app")]`, which selects the first matching item.
+	// Keys containing dots can be quoted in brackets, like `metadata.annotations["example.com/replicas"]`.
+	Path string `yaml:"path"`
+
+	// Key is the dot-separated key of the value in the generated chart, like `db.replicas`.
+	// The rendered value of the field becomes its default in values.yaml.
+	Key string `yaml:"key"`
+}
+
+// ParseExposedValue parses the `KEY=[KIND[/NAME]:]PATH` notation of an ExposedValue, like
+// `db.replicas=Deployment/myapp-db:spec.replicas`.
+func ParseExposedValue(s string) (ExposedValue, error) {
+	key, target, ok := strings.Cut(s, "=")
+	if !ok || key == "" || target == "" {
+		return ExposedValue{}, fmt.Errorf("invalid exposed value %q: it must be in the form of KEY=[KIND[/NAME]:]PATH", s)
+	}
+
+	v := ExposedValue{Key: key, Path: target}
+
+	if selector, path, ok := cutUnquoted(target, ':'); ok {
+		kind, name, _ := strings.Cut(selector, "/")
+		v.Path = path
+		v.Target.Kind = regexp.QuoteMeta(kind)
+		v.Target.Name = regexp.QuoteMeta(name)
+	}
+
+	return v, nil
+}
+
+// exposedValueMarkerFormat is the format of the placeholder that replaces the field selected by the N-th ExposedValue.
+const exposedValueMarkerFormat = "__CHARTIFY_EXPOSED_VALUE_%d__"
+
+type exposedValuePlaceholder struct {
+	marker string
+	key    []string
+}
+
+type exposedValueDefault struct {
+	key  []string
+	node *yaml.Node
+}
+
+// exposeValues replaces the fields selected by exposed in the rendered templates of the chart and its subcharts
+// with placeholders, and writes the rendered values of the fields into values.yaml as defaults.
+// CRDs under crds/ are never rendered by helm, and therefore are left as-is.
+//
+// It returns the placeholders contained in each file, keyed by the path to the file relative to chartDir.
+func (r *Runner) exposeValues(chartDir string, exposed []ExposedValue) (map[string][]exposedValuePlaceholder, error) {
+	type parsedExposedValue struct {
+		ExposedValue
+		path []string
+		key  []string
+	}
+
+	var parsed []parsedExposedValue
+	for _, e := range exposed {
+		path, err := parseFieldPath(e.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := parseValuesKey(e.Key)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed = append(parsed, parsedExposedValue{ExposedValue: e, path: path, key: key})
+	}
+
+	var (
+		placeholders = map[string][]exposedValuePlaceholder{}
+		defaults     []exposedValueDefault
+		matched      = make([]bool, len(parsed))
+	)
+
+	for _, d := range []string{"templates", "charts"} {
+		dir := filepath.Join(chartDir, d)
+		if !r.dirExists(dir) {
+			continue
+		}
+
+		if err := r.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				return nil
+			}
+
+			if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+				return nil
+			}
+
+			rel, err := filepath.Rel(chartDir, path)
+			if err != nil {
+				return fmt.Errorf("calculating relative path to %s from %s: %w", path, chartDir, err)
+			}
+			rel = filepath.ToSlash(rel)
+
+			if !isTemplateOrigin(rel) {
+				return nil
+			}
+
+			resources, err := ReadResourcesFromFile(path)
+			if err != nil {
+				return err
+			}
+
+			var (
+				changed bool
+				buf     bytes.Buffer
+			)
+
+			for _, res := range resources {
+				var exposedInResource bool
+
+				for i, e := range parsed {
+					ok, err := e.Target.Matches(res)
+					if err != nil {
+						return fmt.Errorf("exposing %s as .Values.%s: %w", e.Path, e.Key, err)
+					}
+
+					if !ok {
+						continue
+					}
+
+					field := lookupField(res.Node, e.path)
+					if field == nil {
+						continue
+					}
+
+					def := *field
+					defaults = append(defaults, exposedValueDefault{key: e.key, node: &def})
+
+					marker := fmt.Sprintf(exposedValueMarkerFormat, i)
+					*field = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: marker}
+
+					r.Logf("Exposing %s of %s/%s in %s as .Values.%s", e.Path, res.Kind(), res.Name(), rel, e.Key)
+
+					if !hasPlaceholder(placeholders[rel], marker) {
+						placeholders[rel] = append(placeholders[rel], exposedValuePlaceholder{marker: marker, key: e.key})
+					}
+					matched[i] = true
+					exposedInResource = true
+				}
+
+				if buf.Len() > 0 {
+					buf.WriteString("---\n")
+				}
+
+				if !exposedInResource {
+					buf.Write(res.Raw)
+					continue
+				}
+
+				if err := encodeYAMLDocument(&buf, res.Node); err != nil {
+					return err
+				}
+				changed = true
+			}
+
+			if !changed {
+				return nil
+			}
+
+			return r.WriteFile(path, buf.Bytes(), 0644)
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, e := range parsed {
+		if !matched[i] {
+			return nil, fmt.Errorf("exposing %s as .Values.%s: no rendered resource has the field", e.Path, e.Key)
+		}
+	}
+
+	if err := r.writeValueDefaults(filepath.Join(chartDir, "values.yaml"), defaults); err != nil {
+		return nil, err
+	}
+
+	return placeholders, nil
+}
+
+func hasPlaceholder(placeholders []exposedValuePlaceholder, marker string) bool {
+	for _, p := range placeholders {
+		if p.marker == marker {
+			return true
+		}
+	}
+	return false
+}
+
+// writeValueDefaults sets the defaults in the values file, keeping the rest of its content.
+// It fails when two different defaults are given for the same key.
+func (r *Runner) writeValueDefaults(valuesFile string, defaults []exposedValueDefault) error {
+	var doc yaml.Node
+
+	if exists, err := r.Exists(valuesFile); err != nil {
+		return err
+	} else if exists {
+		content, err := r.ReadFile(valuesFile)
+		if err != nil {
+			return err
+		}
+
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return fmt.Errorf("parsing %s: %w", valuesFile, err)
+		}
+	}
+
+	if len(doc.Content) == 0 || documentRoot(&doc).Tag == "!!null" {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+
+	root := documentRoot(&doc)
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: expected a YAML mapping but got %s", valuesFile, root.Tag)
+	}
+
+	written := map[string]string{}
+
+	for _, d := range defaults {
+		var value bytes.Buffer
+		if err := encodeYAMLDocument(&value, d.node); err != nil {
+			return err
+		}
+
+		key := strings.Join(d.key, ".")
+		if prev, ok := written[key]; ok {
+			if prev != value.String() {
+				return fmt.Errorf("conflicting defaults for .Values.%s: the exposed fields have different values %q and %q", key, prev, value.String())
+			}
+			continue
+		}
+		written[key] = value.String()
+
+		m := root
+		for i, k := range d.key[:len(d.key)-1] {
+			var err error
+			m, err = ensureMapping(m, k)
+			if err != nil {
+				// Never overwrite a value the chart already has with a mapping
+				return fmt.Errorf("setting the default for .Values.%s in %s: .Values.%s: %w", key, valuesFile, strings.Join(d.key[:i+1], "."), err)
+			}
+		}
+
+		last := d.key[len(d.key)-1]
+		if v := mappingValue(m, last); v != nil {
+			*v = *d.node
+		} else {
+			m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: last}, d.node)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := encodeYAMLDocument(&buf, &doc); err != nil {
+		return err
+	}
+
+	return r.WriteFile(valuesFile, buf.Bytes(), 0644)
+}
+
+// bindExposedValues makes the placeholders in the rendered files render to the values of the chart.
+// When the files were escaped in place, every placeholder is replaced with a template action that renders the value.
+// Otherwise, the `{{ .Files.Get "files/..." }}` stubs written by RewriteChartToPreventDoubleRendering
+// for the files containing placeholders are overwritten to replace the placeholders on rendering.
+func (r *Runner) bindExposedValues(chartDir string, escaped bool, placeholders map[string][]exposedValuePlaceholder) error {
+	for rel, ps := range placeholders {
+		path := filepath.Join(chartDir, filepath.FromSlash(rel))
+		if strings.HasPrefix(rel, "charts/") {
+			// Both RewriteChartToPreventDoubleRendering and EscapeChartToPreventDoubleRendering move charts/ under templates/
+			path = filepath.Join(chartDir, "templates", filepath.FromSlash(rel))
+		}
+
+		var content string
+
+		if escaped {
+			c, err := r.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			content = string(c)
+			for _, p := range ps {
+				content = strings.ReplaceAll(content, p.marker, fmt.Sprintf("{{ %s | toJson }}", valuesReference(p.key)))
+			}
+		} else {
+			var b strings.Builder
+			fmt.Fprintf(&b, `{{ .Files.Get "files/%s"`, rel)
+			for _, p := range ps {
+				fmt.Fprintf(&b, ` | replace %q (%s | toJson)`, p.marker, valuesReference(p.key))
+			}
+			b.WriteString(" }}")
+
+			content = b.String()
+		}
+
+		if err := r.WriteFile(path, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// valuesReference returns the template expression that evaluates to the value at the key.
+func valuesReference(key []string) string {
+	ref := "index .Values"
+	for _, k := range key {
+		ref += " " + strconv.Quote(k)
+	}
+	return ref
+}
+
+// parseFieldPath splits the path to a field of a resource, like `spec.template.spec.containers[0].image`,
+// into its segments, like `spec`, `template`, `spec`, `containers`, `0`, and `image`.
+// Keys containing dots can be quoted in brackets, like `metadata.annotations["helm.sh/hook"]`,
+// which is also how Diff prints them.
+// Sequence items can also be selected by a field in JSONPath filters, like `spec.containers[?(@.name=="app")].image`,
+// whose segment is the filter in the form returned by parseFieldFilter.
+func parseFieldPath(path string) ([]string, error) {
+	invalid := fmt.Errorf("invalid field path %q: it must be dot-separated field names, sequence indices, quoted keys, and filters like `spec.containers[0].image`, `metadata.annotations[\"helm.sh/hook\"]` or `spec.containers[?(@.name==\"app\")].image`", path)
+
+	var segments []string
+
+	// needSegment is true at the beginning and after a dot, where a field name or a bracket must follow
+	needSegment := true
+
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			if needSegment {
+				return nil, invalid
+			}
+			needSegment = true
+			i++
+		case '[':
+			rest := path[i+1:]
+
+			var segment string
+			if strings.HasPrefix(rest, `"`) {
+				quoted, err := strconv.QuotedPrefix(rest)
+				if err != nil {
+					return nil, invalid
+				}
+
+				if segment, err = strconv.Unquote(quoted); err != nil {
+					return nil, invalid
+				}
+
+				rest = rest[len(quoted):]
+			} else if strings.HasPrefix(rest, "?(") {
+				filter, n, ok := parseFieldFilter(rest)
+				if !ok {
+					return nil, invalid
+				}
+
+				segment = filter
+				rest = rest[n:]
+			} else {
+				end := strings.Index(rest, "]")
+				if end < 0 {
+					return nil, invalid
+				}
+
+				segment = rest[:end]
+				if _, err := strconv.Atoi(segment); err != nil {
+					return nil, invalid
+				}
+
+				rest = rest[end:]
+			}
+
+			if !strings.HasPrefix(rest, "]") {
+				return nil, invalid
+			}
+
+			segments = append(segments, segment)
+			needSegment = false
+			i = len(path) - len(rest) + 1
+
+			// A bracket must be followed by a dot, another bracket, or nothing
+			if i < len(path) && path[i] != '.' && path[i] != '[' {
+				return nil, invalid
+			}
+		default:
+			if !needSegment {
+				return nil, invalid
+			}
+
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+
+			segments = append(segments, path[i:i+end])
+			needSegment = false
+			i += end
+		}
+	}
+
+	if needSegment {
+		return nil, invalid
+	}
+
+	return segments, nil
+}
+
+// parseFieldFilter parses the JSONPath filter at the beginning of s, like `?(@.name=="app")` or `?(@.port==80)`,
+// which selects the sequence items whose field has the value.
+// It returns the filter in the canonical form, like `?(@.name=="app")`, and the length of the filter in s.
+func parseFieldFilter(s string) (string, int, bool) {
+	rest, ok := strings.CutPrefix(s, "?(@.")
+	if !ok {
+		return "", 0, false
+	}
+
+	key, rest, ok := strings.Cut(rest, "==")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" || strings.ContainsAny(key, ".[]()=\"' ") {
+		return "", 0, false
+	}
+
+	rest = strings.TrimLeft(rest, " ")
+
+	var value string
+	switch {
+	case strings.HasPrefix(rest, `"`):
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return "", 0, false
+		}
+
+		unquoted, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", 0, false
+		}
+
+		value = strconv.Quote(unquoted)
+		rest = rest[len(quoted):]
+	case strings.HasPrefix(rest, "'"):
+		end := strings.Index(rest[1:], "'")
+		if end < 0 {
+			return "", 0, false
+		}
+
+		value = strconv.Quote(rest[1 : end+1])
+		rest = rest[end+2:]
+	default:
+		end := strings.Index(rest, ")")
+		if end < 0 {
+			return "", 0, false
+		}
+
+		value = strings.TrimSpace(rest[:end])
+		if value == "" {
+			return "", 0, false
+		}
+
+		rest = rest[end:]
+	}
+
+	rest, ok = strings.CutPrefix(strings.TrimLeft(rest, " "), ")")
+	if !ok {
+		return "", 0, false
+	}
+
+	return fmt.Sprintf("?(@.%s==%s)", key, value), len(s) - len(rest), true
+}
+
+// fieldFilter returns the key and the value of the segment of a field path, if it is a filter returned by parseFieldFilter.
+func fieldFilter(segment string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(segment, "?(@.")
+	if !ok {
+		return "", "", false
+	}
+
+	rest, ok = strings.CutSuffix(rest, ")")
+	if !ok {
+		return "", "", false
+	}
+
+	key, value, ok := strings.Cut(rest, "==")
+	if !ok {
+		return "", "", false
+	}
+
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+
+	return key, value, true
+}
+
+// sequenceItems returns the items of the sequence selected by the segment of a field path,
+// which is either an index like `0` or a filter like `?(@.name=="app")`.
+func sequenceItems(seq *yaml.Node, segment string) []*yaml.Node {
+	if key, value, ok := fieldFilter(segment); ok {
+		var items []*yaml.Node
+		for _, item := range seq.Content {
+			if item.Kind != yaml.MappingNode {
+				continue
+			}
+
+			if v := mappingValue(item, key); v != nil && v.Kind == yaml.ScalarNode && v.Value == value {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+
+	i, err := strconv.Atoi(segment)
+	if err != nil || i < 0 || i >= len(seq.Content) {
+		return nil
+	}
+
+	return []*yaml.Node{seq.Content[i]}
+}
+
+// parseValuesKey splits the dot-separated key of a value, like `db.replicas`.
+func parseValuesKey(key string) ([]string, error) {
+	segments := strings.Split(key, ".")
+	for _, s := range segments {
+		if s == "" {
+			return nil, fmt.Errorf("invalid values key %q: it must be dot-separated non-empty keys like `db.replicas`", key)
+		}
+	}
+	return segments, nil
+}
+
+// lookupField returns the node at the path within the document, or nil if there is no such field.
+func lookupField(doc *yaml.Node, path []string) *yaml.Node {
+	node := documentRoot(doc)
+
+	for _, s := range path {
+		switch node.Kind {
+		case yaml.MappingNode:
+			node = mappingValue(node, s)
+		case yaml.SequenceNode:
+			items := sequenceItems(node, s)
+			if len(items) == 0 {
+				return nil
+			}
+			node = items[0]
+		default:
+			return nil
+		}
+
+		if node == nil {
+			return nil
+		}
+	}
+
+	return node
+}
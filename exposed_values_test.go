@@ -0,0 +1,200 @@
+package chartify
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseFieldPath(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want []string
+		err  bool
+	}{
+		{path: "spec.replicas", want: []string{"spec", "replicas"}},
+		{path: "spec.template.spec.containers[0].image", want: []string{"spec", "template", "spec", "containers", "0", "image"}},
+		{path: "spec.template.spec.containers.0.image", want: []string{"spec", "template", "spec", "containers", "0", "image"}},
+		{path: "matrix[1][2]", want: []string{"matrix", "1", "2"}},
+		{path: `metadata.annotations["helm.sh/hook"]`, want: []string{"metadata", "annotations", "helm.sh/hook"}},
+		{path: `metadata.labels["app.kubernetes.io/name"].x`, want: []string{"metadata", "labels", "app.kubernetes.io/name", "x"}},
+		{path: `data["a\"[b]"][0]`, want: []string{"data", `a"[b]`, "0"}},
+		{path: `spec.containers[?(@.name=="app")].image`, want: []string{"spec", "containers", `?(@.name=="app")`, "image"}},
+		{path: `spec.containers[?(@.name == 'a]b')]`, want: []string{"spec", "containers", `?(@.name=="a]b")`}},
+		{path: `spec.ports[?(@.port==80)].name`, want: []string{"spec", "ports", "?(@.port==80)", "name"}},
+		{path: `spec.containers[?(@.name=="app")`, err: true},
+		{path: `spec.containers[?(@.name)]`, err: true},
+		{path: `spec.containers[?(@.a.b=="x")]`, err: true},
+		{path: `metadata.annotations["helm.sh/hook`, err: true},
+		{path: `metadata.annotations["helm.sh/hook"]x`, err: true},
+		{path: "spec.", err: true},
+		{path: "spec..replicas", err: true},
+		{path: "spec.containers[x]", err: true},
+		{path: "spec.containers[0", err: true},
+		{path: "", err: true},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := parseFieldPath(tc.path)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseExposedValue(t *testing.T) {
+	v, err := ParseExposedValue("db.replicas=Deployment/myapp-db:spec.replicas")
+	require.NoError(t, err)
+	require.Equal(t, ExposedValue{Target: PatchTarget{Kind: "Deployment", Name: "myapp-db"}, Path: "spec.replicas", Key: "db.replicas"}, v)
+
+	v, err = ParseExposedValue("replicas=Deployment:spec.replicas")
+	require.NoError(t, err)
+	require.Equal(t, ExposedValue{Target: PatchTarget{Kind: "Deployment"}, Path: "spec.replicas", Key: "replicas"}, v)
+
+	v, err = ParseExposedValue(`hook=Job/my.db:metadata.annotations["example.com/a:b"]`)
+	require.NoError(t, err)
+	require.Equal(t, ExposedValue{Target: PatchTarget{Kind: "Job", Name: `my\.db`}, Path: `metadata.annotations["example.com/a:b"]`, Key: "hook"}, v)
+
+	v, err = ParseExposedValue("replicas=spec.replicas")
+	require.NoError(t, err)
+	require.Equal(t, ExposedValue{Path: "spec.replicas", Key: "replicas"}, v)
+
+	_, err = ParseExposedValue("spec.replicas")
+	require.Error(t, err)
+}
+
+func TestRunner_exposeValues(t *testing.T) {
+	chartDir := t.TempDir()
+
+	writeTestFiles(t, chartDir, map[string]string{
+		"values.yaml": "# existing values\nfoo: bar\n",
+		"templates/deploy.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: web
+        image: nginx:1.0
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  replicas: "2"
+`,
+		"charts/sub/templates/deploy.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: sub
+spec:
+  replicas: 3
+`,
+	})
+
+	r := New(WithLogf(t.Logf))
+
+	placeholders, err := r.exposeValues(chartDir, []ExposedValue{
+		{Target: PatchTarget{Kind: "Deployment", Name: "web"}, Path: "spec.replicas", Key: "web.replicas"},
+		{Target: PatchTarget{Kind: "Deployment"}, Path: "spec.template.spec.containers[0].image", Key: "web.image"},
+		{Target: PatchTarget{Group: "apps", Name: "sub"}, Path: "spec.replicas", Key: "sub.replicas"},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, map[string][]exposedValuePlaceholder{
+		"templates/deploy.yaml": {
+			{marker: "__CHARTIFY_EXPOSED_VALUE_0__", key: []string{"web", "replicas"}},
+			{marker: "__CHARTIFY_EXPOSED_VALUE_1__", key: []string{"web", "image"}},
+		},
+		"charts/sub/templates/deploy.yaml": {
+			{marker: "__CHARTIFY_EXPOSED_VALUE_2__", key: []string{"sub", "replicas"}},
+		},
+	}, placeholders)
+
+	deploy, err := os.ReadFile(filepath.Join(chartDir, "templates", "deploy.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(deploy), "replicas: __CHARTIFY_EXPOSED_VALUE_0__")
+	require.Contains(t, string(deploy), "image: __CHARTIFY_EXPOSED_VALUE_1__")
+	require.Contains(t, string(deploy), `replicas: "2"`, "the ConfigMap must be kept as-is")
+
+	values, err := os.ReadFile(filepath.Join(chartDir, "values.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, `# existing values
+foo: bar
+web:
+  replicas: 2
+  image: nginx:1.0
+sub:
+  replicas: 3
+`, string(values))
+
+	_, err = r.exposeValues(chartDir, []ExposedValue{{Target: PatchTarget{Kind: "StatefulSet"}, Path: "spec.replicas", Key: "replicas"}})
+	require.ErrorContains(t, err, "no rendered resource has the field")
+}
+
+func TestRunner_writeValueDefaults_Conflicts(t *testing.T) {
+	valuesFile := filepath.Join(t.TempDir(), "values.yaml")
+	require.NoError(t, os.WriteFile(valuesFile, []byte("web:\n- nginx\nempty:\n"), 0644))
+
+	r := New(WithLogf(t.Logf))
+
+	replicas := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: "2"}
+
+	require.NoError(t, r.writeValueDefaults(valuesFile, []exposedValueDefault{{key: []string{"empty", "replicas"}, node: replicas}}))
+
+	err := r.writeValueDefaults(valuesFile, []exposedValueDefault{{key: []string{"web", "replicas"}, node: replicas}})
+	require.EqualError(t, err, "setting the default for .Values.web.replicas in "+valuesFile+": .Values.web: expected a YAML mapping but got !!seq")
+
+	err = r.writeValueDefaults(valuesFile, []exposedValueDefault{
+		{key: []string{"replicas"}, node: replicas},
+		{key: []string{"replicas", "web"}, node: replicas},
+	})
+	require.EqualError(t, err, "setting the default for .Values.replicas.web in "+valuesFile+": .Values.replicas: expected a YAML mapping but got !!int")
+
+	values, err := os.ReadFile(valuesFile)
+	require.NoError(t, err)
+	require.Equal(t, "web:\n  - nginx\nempty:\n  replicas: 2\n", string(values), "values.yaml must be left as-is on conflicts")
+}
+
+func TestChartify_ExposedValues(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", "testdata/charts/db", WithChartifyOpts(&ChartifyOpts{
+		ExposedValues: []ExposedValue{
+			{Target: PatchTarget{Kind: "Deployment", Name: "myapp-db"}, Path: "spec.replicas", Key: "db.replicas"},
+			{Target: PatchTarget{Kind: "Deployment", Name: "myapp-db"}, Path: "spec.template.spec.containers[0].image", Key: "db.image"},
+		},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	out, err := exec.Command(helm, "template", "myapp", tmpDir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), "replicas: 1")
+	require.Contains(t, string(out), `image: "nginx:1.16.0"`)
+
+	out, err = exec.Command(helm, "template", "myapp", tmpDir, "--set", "db.replicas=5", "--set", "db.image=nginx:1.27").CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), "replicas: 5")
+	require.Contains(t, string(out), `image: "nginx:1.27"`)
+	require.NotContains(t, string(out), "__CHARTIFY_EXPOSED_VALUE_")
+}
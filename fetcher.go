@@ -0,0 +1,237 @@
+package chartify
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v4/pkg/cli"
+	"helm.sh/helm/v4/pkg/getter"
+	"helm.sh/helm/v4/pkg/helmpath"
+	repov4 "helm.sh/helm/v4/pkg/repo/v1"
+)
+
+// ChartFetcher downloads and extracts charts hosted in classic (non-OCI) helm chart repositories in-process,
+// without running `helm pull`.
+//
+// Chart references in the form of `REPO/CHART` are resolved by looking up REPO in helm's repository config
+// and CHART in the repository's index, honoring helm's environment variables like HELM_REPOSITORY_CONFIG
+// and HELM_REPOSITORY_CACHE.
+type ChartFetcher struct {
+	settings *cli.EnvSettings
+	getters  getter.Providers
+
+	// Logf is the log function used by the fetcher
+	Logf func(string, ...interface{})
+}
+
+// NewChartFetcher returns a ChartFetcher configured from helm's environment variables.
+func NewChartFetcher(logf func(string, ...interface{})) *ChartFetcher {
+	settings := cli.New()
+
+	return &ChartFetcher{
+		settings: settings,
+		getters:  getter.All(settings),
+		Logf:     logf,
+	}
+}
+
+// FetchOpts configures how ChartFetcher fetches a chart.
+type FetchOpts struct {
+	// Version is the exact version or the semver constraint of the chart version to fetch.
+	// The latest stable version is fetched when empty.
+	Version string
+
+	// Verify verifies the chart against its provenance file, using the public keys in Keyring.
+	Verify bool
+
+	// Keyring is the path to the keyring used when Verify is true.
+	Keyring string
+}
+
+// ErrChartVersionNotFound is returned when the repository contains the chart but
+// none of its versions satisfies the requested version.
+var ErrChartVersionNotFound = errors.New("chart version not found")
+
+// isRepoChartRef returns true when the chart reference is in the form of `REPO/CHART`, which
+// can be fetched by ChartFetcher.
+func isRepoChartRef(chart string) bool {
+	if strings.Contains(chart, "://") {
+		return false
+	}
+
+	repoAndChart := strings.Split(chart, "/")
+
+	return len(repoAndChart) == 2 && repoAndChart[0] != "" && repoAndChart[1] != ""
+}
+
+// Fetch downloads the chart referenced as `REPO/CHART` and extracts it under dir.
+// It returns the path to the directory containing the extracted chart.
+func (f *ChartFetcher) Fetch(chart, dir string, o FetchOpts) (string, error) {
+	if !isRepoChartRef(chart) {
+		return "", fmt.Errorf("unable to fetch %q: chart reference must be in the form of REPO/CHART", chart)
+	}
+
+	repoAndChart := strings.Split(chart, "/")
+	repoName, chartName := repoAndChart[0], repoAndChart[1]
+
+	repoFile, err := repov4.LoadFile(f.settings.RepositoryConfig)
+	if err != nil {
+		return "", fmt.Errorf("loading repository config %s: %w", f.settings.RepositoryConfig, err)
+	}
+
+	entry := repoFile.Get(repoName)
+	if entry == nil {
+		return "", fmt.Errorf("no repository named %q found in %s. please `helm repo add` it", repoName, f.settings.RepositoryConfig)
+	}
+
+	cv, err := f.findChartVersion(entry, chartName, o.Version)
+	if err != nil {
+		return "", err
+	}
+
+	if len(cv.URLs) == 0 {
+		return "", fmt.Errorf("chart %q version %s in repository %q has no download URLs", chartName, cv.Version, repoName)
+	}
+
+	chartURL, err := repov4.ResolveReferenceURL(entry.URL, cv.URLs[0])
+	if err != nil {
+		return "", fmt.Errorf("resolving download URL of chart %q version %s: %w", chartName, cv.Version, err)
+	}
+
+	f.Logf("downloading chart %q version %s from %s", chartName, cv.Version, chartURL)
+
+	data, err := f.download(entry, chartURL)
+	if err != nil {
+		return "", fmt.Errorf("downloading chart %q version %s from %s: %w", chartName, cv.Version, chartURL, err)
+	}
+
+	if cv.Digest != "" {
+		sum := sha256.Sum256(data)
+		if got := hex.EncodeToString(sum[:]); got != cv.Digest {
+			return "", fmt.Errorf("digest mismatch for chart %q version %s downloaded from %s: index has %s but got %s", chartName, cv.Version, chartURL, cv.Digest, got)
+		}
+	}
+
+	if o.Verify {
+		if err := f.verify(entry, chartURL, data, o.Keyring); err != nil {
+			return "", err
+		}
+	}
+
+	return ExtractFilesFromChartTGZ(bytes.NewReader(data), dir)
+}
+
+// findChartVersion finds the chart version satisfying the version in the cached index of the repository.
+// The index is downloaded again when it is not cached yet, or the cached one does not contain the requested chart version,
+// so that newly published charts can be fetched without running `helm repo update`.
+func (f *ChartFetcher) findChartVersion(entry *repov4.Entry, chartName, version string) (*repov4.ChartVersion, error) {
+	cachedIndexPath := filepath.Join(f.settings.RepositoryCache, helmpath.CacheIndexFile(entry.Name))
+
+	if index, err := repov4.LoadIndexFile(cachedIndexPath); err == nil {
+		if cv, err := index.Get(chartName, version); err == nil {
+			return cv, nil
+		}
+	}
+
+	f.Logf("updating the index of repository %q", entry.Name)
+
+	chartRepo, err := repov4.NewChartRepository(entry, f.getters)
+	if err != nil {
+		return nil, err
+	}
+	chartRepo.CachePath = f.settings.RepositoryCache
+
+	indexPath, err := chartRepo.DownloadIndexFile()
+	if err != nil {
+		return nil, fmt.Errorf("downloading the index of repository %q: %w", entry.Name, err)
+	}
+
+	index, err := repov4.LoadIndexFile(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading the index of repository %q: %w", entry.Name, err)
+	}
+
+	cv, err := index.Get(chartName, version)
+	if errors.Is(err, repov4.ErrNoChartName) {
+		return nil, fmt.Errorf("chart %q not found in repository %q", chartName, entry.Name)
+	} else if err != nil {
+		var available []string
+		for _, v := range index.Entries[chartName] {
+			available = append(available, v.Version)
+		}
+
+		requested := version
+		if requested == "" {
+			requested = "any stable version"
+		} else if _, err := semver.NewConstraint(version); err != nil {
+			return nil, fmt.Errorf("invalid version %q for chart %q: %w", version, chartName, err)
+		}
+
+		return nil, fmt.Errorf("%w: no version of chart %q in repository %q matches %s. available versions: %s",
+			ErrChartVersionNotFound, chartName, entry.Name, requested, strings.Join(available, ", "))
+	}
+
+	return cv, nil
+}
+
+func (f *ChartFetcher) download(entry *repov4.Entry, u string) ([]byte, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := f.getters.ByScheme(parsed.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := g.Get(u,
+		getter.WithURL(entry.URL),
+		getter.WithInsecureSkipVerifyTLS(entry.InsecureSkipTLSVerify),
+		getter.WithTLSClientConfig(entry.CertFile, entry.KeyFile, entry.CAFile),
+		getter.WithBasicAuth(entry.Username, entry.Password),
+		getter.WithPassCredentialsAll(entry.PassCredentialsAll),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(buf)
+}
+
+// verify downloads the provenance file of the chart and verifies the chart archive data against it.
+func (f *ChartFetcher) verify(entry *repov4.Entry, chartURL string, data []byte, keyring string) error {
+	prov, err := f.download(entry, chartURL+".prov")
+	if err != nil {
+		return fmt.Errorf("downloading provenance file of %s: %w", chartURL, err)
+	}
+
+	d, err := os.MkdirTemp("", "chartify-verify")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(d)
+	}()
+
+	archive := filepath.Join(d, filepath.Base(chartURL))
+
+	if err := os.WriteFile(archive, data, 0644); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(archive+".prov", prov, 0644); err != nil {
+		return err
+	}
+
+	return VerifyChartArchive(archive, keyring)
+}
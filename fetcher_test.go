@@ -0,0 +1,198 @@
+package chartify
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/helmfile/chartify/chartrepo"
+	"github.com/helmfile/chartify/helmtesting"
+)
+
+func TestIsRepoChartRef(t *testing.T) {
+	require.True(t, isRepoChartRef("myrepo/db"))
+	require.False(t, isRepoChartRef("db"))
+	require.False(t, isRepoChartRef("./charts/db"))
+	require.False(t, isRepoChartRef("oci://registry.example.com/charts/db"))
+	require.False(t, isRepoChartRef("myrepo/"))
+	require.False(t, isRepoChartRef("/db"))
+}
+
+func TestChartFetcher_Fetch(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	srv := helmtesting.StartChartRepoServer(t, helmtesting.ChartRepoServerConfig{
+		Port:      chartrepo.RandomPort,
+		ChartsDir: "testdata/charts",
+	})
+	helmtesting.AddChartRepo(t, helm, "fetchrepo", srv)
+
+	tests := []struct {
+		name        string
+		chart       string
+		version     string
+		wantVersion string
+		wantErr     string
+		wantErrIs   error
+	}{
+		{
+			name:        "latest version",
+			chart:       "fetchrepo/db",
+			wantVersion: "0.1.0",
+		},
+		{
+			name:        "exact version",
+			chart:       "fetchrepo/db",
+			version:     "0.1.0",
+			wantVersion: "0.1.0",
+		},
+		{
+			name:        "version constraint",
+			chart:       "fetchrepo/db",
+			version:     "~0.1",
+			wantVersion: "0.1.0",
+		},
+		{
+			name:      "missing version",
+			chart:     "fetchrepo/db",
+			version:   "9.9.9",
+			wantErr:   `no version of chart "db" in repository "fetchrepo" matches 9.9.9. available versions: 0.1.0`,
+			wantErrIs: ErrChartVersionNotFound,
+		},
+		{
+			name:    "invalid version",
+			chart:   "fetchrepo/db",
+			version: "not-a-version",
+			wantErr: `invalid version "not-a-version" for chart "db"`,
+		},
+		{
+			name:    "missing chart",
+			chart:   "fetchrepo/nonexistent",
+			wantErr: `chart "nonexistent" not found in repository "fetchrepo"`,
+		},
+		{
+			name:    "missing repository",
+			chart:   "norepo/db",
+			wantErr: `no repository named "norepo" found`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewChartFetcher(t.Logf)
+
+			dir, err := f.Fetch(tt.chart, t.TempDir(), FetchOpts{Version: tt.version})
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErr)
+				if tt.wantErrIs != nil {
+					require.ErrorIs(t, err, tt.wantErrIs)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+
+			chartYaml, err := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+			require.NoError(t, err)
+			require.Contains(t, string(chartYaml), "version: "+tt.wantVersion)
+		})
+	}
+
+	t.Run("runner", func(t *testing.T) {
+		var commands [][]string
+
+		newRunner := func(opts ...Option) *Runner {
+			r := New(append([]Option{HelmBin(helm), WithLogf(t.Logf)}, opts...)...)
+			runCommand := r.RunCommand
+			r.RunCommand = func(name string, args []string, dir string, stdout, stderr io.Writer, env map[string]string) error {
+				commands = append(commands, append([]string{name}, args...))
+				return runCommand(name, args, dir, stdout, stderr, env)
+			}
+			return r
+		}
+
+		// helm pull is used by default so that RunCommand and the helm binary are honored
+		dir, err := newRunner().fetchAndUntarUnderDir("fetchrepo/db", t.TempDir(), &ChartifyOpts{})
+		require.NoError(t, err)
+		require.FileExists(t, filepath.Join(dir, "Chart.yaml"))
+		require.Contains(t, commands, []string{helm, "pull", "fetchrepo/db", "--untar", "-d", filepath.Dir(dir)})
+
+		commands = nil
+
+		dir, err = newRunner(UseInProcessChartFetch(true)).fetchAndUntarUnderDir("fetchrepo/db", t.TempDir(), &ChartifyOpts{})
+		require.NoError(t, err)
+		require.FileExists(t, filepath.Join(dir, "Chart.yaml"))
+		require.Empty(t, commands)
+	})
+}
+
+func TestChartFetcher_Fetch_FlakyRepo(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	tests := []struct {
+		name    string
+		faults  map[string]chartrepo.Fault
+		wantErr string
+	}{
+		{
+			name: "server error",
+			faults: map[string]chartrepo.Fault{
+				"/db-0.1.0.tgz": {StatusCode: http.StatusServiceUnavailable},
+			},
+			wantErr: "503 Service Unavailable",
+		},
+		{
+			name: "corrupt digest",
+			faults: map[string]chartrepo.Fault{
+				"/db-0.1.0.tgz": {CorruptDigest: true},
+			},
+			wantErr: `digest mismatch for chart "db" version 0.1.0`,
+		},
+		{
+			name: "truncated body",
+			faults: map[string]chartrepo.Fault{
+				"/db-0.1.0.tgz": {TruncateAfter: 100},
+			},
+			wantErr: "unexpected EOF",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupHelmConfig(t)
+
+			s := &chartrepo.Server{
+				ChartsDir: "testdata/charts",
+				HelmBin:   helm,
+				Faults:    tt.faults,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			serverURL, err := s.Start(ctx)
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				cancel()
+				require.NoError(t, s.Wait())
+			})
+
+			helmtesting.AddChartRepo(t, helm, "flakyrepo", helmtesting.ChartRepoServer(serverURL))
+
+			_, err = NewChartFetcher(t.Logf).Fetch("flakyrepo/db", t.TempDir(), FetchOpts{})
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.wantErr)
+			require.NotEmpty(t, s.RequestsTo("/db-0.1.0.tgz"))
+		})
+	}
+}
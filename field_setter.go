@@ -0,0 +1,190 @@
+package chartify
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FieldSetter sets a field of every resource its target matches to the value,
+// like a JSON merge patch that sets a single field but can also select sequence items.
+type FieldSetter struct {
+	// Target selects the resources, in the same format as the target of JsonPatches.
+	// An empty Target selects every resource.
+	Target PatchTarget `yaml:"target,omitempty"`
+
+	// Path is the path to the field within the resource, like `spec.replicas` or `spec.template.spec.containers[0].image`.
+	// Sequence items can be selected either by `[N]`, `.N`, or a JSONPath filter on a field like `[?(@.name=="app")]`,
+	// which selects every item whose field has the value, like `spec.template.spec.containers[?(@.name=="app")].image`.
+	// The selected items must exist.
+	// Keys containing dots can be quoted in brackets, like `metadata.annotations["helm.sh/hook"]`.
+	// Missing mappings along the path are created.
+	Path string `yaml:"path"`
+
+	// Value is the new value of the field, which can also be a mapping or a sequence.
+	Value interface{} `yaml:"value"`
+}
+
+// ParseFieldSetter parses the `[KIND[/NAME]:]PATH=VALUE` notation of a FieldSetter, like
+// `Deployment/myapp-db:spec.replicas=3`.
+// VALUE is parsed as YAML, so that `3` is a number and `"3"` is a string.
+func ParseFieldSetter(s string) (FieldSetter, error) {
+	target, value, ok := cutUnquoted(s, '=')
+	if !ok || target == "" {
+		return FieldSetter{}, fmt.Errorf("invalid field setter %q: it must be in the form of [KIND[/NAME]:]PATH=VALUE", s)
+	}
+
+	setter := FieldSetter{Path: target}
+
+	if selector, path, ok := cutUnquoted(target, ':'); ok {
+		kind, name, _ := strings.Cut(selector, "/")
+		setter.Path = path
+		setter.Target.Kind = regexp.QuoteMeta(kind)
+		setter.Target.Name = regexp.QuoteMeta(name)
+	}
+
+	if err := yaml.Unmarshal([]byte(value), &setter.Value); err != nil {
+		return FieldSetter{}, fmt.Errorf("invalid value in field setter %q: %w", s, err)
+	}
+
+	return setter, nil
+}
+
+// cutUnquoted is like strings.Cut, but ignores the separators in double-quoted keys and brackets of the path,
+// like `metadata.annotations["example.com/a=b"]` or `spec.containers[?(@.name=="app")]`.
+func cutUnquoted(s string, sep byte) (string, string, bool) {
+	var (
+		quoted   bool
+		brackets int
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quoted && s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case quoted:
+		case s[i] == '[':
+			brackets++
+		case s[i] == ']' && brackets > 0:
+			brackets--
+		case brackets == 0 && s[i] == sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+
+	return s, "", false
+}
+
+// applyFieldSetters sets the fields of the resources matching the targets of the setters.
+// Other fields of the resources are kept as they were, including their order and comments.
+func applyFieldSetters(resources []*Resource, setters []FieldSetter) ([]*Resource, error) {
+	for i, s := range setters {
+		report := PatchReport{Type: PatchTypeFieldSetter, Source: s.Path, Index: i}
+
+		path, err := parseFieldPath(s.Path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", report, err)
+		}
+
+		for j, res := range resources {
+			ok, err := s.Target.Matches(res)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", report, err)
+			}
+
+			if !ok {
+				continue
+			}
+
+			// Parse the resource again to leave the original node untouched
+			var doc yaml.Node
+			if err := yaml.Unmarshal(res.Raw, &doc); err != nil {
+				return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+			}
+
+			var value yaml.Node
+			if err := value.Encode(s.Value); err != nil {
+				return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+			}
+
+			if err := setField(&doc, path, &value); err != nil {
+				return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+			}
+
+			if resources[j], err = resourceFromNode(&doc); err != nil {
+				return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+			}
+		}
+	}
+
+	return resources, nil
+}
+
+// setField sets the field at the path within the document to the value, creating missing mappings along the path.
+func setField(doc *yaml.Node, path []string, value *yaml.Node) error {
+	return setFieldAt(documentRoot(doc), path, 0, value)
+}
+
+// setFieldAt sets the field at path[i:] within the node found at path[:i] to the value.
+// A filter in the path sets the field of every sequence item it selects.
+func setFieldAt(node *yaml.Node, path []string, i int, value *yaml.Node) error {
+	s, last := path[i], i == len(path)-1
+
+	// Treat an empty field like `labels:` as an empty mapping
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		node.Kind, node.Tag, node.Value = yaml.MappingNode, "!!map", ""
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		child := mappingValue(node, s)
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}, child)
+		}
+
+		if last {
+			*child = *value
+			return nil
+		}
+
+		return setFieldAt(child, path, i+1, value)
+	case yaml.SequenceNode:
+		items := sequenceItems(node, s)
+		if len(items) == 0 {
+			return fmt.Errorf("%s has no item %s", joinFieldPath(path[:i]), s)
+		}
+
+		for _, item := range items {
+			if last {
+				*item = *value
+				continue
+			}
+
+			if err := setFieldAt(item, path, i+1, value); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%s is neither a mapping nor a sequence", joinFieldPath(path[:i]))
+	}
+}
+
+// joinFieldPath joins the segments of a field path parsed by parseFieldPath, quoting the keys containing dots.
+func joinFieldPath(segments []string) string {
+	var path string
+	for _, s := range segments {
+		if _, _, ok := fieldFilter(s); ok {
+			path += "[" + s + "]"
+			continue
+		}
+		path = appendFieldPath(path, s)
+	}
+	return path
+}
@@ -0,0 +1,115 @@
+package chartify
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFieldSetter(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want FieldSetter
+	}{
+		{"spec.replicas=3", FieldSetter{Path: "spec.replicas", Value: 3}},
+		{`Deployment:metadata.labels.tier="3"`, FieldSetter{Target: PatchTarget{Kind: "Deployment"}, Path: "metadata.labels.tier", Value: "3"}},
+		{"Deployment/my.db:spec.template.spec.containers[0].image=postgres:16", FieldSetter{
+			Target: PatchTarget{Kind: "Deployment", Name: `my\.db`},
+			Path:   "spec.template.spec.containers[0].image",
+			Value:  "postgres:16",
+		}},
+		{"spec.paused=", FieldSetter{Path: "spec.paused"}},
+		{`Deployment:spec.template.spec.containers[?(@.name=="app")].image=nginx`, FieldSetter{
+			Target: PatchTarget{Kind: "Deployment"},
+			Path:   `spec.template.spec.containers[?(@.name=="app")].image`,
+			Value:  "nginx",
+		}},
+		{`Job:metadata.annotations["example.com/a=b:c"]=x=y`, FieldSetter{
+			Target: PatchTarget{Kind: "Job"},
+			Path:   `metadata.annotations["example.com/a=b:c"]`,
+			Value:  "x=y",
+		}},
+	} {
+		got, err := ParseFieldSetter(tc.in)
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.want, got, tc.in)
+	}
+
+	_, err := ParseFieldSetter("spec.replicas")
+	require.EqualError(t, err, `invalid field setter "spec.replicas": it must be in the form of [KIND[/NAME]:]PATH=VALUE`)
+}
+
+func TestApplyFieldSetters(t *testing.T) {
+	resources, err := ReadResources(strings.NewReader(`# web
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+spec:
+  replicas: 1 # overridden
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0
+      - name: sidecar
+        image: sidecar:1.0
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+`))
+	require.NoError(t, err)
+
+	patched, err := applyFieldSetters(append([]*Resource{}, resources...), []FieldSetter{
+		{Target: PatchTarget{Kind: "Deployment"}, Path: "spec.replicas", Value: 3},
+		{Target: PatchTarget{Kind: "Deployment"}, Path: "spec.template.spec.containers.0.image", Value: "web:2.0"},
+		{Target: PatchTarget{Kind: "Deployment"}, Path: `spec.template.spec.containers[?(@.name=="sidecar")].image`, Value: "sidecar:2.0"},
+		{Path: "metadata.labels.tier", Value: "frontend"},
+		{Target: PatchTarget{Kind: "Deployment"}, Path: `metadata.annotations["helm.sh/hook"]`, Value: "pre-install"},
+		{Target: PatchTarget{Kind: "ConfigMap"}, Path: "data", Value: map[string]interface{}{"a": "b"}},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, `# web
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    tier: frontend
+  annotations:
+    helm.sh/hook: pre-install
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: web
+          image: web:2.0
+        - name: sidecar
+          image: sidecar:2.0
+`, string(patched[0].Raw))
+	require.Equal(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  labels:
+    tier: frontend
+data:
+  a: b
+`, string(patched[1].Raw))
+	require.Contains(t, string(resources[0].Raw), "replicas: 1", "the original resources must be left untouched")
+
+	_, err = applyFieldSetters(resources, []FieldSetter{{Target: PatchTarget{Kind: "Deployment"}, Path: "spec.template.spec.containers[2].image", Value: "x"}})
+	require.EqualError(t, err, "applying field setter #0 at spec.template.spec.containers[2].image to apps/v1 Deployment web: spec.template.spec.containers has no item 2")
+
+	_, err = applyFieldSetters(resources, []FieldSetter{{Target: PatchTarget{Kind: "Deployment"}, Path: `spec.template.spec.containers[?(@.name=='db')].image`, Value: "x"}})
+	require.EqualError(t, err, `applying field setter #0 at spec.template.spec.containers[?(@.name=='db')].image to apps/v1 Deployment web: spec.template.spec.containers has no item ?(@.name=="db")`)
+
+	_, err = applyFieldSetters(resources, []FieldSetter{{Path: "kind.name", Value: "x"}})
+	require.EqualError(t, err, "applying field setter #0 at kind.name to apps/v1 Deployment web: kind is neither a mapping nor a sequence")
+}
@@ -0,0 +1,331 @@
+package chartify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+	registryv3 "helm.sh/helm/v3/pkg/registry"
+	registryv4 "helm.sh/helm/v4/pkg/registry"
+)
+
+// HelmVersionAdapter encapsulates everything chartify does differently depending on the major version of Helm
+// it drives, so that the rest of chartify can stay version-agnostic.
+//
+// Helm 3 and Helm 4 are supported first-class. Helm 2 is still supported via a legacy adapter that is used only
+// when the detected (or explicitly requested) Helm binary is neither Helm 3 nor Helm 4.
+type HelmVersionAdapter interface {
+	// MajorVersion returns the major version of Helm this adapter handles.
+	MajorVersion() uint64
+
+	// IsOCI reports whether the chart reference points to an OCI registry that this version of Helm can pull from.
+	IsOCI(chart string) bool
+
+	// DependencyFlags returns the additional flags to pass to `helm dependency up` and `helm dependency build`.
+	DependencyFlags(u *ChartifyOpts) []string
+
+	// UpdateDependencies writes the given dependencies into the chart metadata under chartPath.
+	// reqs is the content of the chart's requirements.yaml, if any.
+	// See Runner.UpdateRequirements for the meaning of replace and the returned dependencies.
+	UpdateDependencies(r *Runner, replace bool, chartYamlPath, chartPath string, reqs Requirements, deps []Dependency) ([]Dependency, error)
+
+	// ClearDependencies removes all the dependencies from the chart metadata under chartPath,
+	// so that Helm won't try to fetch dependencies that are already rendered into the chart.
+	ClearDependencies(r *Runner, chartName, chartPath string) error
+
+	// TemplateArgs returns the arguments to `helm` for rendering the chart at chartPath into outputDir,
+	// or to stdout when outputDir is empty.
+	TemplateArgs(name, chartPath, outputDir string, o ReplaceWithRenderedOpts) []string
+
+	// CRDsDir returns the directory under chartPath where patched CRDs are written
+	// when they don't need to be kept in the templates/ directory.
+	CRDsDir(chartPath string) string
+
+	// ChartAPIVersions returns the apiVersions of Chart.yaml this version of Helm is able to load.
+	ChartAPIVersions() []string
+}
+
+// WithHelmVersionAdapter overrides the Helm version adapter that is otherwise selected by
+// detecting the version of the Helm binary.
+func WithHelmVersionAdapter(a HelmVersionAdapter) Option {
+	return func(r *Runner) error {
+		r.helmAdapter = a
+		return nil
+	}
+}
+
+// HelmAdapter returns the HelmVersionAdapter for the Helm binary used by the runner.
+func (r *Runner) HelmAdapter() HelmVersionAdapter {
+	if r.helmAdapter != nil {
+		return r.helmAdapter
+	}
+
+	switch {
+	case r.isHelm4:
+		return helm4Adapter{}
+	case r.IsHelm3():
+		return helm3Adapter{}
+	case r.IsHelm4():
+		return helm4Adapter{}
+	default:
+		return helm2Adapter{}
+	}
+}
+
+// chartMetadata is the part of Chart.yaml chartify needs to rewrite, with every other field preserved as-is.
+type chartMetadata struct {
+	Dependencies []Dependency           `yaml:"dependencies,omitempty"`
+	Data         map[string]interface{} `yaml:",inline"`
+}
+
+func (r *Runner) readChartMetadata(chartYamlPath string) (*chartMetadata, error) {
+	var chartMeta chartMetadata
+
+	bytes, err := r.ReadFile(chartYamlPath)
+	if os.IsNotExist(err) {
+
+	} else if err != nil {
+		return nil, err
+	} else {
+		if err := yaml.Unmarshal(bytes, &chartMeta); err != nil {
+			return nil, err
+		}
+	}
+
+	return &chartMeta, nil
+}
+
+type helm3Adapter struct{}
+
+func (helm3Adapter) MajorVersion() uint64 {
+	return 3
+}
+
+func (helm3Adapter) IsOCI(chart string) bool {
+	return registryv3.IsOCI(chart)
+}
+
+func (helm3Adapter) DependencyFlags(u *ChartifyOpts) []string {
+	return verifyFlags(u.Verify, u.Keyring)
+}
+
+func (helm3Adapter) UpdateDependencies(r *Runner, replace bool, chartYamlPath, chartPath string, reqs Requirements, deps []Dependency) ([]Dependency, error) {
+	chartMeta, err := r.readChartMetadata(chartYamlPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []Dependency
+
+	all = append(all, chartMeta.Dependencies...)
+	all = append(all, reqs.Dependencies...)
+	all = append(all, deps...)
+
+	if replace {
+		// When it's a remote chart, the helm-fetch preceded this chartification step
+		// should have been already downloaded all the dependencies into the charts/ directory.
+		//
+		// In that case, we need to remove the original Chart.yaml's `dependencies` to
+		// avoid failing due to unnecessarily trying to fetch chart dependencies.
+		//
+		// Note that this depends on how `helm package` used to package the chart served by the chart repo server works.
+		// We assume that `helm package` to enforce the package to contains `charts/*.tgz` for every dependency declared in Chart.yaml or requirements.yaml.
+		// If the package somehow misses the `charts/*.tgz` files even though it has one ore more dependencies in either Chart.yaml or requirements.yaml,
+		// this assumption breaks and chartify might not work well.
+		chartMeta.Dependencies = deps
+	} else {
+		chartMeta.Dependencies = all
+	}
+
+	chartYamlContent, err := yaml.Marshal(chartMeta)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling-back Chart.yaml: %w", err)
+	}
+
+	r.Logf("Removing the dependencies field from the original Chart.yaml.")
+
+	if err := r.WriteFile(filepath.Join(chartPath, "Chart.yaml"), chartYamlContent, 0644); err != nil {
+		return nil, err
+	}
+
+	// We already merged requirements.yaml into Chart.yaml's dependencies field
+	// so we don't need requirements anymore.
+	reqYaml := filepath.Join(chartPath, "requirements.yaml")
+	if _, err := os.Stat(reqYaml); err == nil {
+		r.Logf("Removing requirements.yaml as unneeded. charts/ should have already been populated by helm-fetch.")
+		if err := os.Remove(reqYaml); err != nil {
+			return nil, err
+		}
+	}
+
+	return all, nil
+}
+
+func (helm3Adapter) ClearDependencies(r *Runner, chartName, chartPath string) error {
+	chartYamlPath := filepath.Join(chartPath, "Chart.yaml")
+
+	chartMeta, err := r.readChartMetadata(chartYamlPath)
+	if err != nil {
+		return err
+	}
+
+	chartMeta.Dependencies = nil
+
+	chartYamlContent, err := yaml.Marshal(chartMeta)
+	if err != nil {
+		return fmt.Errorf("marshaling-back %s's Chart.yaml: %w", chartName, err)
+	}
+
+	r.Logf("Removing the dependencies field from the original Chart.yaml.")
+
+	return r.WriteFile(chartYamlPath, chartYamlContent, 0644)
+}
+
+func (helm3Adapter) TemplateArgs(name, chartPath, outputDir string, o ReplaceWithRenderedOpts) []string {
+	args := []string{
+		"template",
+		fmt.Sprintf("--debug=%v", o.Debug),
+	}
+
+	if outputDir != "" {
+		args = append(args, fmt.Sprintf("--output-dir=%s", outputDir))
+	}
+
+	if o.IncludeCRDs {
+		args = append(args, "--include-crds")
+	}
+
+	if o.Validate {
+		args = append(args, "--validate")
+	}
+
+	return append(args, name, chartPath)
+}
+
+func (helm3Adapter) CRDsDir(chartPath string) string {
+	return filepath.Join(chartPath, "crds")
+}
+
+func (helm3Adapter) ChartAPIVersions() []string {
+	return []string{ChartAPIVersionV1, ChartAPIVersionV2}
+}
+
+// helm4Adapter behaves like helm3Adapter except where Helm 4 differs.
+type helm4Adapter struct {
+	helm3Adapter
+}
+
+func (helm4Adapter) MajorVersion() uint64 {
+	return 4
+}
+
+func (helm4Adapter) IsOCI(chart string) bool {
+	return registryv4.IsOCI(chart)
+}
+
+func (a helm4Adapter) DependencyFlags(u *ChartifyOpts) []string {
+	var flags []string
+
+	// Helm 4 requires --plain-http for HTTP-only OCI registries
+	if u.OCIPlainHTTP {
+		flags = append(flags, "--plain-http")
+	}
+
+	return append(flags, a.helm3Adapter.DependencyFlags(u)...)
+}
+
+func (helm4Adapter) ChartAPIVersions() []string {
+	return []string{ChartAPIVersionV1, ChartAPIVersionV2, ChartAPIVersionV3}
+}
+
+// helm2Adapter is the legacy adapter for Helm 2, which uses requirements.yaml instead of Chart.yaml's dependencies
+// and has no dedicated crds/ directory.
+type helm2Adapter struct{}
+
+func (helm2Adapter) MajorVersion() uint64 {
+	return 2
+}
+
+func (helm2Adapter) IsOCI(chart string) bool {
+	return false
+}
+
+func (helm2Adapter) DependencyFlags(u *ChartifyOpts) []string {
+	return verifyFlags(u.Verify, u.Keyring)
+}
+
+func (helm2Adapter) UpdateDependencies(r *Runner, replace bool, chartYamlPath, chartPath string, reqs Requirements, deps []Dependency) ([]Dependency, error) {
+	var all []Dependency
+
+	all = append(all, reqs.Dependencies...)
+	all = append(all, deps...)
+
+	if replace {
+		reqs.Dependencies = all
+	} else {
+		reqs.Dependencies = deps
+	}
+
+	if err := writeRequirements(r, chartPath, reqs); err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+func (helm2Adapter) ClearDependencies(r *Runner, chartName, chartPath string) error {
+	reqs, err := r.readRequirements(chartPath)
+	if err != nil {
+		return err
+	}
+
+	reqs.Dependencies = nil
+
+	return writeRequirements(r, chartPath, *reqs)
+}
+
+func (helm2Adapter) TemplateArgs(name, chartPath, outputDir string, o ReplaceWithRenderedOpts) []string {
+	args := []string{
+		"template",
+		fmt.Sprintf("--debug=%v", o.Debug),
+		chartPath,
+		"--name", name,
+	}
+
+	if outputDir != "" {
+		args = append(args, "--output-dir", outputDir)
+	}
+
+	return args
+}
+
+func (helm2Adapter) CRDsDir(chartPath string) string {
+	return filepath.Join(chartPath, "templates")
+}
+
+func (helm2Adapter) ChartAPIVersions() []string {
+	// Helm 2 doesn't check apiVersion, and loads v2 charts like the ones chartify generates as if they were v1
+	return []string{ChartAPIVersionV1, ChartAPIVersionV2}
+}
+
+func writeRequirements(r *Runner, chartPath string, reqs Requirements) error {
+	requirementsYamlContent, err := yaml.Marshal(&reqs)
+	if err != nil {
+		return fmt.Errorf("marshaling requirements as YAML: %w", err)
+	}
+
+	reqYaml := filepath.Join(chartPath, "requirements.yaml")
+
+	if err := r.WriteFile(reqYaml, requirementsYamlContent, 0644); err != nil {
+		return err
+	}
+
+	debugOut, err := r.ReadFile(reqYaml)
+	if err != nil {
+		return err
+	}
+	r.Logf("using requirements.yaml:\n%s", debugOut)
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package chartify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner_HelmAdapter(t *testing.T) {
+	require.Equal(t, uint64(4), New(UseHelm4(true)).HelmAdapter().MajorVersion())
+	require.Equal(t, uint64(3), New(UseHelm3(true)).HelmAdapter().MajorVersion())
+	require.Equal(t, uint64(2), New(UseHelm3(true), WithHelmVersionAdapter(helm2Adapter{})).HelmAdapter().MajorVersion())
+}
+
+func TestHelmVersionAdapter_TemplateArgs(t *testing.T) {
+	o := ReplaceWithRenderedOpts{IncludeCRDs: true, Validate: true}
+
+	require.Equal(t,
+		[]string{"template", "--debug=false", "--output-dir=out", "--include-crds", "--validate", "myapp", "chart"},
+		helm3Adapter{}.TemplateArgs("myapp", "chart", "out", o),
+	)
+	require.Equal(t,
+		[]string{"template", "--debug=false", "--output-dir=out", "--include-crds", "--validate", "myapp", "chart"},
+		helm4Adapter{}.TemplateArgs("myapp", "chart", "out", o),
+	)
+	require.Equal(t,
+		[]string{"template", "--debug=false", "chart", "--name", "myapp", "--output-dir", "out"},
+		helm2Adapter{}.TemplateArgs("myapp", "chart", "out", o),
+	)
+}
+
+func TestHelmVersionAdapter_DependencyFlags(t *testing.T) {
+	u := &ChartifyOpts{OCIPlainHTTP: true, Verify: true, Keyring: "pubring.gpg"}
+
+	require.Equal(t, []string{"--verify", "--keyring", "pubring.gpg"}, helm3Adapter{}.DependencyFlags(u))
+	require.Equal(t, []string{"--plain-http", "--verify", "--keyring", "pubring.gpg"}, helm4Adapter{}.DependencyFlags(u))
+	require.Empty(t, helm4Adapter{}.DependencyFlags(&ChartifyOpts{}))
+}
+
+func TestHelmVersionAdapter_CRDsDir(t *testing.T) {
+	require.Equal(t, filepath.Join("chart", "crds"), helm3Adapter{}.CRDsDir("chart"))
+	require.Equal(t, filepath.Join("chart", "crds"), helm4Adapter{}.CRDsDir("chart"))
+	require.Equal(t, filepath.Join("chart", "templates"), helm2Adapter{}.CRDsDir("chart"))
+}
+
+func TestHelmVersionAdapter_Dependencies(t *testing.T) {
+	adhoc := []Dependency{{Name: "adhoc", Repository: "https://example.com/charts", Version: "1.0.0"}}
+
+	t.Run("helm3 merges requirements.yaml into Chart.yaml", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("apiVersion: v2\nname: app\ndependencies:\n- name: db\n  version: 0.1.0\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "requirements.yaml"), []byte("dependencies:\n- name: cache\n  version: 0.2.0\n"), 0644))
+
+		r := New(UseHelm3(true), WithLogf(t.Logf))
+
+		all, err := r.UpdateRequirements(false, filepath.Join(dir, "Chart.yaml"), dir, adhoc)
+		require.NoError(t, err)
+		require.Equal(t, []string{"db", "cache", "adhoc"}, dependencyNames(all))
+		require.NoFileExists(t, filepath.Join(dir, "requirements.yaml"))
+
+		chartYaml, err := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+		require.NoError(t, err)
+		require.Contains(t, string(chartYaml), "name: cache")
+		require.Contains(t, string(chartYaml), "name: app")
+
+		require.NoError(t, r.HelmAdapter().ClearDependencies(r, "app", dir))
+
+		chartYaml, err = os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+		require.NoError(t, err)
+		require.NotContains(t, string(chartYaml), "dependencies")
+		require.Contains(t, string(chartYaml), "name: app")
+	})
+
+	t.Run("helm2 writes requirements.yaml", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("apiVersion: v1\nname: app\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "requirements.yaml"), []byte("dependencies:\n- name: cache\n  version: 0.2.0\n"), 0644))
+
+		r := New(WithHelmVersionAdapter(helm2Adapter{}), WithLogf(t.Logf))
+
+		all, err := r.UpdateRequirements(true, filepath.Join(dir, "Chart.yaml"), dir, adhoc)
+		require.NoError(t, err)
+		require.Equal(t, []string{"cache", "adhoc"}, dependencyNames(all))
+
+		reqs, err := r.readRequirements(dir)
+		require.NoError(t, err)
+		require.Equal(t, []string{"cache", "adhoc"}, dependencyNames(reqs.Dependencies))
+
+		require.NoError(t, r.HelmAdapter().ClearDependencies(r, "app", dir))
+
+		reqs, err = r.readRequirements(dir)
+		require.NoError(t, err)
+		require.Empty(t, reqs.Dependencies)
+	})
+}
+
+func dependencyNames(deps []Dependency) []string {
+	var names []string
+	for _, d := range deps {
+		names = append(names, d.Name)
+	}
+	return names
+}
@@ -2,7 +2,6 @@ package helmtesting
 
 import (
 	"context"
-	"net/http"
 	"os/exec"
 	"testing"
 	"time"
@@ -21,42 +20,18 @@ func (r ChartRepoServer) URL() string {
 
 // StartChartRepoServer starts a local helm chart server and returns ChartRepoServer that
 // contains various information like the local server's URL.
+// Set srv.Port to chartrepo.RandomPort to let the server listen on a random free port.
 func StartChartRepoServer(t *testing.T, srv ChartRepoServerConfig) ChartRepoServer {
 	t.Helper()
 
-	srvErr := make(chan error)
-	ctx, cancel := context.WithCancel(context.Background())
-	go func() {
-		srvErr <- srv.Run(ctx)
-	}()
-
-	srvStart := make(chan struct{})
-	ticker := time.NewTicker(1 * time.Second)
-	go func() {
-		defer ticker.Stop()
-		for range ticker.C {
-			res, err := http.Get(srv.ServerURL() + "index.yaml")
-			if err == nil && res.StatusCode == http.StatusOK {
-				_ = res.Body.Close()
-				break
-			}
-
-			if res != nil {
-				_ = res.Body.Close()
-				t.Logf("Waiting for chartrepo server to start: code=%d", res.StatusCode)
-			} else {
-				t.Logf("Waiting for chartrepo server to start: error=%v", err)
-			}
-		}
+	s := &srv
 
-		srvStart <- struct{}{}
-	}()
+	ctx, cancel := context.WithCancel(context.Background())
 
-	select {
-	case <-srvStart:
-		t.Logf("chartrepo server started")
-	case <-time.After(10 * time.Second):
-		t.Fatalf("unable to restart chartrepo server within 10 seconds")
+	serverURL, err := s.Start(ctx)
+	if err != nil {
+		cancel()
+		t.Fatalf("unable to start chartrepo server: %v", err)
 	}
 
 	t.Cleanup(func() {
@@ -64,19 +39,14 @@ func StartChartRepoServer(t *testing.T, srv ChartRepoServerConfig) ChartRepoServ
 
 		cancel()
 
-		select {
-		case err := <-srvErr:
-			if err != nil {
-				t.Log("cleanup: stopping chartrepo server: " + err.Error())
-			}
-		case <-time.After(3 * time.Second):
-			t.Log("cleanup: unable to stop chartrepo server within 3 seconds")
+		if err := s.Wait(); err != nil {
+			t.Log("cleanup: stopping chartrepo server: " + err.Error())
 		}
 	})
 
-	t.Logf("Started chartrepo server")
+	t.Logf("Started chartrepo server at %s", serverURL)
 
-	return ChartRepoServer(srv.ServerURL())
+	return ChartRepoServer(serverURL)
 }
 
 // AddChartRepo names the specified chart repo server so that it can be used by helm as a chart repo
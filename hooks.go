@@ -0,0 +1,121 @@
+package chartify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// HookAnnotation is the annotation Helm uses to mark a resource as a hook.
+	// See https://helm.sh/docs/topics/charts_hooks/
+	HookAnnotation = "helm.sh/hook"
+
+	// hooksFileName is the file under templates/ to which patched hooks other than tests are written.
+	hooksFileName = "patched_hooks.yaml"
+
+	// testHooksFileName is the file under templates/tests/ to which patched test hooks are written.
+	testHooksFileName = "patched_tests.yaml"
+)
+
+// Annotation returns the value of the annotation on the resource.
+func (r *Resource) Annotation(key string) (string, bool) {
+	annotations := mappingValue(mappingValue(documentRoot(r.Node), "metadata"), "annotations")
+	if v := mappingValue(annotations, key); v != nil {
+		return v.Value, true
+	}
+	return "", false
+}
+
+// Hooks returns the Helm hooks the resource is registered to, like pre-install or test.
+// It returns nil when the resource is not a hook.
+func (r *Resource) Hooks() []string {
+	v, ok := r.Annotation(HookAnnotation)
+	if !ok {
+		return nil
+	}
+
+	var hooks []string
+	for _, h := range strings.Split(v, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks
+}
+
+// IsTestHook returns true when the resource is a Helm test, which is run by `helm test` rather than
+// installed along with the release.
+func (r *Resource) IsTestHook() bool {
+	for _, h := range r.Hooks() {
+		// test-success and test-failure are the Helm 2 names of test hooks. Helm 3 still supports the former
+		if h == "test" || h == "test-success" || h == "test-failure" {
+			return true
+		}
+	}
+	return false
+}
+
+// dropTestHooks removes Helm test hooks from the rendered manifest files.
+// Files that contain nothing but test hooks are removed, and the remaining files are returned.
+func (r *Runner) dropTestHooks(files []string) ([]string, error) {
+	var remaining []string
+
+	for _, f := range files {
+		resources, err := ReadResourcesFromFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var kept []*Resource
+		for _, res := range resources {
+			if res.IsTestHook() {
+				r.Logf("Dropping test hook %s/%s in %s", res.Kind(), res.Name(), f)
+				continue
+			}
+			kept = append(kept, res)
+		}
+
+		if len(kept) == len(resources) {
+			remaining = append(remaining, f)
+			continue
+		}
+
+		if len(kept) == 0 {
+			if err := os.Remove(f); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := WriteResources(&buf, kept); err != nil {
+			return nil, err
+		}
+
+		if err := r.WriteFile(f, buf.Bytes(), 0644); err != nil {
+			return nil, fmt.Errorf("writing %s: %w", f, err)
+		}
+
+		remaining = append(remaining, f)
+	}
+
+	return remaining, nil
+}
+
+// restrictTargetToHooks modifies the target of the kustomize patch entry so that it selects only Helm hooks.
+func restrictTargetToHooks(entry map[string]interface{}) error {
+	target, ok := entry["target"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("hook patches must have a target")
+	}
+
+	selector := HookAnnotation
+	if s, ok := target["annotationSelector"].(string); ok && s != "" {
+		selector = s + "," + HookAnnotation
+	}
+	target["annotationSelector"] = selector
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package chartify
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResource_Hooks(t *testing.T) {
+	tests := []struct {
+		input    string
+		hooks    []string
+		testHook bool
+	}{
+		{
+			input: "kind: Pod\nmetadata:\n  name: a\n",
+		},
+		{
+			input: "kind: Job\nmetadata:\n  annotations:\n    helm.sh/hook: pre-install, pre-upgrade\n",
+			hooks: []string{"pre-install", "pre-upgrade"},
+		},
+		{
+			input:    "kind: Pod\nmetadata:\n  annotations:\n    helm.sh/hook: test\n",
+			hooks:    []string{"test"},
+			testHook: true,
+		},
+		{
+			input:    "kind: Pod\nmetadata:\n  annotations:\n    helm.sh/hook: test-success\n",
+			hooks:    []string{"test-success"},
+			testHook: true,
+		},
+	}
+
+	for _, tt := range tests {
+		resources, err := ReadResources(strings.NewReader(tt.input))
+		require.NoError(t, err)
+		require.Len(t, resources, 1)
+
+		require.Equal(t, tt.hooks, resources[0].Hooks(), tt.input)
+		require.Equal(t, tt.testHook, resources[0].IsTestHook(), tt.input)
+	}
+}
+
+func TestRestrictTargetToHooks(t *testing.T) {
+	entry := map[string]interface{}{"target": map[string]interface{}{"kind": "Job"}}
+	require.NoError(t, restrictTargetToHooks(entry))
+	require.Equal(t, "helm.sh/hook", entry["target"].(map[string]interface{})["annotationSelector"])
+
+	entry = map[string]interface{}{"target": map[string]interface{}{"kind": "Job", "annotationSelector": "foo=bar"}}
+	require.NoError(t, restrictTargetToHooks(entry))
+	require.Equal(t, "foo=bar,helm.sh/hook", entry["target"].(map[string]interface{})["annotationSelector"])
+
+	err := restrictTargetToHooks(map[string]interface{}{"patch": "kind: Job"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "hook patches must have a target")
+}
+
+func TestRunner_dropTestHooks(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"templates/cm.yaml":               "kind: ConfigMap\nmetadata:\n  name: cm\n",
+		"templates/tests/test.yaml":       "kind: Pod\nmetadata:\n  name: test\n  annotations:\n    helm.sh/hook: test\n",
+		"templates/mixed.yaml":            "kind: ConfigMap\nmetadata:\n  name: mixed\n---\nkind: Pod\nmetadata:\n  name: test2\n  annotations:\n    helm.sh/hook: test\n",
+		"templates/tests/not-a-test.yaml": "kind: Job\nmetadata:\n  name: job\n  annotations:\n    helm.sh/hook: post-install\n",
+	})
+
+	files := []string{
+		filepath.Join(dir, "templates", "cm.yaml"),
+		filepath.Join(dir, "templates", "tests", "test.yaml"),
+		filepath.Join(dir, "templates", "mixed.yaml"),
+		filepath.Join(dir, "templates", "tests", "not-a-test.yaml"),
+	}
+
+	r := New(WithLogf(t.Logf))
+
+	remaining, err := r.dropTestHooks(files)
+	require.NoError(t, err)
+	require.Equal(t, []string{files[0], files[2], files[3]}, remaining)
+	require.NoFileExists(t, files[1])
+
+	mixed, err := os.ReadFile(files[2])
+	require.NoError(t, err)
+	require.Equal(t, "kind: ConfigMap\nmetadata:\n  name: mixed\n", string(mixed))
+}
+
+func TestChartify_DropTestHooks(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", "testdata/charts/db", WithChartifyOpts(&ChartifyOpts{
+		DropTestHooks: true,
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	out, err := exec.Command(helm, "template", "myapp", tmpDir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), "name: myapp-db")
+	require.NotContains(t, string(out), "helm.sh/hook")
+}
+
+func TestChartify_HookPatches(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	chart := t.TempDir()
+	writeTestFiles(t, chart, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: hooks\nversion: 0.1.0\n",
+		"templates/jobs.yaml": `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install,pre-upgrade
+spec:
+  template:
+    spec:
+      containers:
+      - name: migrate
+        image: migrate
+      restartPolicy: Never
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: worker
+spec:
+  template:
+    spec:
+      containers:
+      - name: worker
+        image: worker
+      restartPolicy: Never
+`,
+		"templates/tests/test.yaml": "apiVersion: v1\nkind: Pod\nmetadata:\n  name: test\n  annotations:\n    helm.sh/hook: test\nspec:\n  containers:\n  - name: test\n    image: busybox\n",
+	})
+
+	hookPatch := filepath.Join(t.TempDir(), "hook-patch.yaml")
+	require.NoError(t, os.WriteFile(hookPatch, []byte(`target:
+  kind: Job
+patch: |-
+  - op: add
+    path: /metadata/labels
+    value:
+      patched: "true"
+`), 0644))
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", chart, WithChartifyOpts(&ChartifyOpts{
+		HookPatches:       []string{hookPatch},
+		SeparateHookFiles: true,
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	hooks, err := ReadResourcesFromFile(filepath.Join(tmpDir, "files", "templates", hooksFileName))
+	require.NoError(t, err)
+	require.Len(t, hooks, 1)
+	require.Equal(t, "migrate", hooks[0].Name())
+	require.Contains(t, string(hooks[0].Raw), `patched: "true"`)
+
+	resources, err := ReadResourcesFromFile(filepath.Join(tmpDir, "files", "templates", "patched_resources.yaml"))
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	require.Equal(t, "worker", resources[0].Name())
+	require.NotContains(t, string(resources[0].Raw), "patched")
+
+	tests, err := ReadResourcesFromFile(filepath.Join(tmpDir, "files", "templates", "tests", testHooksFileName))
+	require.NoError(t, err)
+	require.Len(t, tests, 1)
+	require.True(t, tests[0].IsTestHook())
+
+	// Hooks are written along with the other resources by default
+	tmpDir, err = r.Chartify("myapp", chart, WithChartifyOpts(&ChartifyOpts{
+		HookPatches: []string{hookPatch},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	resources, err = ReadResourcesFromFile(filepath.Join(tmpDir, "files", "templates", "patched_resources.yaml"))
+	require.NoError(t, err)
+	require.Len(t, resources, 3)
+	require.NoFileExists(t, filepath.Join(tmpDir, "files", "templates", hooksFileName))
+	require.NoFileExists(t, filepath.Join(tmpDir, "files", "templates", "tests", testHooksFileName))
+}
@@ -0,0 +1,216 @@
+package chartify
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// InputKind is the kind of the input chartify turns into a chart, like a chart or a kustomization.
+type InputKind string
+
+const (
+	// InputKindChart is a Helm chart, either a directory containing Chart.yaml, a .tgz archive, or a remote chart.
+	// Remote inputs that chartify can't fetch by itself are always pulled as charts by helm.
+	InputKindChart InputKind = "chart"
+
+	// InputKindKustomize is a directory containing a kustomization, which is built with kustomize.
+	InputKindKustomize InputKind = "kustomize"
+
+	// InputKindJsonnet is a Jsonnet file, or a directory containing main.jsonnet, which is evaluated with jsonnet.
+	// See Runner.JsonnetBuild for more details.
+	InputKindJsonnet InputKind = "jsonnet"
+
+	// InputKindManifests is a directory containing K8s manifests, along with .gotmpl templates and CUE packages
+	// that are rendered into manifests.
+	// It is the kind of every directory no other detector knows.
+	InputKindManifests InputKind = "manifests"
+)
+
+// kustomizationFileNames are the names of the kustomization file kustomize looks for.
+var kustomizationFileNames = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+
+// InputDetector detects the kind of local inputs.
+//
+// Detectors are consulted in order, and the kind of the first detector that detects the input wins.
+// Custom detectors registered with WithInputDetectors take precedence over the default ones, so that
+// new kinds can be added without changing Chartify.
+type InputDetector interface {
+	// Kind returns the kind of inputs the detector detects.
+	// It is also used to look up the detector for ChartifyOpts.InputKind.
+	Kind() InputKind
+
+	// Detect returns true when the local file or directory at path is of the kind.
+	Detect(r *Runner, path string) (bool, error)
+}
+
+// InputBuilder is implemented by InputDetectors of kinds that are rendered into K8s manifests by a tool,
+// like kustomize and jsonnet.
+// Inputs of the other kinds are copied into the temporary chart, and treated as a chart if the kind is InputKindChart,
+// or as K8s manifests otherwise.
+type InputBuilder interface {
+	// Build renders the input at path into K8s manifests written to a file under the templates/ directory of tempDir,
+	// and returns the path to the file.
+	Build(r *Runner, path, tempDir string, u *ChartifyOpts) (string, error)
+}
+
+// WithInputDetectors registers the additional detectors, which take precedence over the default ones.
+// A detector replaces the default one of the same kind.
+func WithInputDetectors(detectors ...InputDetector) Option {
+	return func(r *Runner) error {
+		r.inputDetectors = append(r.inputDetectors, detectors...)
+		return nil
+	}
+}
+
+// DefaultInputDetectors returns the detectors chartify uses by default, in the order they are consulted.
+func DefaultInputDetectors() []InputDetector {
+	return []InputDetector{
+		kustomizeInput{},
+		chartInput{},
+		jsonnetInput{},
+		manifestsInput{},
+	}
+}
+
+// InputDetectors returns the detectors used by the runner, in the order they are consulted.
+func (r *Runner) InputDetectors() []InputDetector {
+	detectors := append([]InputDetector{}, r.inputDetectors...)
+
+	for _, d := range DefaultInputDetectors() {
+		var overridden bool
+		for _, custom := range r.inputDetectors {
+			if custom.Kind() == d.Kind() {
+				overridden = true
+				break
+			}
+		}
+
+		if !overridden {
+			detectors = append(detectors, d)
+		}
+	}
+
+	return detectors
+}
+
+// inputDetector returns the detector of the kind.
+func (r *Runner) inputDetector(kind InputKind) (InputDetector, error) {
+	var kinds []string
+
+	for _, d := range r.InputDetectors() {
+		if d.Kind() == kind {
+			return d, nil
+		}
+		kinds = append(kinds, string(d.Kind()))
+	}
+
+	return nil, fmt.Errorf("unsupported input kind %q: it must be one of %s", kind, strings.Join(kinds, ", "))
+}
+
+// detectInput returns the detector of the kind of the local input at path.
+func (r *Runner) detectInput(path string) (InputDetector, error) {
+	for _, d := range r.InputDetectors() {
+		ok, err := d.Detect(r, path)
+		if err != nil {
+			return nil, fmt.Errorf("detecting the kind of %s as %s: %w", path, d.Kind(), err)
+		}
+
+		if ok {
+			return d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unable to detect the kind of %s: it must be a chart, a kustomization, or a directory containing K8s manifests", path)
+}
+
+type kustomizeInput struct{}
+
+func (kustomizeInput) Kind() InputKind {
+	return InputKindKustomize
+}
+
+func (kustomizeInput) Detect(r *Runner, path string) (bool, error) {
+	if !r.dirExists(path) {
+		return false, nil
+	}
+
+	for _, f := range kustomizationFileNames {
+		if ok, err := r.Exists(filepath.Join(path, f)); err != nil || ok {
+			return ok, err
+		}
+	}
+
+	return false, nil
+}
+
+func (kustomizeInput) Build(r *Runner, path, tempDir string, u *ChartifyOpts) (string, error) {
+	kustomizeOpts := &KustomizeBuildOpts{
+		ValuesFiles:        u.ValuesFiles,
+		SetValues:          u.SetValues,
+		SetFlags:           u.SetFlags,
+		EnableAlphaPlugins: u.EnableKustomizeAlphaPlugins,
+		Namespace:          u.Namespace,
+		HelmBinary:         r.helmBin(),
+		SortOptions:        u.SortOptions,
+		ExtraArgs:          u.KustomizeBuildArgs,
+	}
+
+	return r.KustomizeBuild(path, tempDir, kustomizeOpts)
+}
+
+type chartInput struct{}
+
+func (chartInput) Kind() InputKind {
+	return InputKindChart
+}
+
+func (chartInput) Detect(r *Runner, path string) (bool, error) {
+	if filepath.Ext(path) == ".tgz" {
+		return r.Exists(path)
+	}
+
+	if !r.dirExists(path) {
+		return false, nil
+	}
+
+	return r.Exists(filepath.Join(path, "Chart.yaml"))
+}
+
+type jsonnetInput struct{}
+
+func (jsonnetInput) Kind() InputKind {
+	return InputKindJsonnet
+}
+
+func (jsonnetInput) Detect(r *Runner, path string) (bool, error) {
+	if filepath.Ext(path) == ".jsonnet" {
+		return r.Exists(path)
+	}
+
+	if !r.dirExists(path) {
+		return false, nil
+	}
+
+	return r.Exists(filepath.Join(path, JsonnetMainFile))
+}
+
+func (jsonnetInput) Build(r *Runner, path, tempDir string, u *ChartifyOpts) (string, error) {
+	jsonnetOpts := &JsonnetBuildOpts{
+		ValuesFiles: u.ValuesFiles,
+		SetValues:   u.SetValues,
+		SetFlags:    u.SetFlags,
+	}
+
+	return r.JsonnetBuild(path, tempDir, jsonnetOpts)
+}
+
+type manifestsInput struct{}
+
+func (manifestsInput) Kind() InputKind {
+	return InputKindManifests
+}
+
+func (manifestsInput) Detect(r *Runner, path string) (bool, error) {
+	return r.dirExists(path), nil
+}
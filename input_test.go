@@ -0,0 +1,142 @@
+package chartify
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner_detectInput(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"kustomization.yaml/kustomization.yaml": "resources: []\n",
+		"kustomization.yml/kustomization.yml":   "resources: []\n",
+		"Kustomization/Kustomization":           "resources: []\n",
+		"chart/Chart.yaml":                      "name: chart\nversion: 0.1.0\n",
+		"archive.tgz":                           "",
+		"jsonnet/main.jsonnet":                  "{}",
+		"app.jsonnet":                           "{}",
+		"manifests/cm.yaml":                     "",
+		"file.txt":                              "",
+	})
+
+	r := New(WithLogf(t.Logf))
+
+	for path, want := range map[string]InputKind{
+		"kustomization.yaml": InputKindKustomize,
+		"kustomization.yml":  InputKindKustomize,
+		"Kustomization":      InputKindKustomize,
+		"chart":              InputKindChart,
+		"archive.tgz":        InputKindChart,
+		"jsonnet":            InputKindJsonnet,
+		"app.jsonnet":        InputKindJsonnet,
+		"manifests":          InputKindManifests,
+	} {
+		d, err := r.detectInput(filepath.Join(dir, path))
+		require.NoError(t, err, path)
+		require.Equal(t, want, d.Kind(), path)
+	}
+
+	_, err := r.detectInput(filepath.Join(dir, "file.txt"))
+	require.ErrorContains(t, err, "unable to detect the kind of")
+}
+
+// helmfileInput is a custom input kind that is detected by the presence of helmfile.yaml,
+// and built into a single ConfigMap.
+type helmfileInput struct{}
+
+func (helmfileInput) Kind() InputKind {
+	return "helmfile"
+}
+
+func (helmfileInput) Detect(r *Runner, path string) (bool, error) {
+	if !r.dirExists(path) {
+		return false, nil
+	}
+
+	return r.Exists(filepath.Join(path, "helmfile.yaml"))
+}
+
+func (helmfileInput) Build(r *Runner, path, tempDir string, u *ChartifyOpts) (string, error) {
+	f := filepath.Join(tempDir, "templates", "helmfile.yaml")
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		return "", err
+	}
+
+	return f, r.WriteFile(f, []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: from-helmfile\n"), 0644)
+}
+
+func TestRunner_InputDetectors(t *testing.T) {
+	r := New(WithInputDetectors(helmfileInput{}, chartInput{}))
+
+	var kinds []InputKind
+	for _, d := range r.InputDetectors() {
+		kinds = append(kinds, d.Kind())
+	}
+	require.Equal(t, []InputKind{"helmfile", InputKindChart, InputKindKustomize, InputKindJsonnet, InputKindManifests}, kinds)
+
+	_, err := r.inputDetector("unknown")
+	require.EqualError(t, err, `unsupported input kind "unknown": it must be one of helmfile, chart, kustomize, jsonnet, manifests`)
+}
+
+func TestChartify_InputKind(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	srcDir := filepath.Join(t.TempDir(), "myapp")
+	writeTestFiles(t, srcDir, map[string]string{
+		"helmfile.yaml": "releases: []\n",
+		"cm.yaml":       "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: plain\n",
+	})
+
+	r := New(HelmBin(helm), WithInputDetectors(helmfileInput{}), WithLogf(t.Logf))
+
+	template := func(t *testing.T, opts *ChartifyOpts) string {
+		t.Helper()
+
+		tmpDir, err := r.Chartify("myapp", srcDir, WithChartifyOpts(opts))
+		t.Cleanup(func() {
+			_ = os.RemoveAll(tmpDir)
+		})
+		require.NoError(t, err)
+
+		out, err := exec.Command(helm, "template", "myapp", tmpDir).CombinedOutput()
+		require.NoError(t, err, string(out))
+
+		return string(out)
+	}
+
+	t.Run("detected", func(t *testing.T) {
+		out := template(t, &ChartifyOpts{Namespace: "ns"})
+		require.Contains(t, out, "name: from-helmfile")
+		require.Contains(t, out, "namespace: ns")
+		require.NotContains(t, out, "name: plain")
+	})
+
+	t.Run("overridden", func(t *testing.T) {
+		out := template(t, &ChartifyOpts{InputKind: InputKindManifests})
+		require.Contains(t, out, "name: plain")
+		require.NotContains(t, out, "name: from-helmfile")
+	})
+
+	t.Run("forced chart", func(t *testing.T) {
+		tmpDir, err := r.Chartify("myapp", "testdata/charts/log", WithChartifyOpts(&ChartifyOpts{
+			InputKind: InputKindChart,
+		}))
+		t.Cleanup(func() {
+			_ = os.RemoveAll(tmpDir)
+		})
+		require.NoError(t, err)
+
+		_, err = r.Chartify("myapp", srcDir, WithChartifyOpts(&ChartifyOpts{
+			InputKind: InputKindChart,
+		}))
+		require.ErrorContains(t, err, "Chart.yaml not found")
+	})
+}
@@ -0,0 +1,217 @@
+package chartify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// JsonnetMainFile is the file chartify evaluates when the input directory is a Jsonnet project.
+const JsonnetMainFile = "main.jsonnet"
+
+type JsonnetBuildOpts struct {
+	ValuesFiles []string
+	SetValues   []string
+	SetFlags    []string
+}
+
+func (o *JsonnetBuildOpts) SetJsonnetBuildOption(opts *JsonnetBuildOpts) error {
+	*opts = *o
+	return nil
+}
+
+type JsonnetBuildOption interface {
+	SetJsonnetBuildOption(opts *JsonnetBuildOpts) error
+}
+
+// JsonnetBuild evaluates the Jsonnet file at src, or main.jsonnet when src is a directory, with the `jsonnet` command,
+// and writes the resulting K8s resources into templates/jsonnet.yaml under tempDir.
+// It returns the path to the written file.
+//
+// The values given via the values files and the set flags are merged like helm does, and passed to Jsonnet as
+// both the external variable `values`, available via `std.extVar('values')`, and the top-level argument `values`,
+// which is used only when the file evaluates to a function like `function(values) ...`.
+//
+// The file may evaluate to a K8s resource, a List of resources, or arrays and objects of them nested at any depth,
+// like the `{ deployment: {...}, service: {...} }` objects commonly used in Jsonnet.
+// Resources in objects are written in the order of their keys.
+func (r *Runner) JsonnetBuild(src, tempDir string, opts ...JsonnetBuildOption) (string, error) {
+	u := &JsonnetBuildOpts{}
+
+	for i := range opts {
+		if err := opts[i].SetJsonnetBuildOption(u); err != nil {
+			return "", err
+		}
+	}
+
+	mainFile, libDir := src, filepath.Dir(src)
+	if filepath.Ext(src) != ".jsonnet" {
+		mainFile, libDir = filepath.Join(src, JsonnetMainFile), src
+	}
+
+	vals, err := mergeGivenValues(u.ValuesFiles, append(setValuesFlags(u.SetValues), parseSetFlags(u.SetFlags)...))
+	if err != nil {
+		return "", fmt.Errorf("merging values for %s: %w", mainFile, err)
+	}
+
+	valuesJSON, err := json.Marshal(vals)
+	if err != nil {
+		return "", fmt.Errorf("marshaling values for %s: %w", mainFile, err)
+	}
+
+	args := []string{"--jpath", libDir}
+
+	// Libraries installed by jsonnet-bundler
+	if vendorDir := filepath.Join(libDir, "vendor"); r.dirExists(vendorDir) {
+		args = append(args, "--jpath", vendorDir)
+	}
+
+	args = append(args,
+		"--ext-code", "values="+string(valuesJSON),
+		"--tla-code", "values="+string(valuesJSON),
+		mainFile,
+	)
+
+	r.Logf("Evaluating %s", mainFile)
+
+	out, err := r.runBytes(nil, "", r.jsonnetBin(), args...)
+	if err != nil {
+		return "", err
+	}
+
+	resources, err := jsonnetResources(out)
+	if err != nil {
+		return "", fmt.Errorf("evaluating %s: %w", mainFile, err)
+	}
+
+	templatesDir := filepath.Join(tempDir, "templates")
+	if err := os.MkdirAll(templatesDir, 0755); err != nil {
+		return "", err
+	}
+
+	outputFile := filepath.Join(templatesDir, "jsonnet.yaml")
+
+	if err := r.writeResources(outputFile, resources); err != nil {
+		return "", err
+	}
+
+	return outputFile, nil
+}
+
+// writeResources writes the K8s resources into the file as a multi-document YAML.
+func (r *Runner) writeResources(path string, resources []map[string]interface{}) error {
+	var buf bytes.Buffer
+	for i, res := range resources {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+
+		var n yaml.Node
+		if err := n.Encode(res); err != nil {
+			return err
+		}
+
+		if err := encodeYAMLDocument(&buf, &n); err != nil {
+			return err
+		}
+	}
+
+	return r.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// jsonnetResources extracts K8s resources out of the JSON output of Jsonnet.
+func jsonnetResources(out []byte) ([]map[string]interface{}, error) {
+	v, err := decodeJSON(out)
+	if err != nil {
+		return nil, fmt.Errorf("parsing the output of jsonnet: %w", err)
+	}
+
+	return flattenJSONResources(v, "$")
+}
+
+// decodeJSON decodes the JSON, keeping integers as int64 instead of float64.
+func decodeJSON(data []byte) (interface{}, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	return normalizeJSONNumbers(v), nil
+}
+
+func flattenJSONResources(v interface{}, path string) ([]map[string]interface{}, error) {
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		var resources []map[string]interface{}
+		for i, item := range v {
+			res, err := flattenJSONResources(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, res...)
+		}
+		return resources, nil
+	case map[string]interface{}:
+		_, hasAPIVersion := v["apiVersion"]
+		kind, hasKind := v["kind"].(string)
+
+		if hasAPIVersion && hasKind {
+			if items, ok := v["items"].([]interface{}); ok && strings.HasSuffix(kind, "List") {
+				return flattenJSONResources(items, path+".items")
+			}
+
+			return []map[string]interface{}{v}, nil
+		}
+
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var resources []map[string]interface{}
+		for _, k := range keys {
+			res, err := flattenJSONResources(v[k], path+"."+k)
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, res...)
+		}
+		return resources, nil
+	}
+
+	return nil, fmt.Errorf("unexpected %T at %s: it must be a K8s resource, or an array or an object of them", v, path)
+}
+
+// normalizeJSONNumbers converts json.Numbers into int64 when possible, or float64 otherwise,
+// so that integers are written as integers without losing precision.
+func normalizeJSONNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeJSONNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = normalizeJSONNumbers(v[k])
+		}
+	}
+	return v
+}
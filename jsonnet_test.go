@@ -0,0 +1,183 @@
+package chartify
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeJsonnet is a jsonnet command that outputs a ConfigMap whose data is the values passed via --ext-code,
+// and records its arguments to args.txt next to itself.
+const fakeJsonnet = `#!/bin/sh
+dir=$(dirname "$0")
+: > "$dir/args.txt"
+values=
+prev=
+for arg in "$@"; do
+  echo "$arg" >> "$dir/args.txt"
+  if [ "$prev" = "--ext-code" ]; then
+    values=${arg#values=}
+  fi
+  prev=$arg
+done
+printf '{"cm": {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "from-jsonnet"}, "data": %s}}' "$values"
+`
+
+func writeFakeJsonnet(t *testing.T) string {
+	t.Helper()
+
+	bin := filepath.Join(t.TempDir(), "jsonnet")
+	require.NoError(t, os.WriteFile(bin, []byte(fakeJsonnet), 0755))
+
+	return bin
+}
+
+func TestJsonnetResources(t *testing.T) {
+	resources, err := jsonnetResources([]byte(`{
+  "service": {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "b"}, "spec": {"ports": [{"port": 8080}]}},
+  "deployment": {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "a"}, "spec": {"replicas": 3}},
+  "extra": [
+    {"apiVersion": "v1", "kind": "ConfigMapList", "items": [{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c"}}]},
+    null
+  ]
+}`))
+	require.NoError(t, err)
+
+	var names []string
+	for _, res := range resources {
+		names = append(names, res["metadata"].(map[string]interface{})["name"].(string))
+	}
+	require.Equal(t, []string{"a", "c", "b"}, names)
+	require.Equal(t, int64(3), resources[0]["spec"].(map[string]interface{})["replicas"])
+
+	_, err = jsonnetResources([]byte(`{"deployment": {"name": "a", "replicas": 3}}`))
+	require.ErrorContains(t, err, "unexpected string at $.deployment.name")
+}
+
+func TestRunner_JsonnetBuild(t *testing.T) {
+	bin := writeFakeJsonnet(t)
+
+	srcDir := t.TempDir()
+	writeTestFiles(t, srcDir, map[string]string{
+		JsonnetMainFile:        "function(values) {}",
+		"vendor/lib.libsonnet": "{}",
+		"values/prod.yaml":     "foo: prod\nbar: prod\n",
+	})
+
+	tempDir := t.TempDir()
+
+	r := New(JsonnetBin(bin), WithLogf(t.Logf))
+
+	out, err := r.JsonnetBuild(srcDir, tempDir, &JsonnetBuildOpts{
+		ValuesFiles: []string{filepath.Join(srcDir, "values", "prod.yaml")},
+		SetFlags:    []string{"--set", "bar=override"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(tempDir, "templates", "jsonnet.yaml"), out)
+
+	content, err := os.ReadFile(out)
+	require.NoError(t, err)
+	require.Equal(t, `apiVersion: v1
+data:
+  bar: override
+  foo: prod
+kind: ConfigMap
+metadata:
+  name: from-jsonnet
+`, string(content))
+
+	args, err := os.ReadFile(filepath.Join(filepath.Dir(bin), "args.txt"))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"--jpath", srcDir,
+		"--jpath", filepath.Join(srcDir, "vendor"),
+		"--ext-code", `values={"bar":"override","foo":"prod"}`,
+		"--tla-code", `values={"bar":"override","foo":"prod"}`,
+		filepath.Join(srcDir, JsonnetMainFile),
+	}, strings.Split(strings.TrimSpace(string(args)), "\n"))
+}
+
+func TestChartify_Jsonnet(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	srcDir := filepath.Join(t.TempDir(), "myapp")
+	writeTestFiles(t, srcDir, map[string]string{
+		JsonnetMainFile: "function(values) {}",
+		// Not a manifest, and must not end up in the chart
+		"config.yaml": "foo: bar\n",
+	})
+
+	r := New(HelmBin(helm), JsonnetBin(writeFakeJsonnet(t)), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", srcDir, WithChartifyOpts(&ChartifyOpts{
+		Namespace: "ns",
+		SetFlags:  []string{"--set", "foo=bar"},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	require.NoFileExists(t, filepath.Join(tmpDir, "config.yaml"))
+	require.NoFileExists(t, filepath.Join(tmpDir, "templates", "config.yaml"))
+
+	out, err := exec.Command(helm, "template", "myapp", tmpDir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), "name: from-jsonnet")
+	require.Contains(t, string(out), "namespace: ns")
+	require.Contains(t, string(out), "foo: bar")
+
+	_, err = r.Chartify("myapp", "testdata/kube_manifest", WithChartifyOpts(&ChartifyOpts{
+		InputKind: "unknown",
+	}))
+	require.ErrorContains(t, err, `unsupported input kind "unknown"`)
+}
+
+func TestChartify_JsonnetBinary(t *testing.T) {
+	jsonnet, err := exec.LookPath("jsonnet")
+	if err != nil {
+		t.Skip("jsonnet is not installed")
+	}
+
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	srcDir := filepath.Join(t.TempDir(), "myapp")
+	writeTestFiles(t, srcDir, map[string]string{
+		"app.jsonnet": `function(values) {
+  configMap: {
+    apiVersion: 'v1',
+    kind: 'ConfigMap',
+    metadata: { name: 'myapp' },
+    data: { replicas: std.toString(values.replicas), ext: std.extVar('values').name },
+  },
+}
+`,
+	})
+
+	r := New(HelmBin(helm), JsonnetBin(jsonnet), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", filepath.Join(srcDir, "app.jsonnet"), WithChartifyOpts(&ChartifyOpts{
+		SetFlags: []string{"--set", "replicas=3,name=foo"},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	out, err := exec.Command(helm, "template", "myapp", tmpDir).CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.Contains(t, string(out), `replicas: "3"`)
+	require.Contains(t, string(out), "ext: foo")
+}
@@ -0,0 +1,77 @@
+package chartify
+
+import (
+	"fmt"
+	"path/filepath"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"gopkg.in/yaml.v3"
+)
+
+// applyMergePatches applies the JSON merge patches (RFC 7386) in u.MergePatches to the resources matching their targets.
+func (r *Runner) applyMergePatches(resources []*Resource, u *PatchOpts) ([]*Resource, error) {
+	for _, f := range u.MergePatches {
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var patch struct {
+			Target PatchTarget `yaml:"target"`
+			Patch  interface{} `yaml:"patch"`
+			Path   string      `yaml:"path"`
+		}
+		if err := yaml.Unmarshal(content, &patch); err != nil {
+			return nil, fmt.Errorf("parsing merge patch %s: %w", f, err)
+		}
+
+		report := PatchReport{Type: PatchTypeMergePatch, Source: f}
+
+		content, err = r.nativePatchContent(patch.Patch, patch.Path, filepath.Dir(f))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", report, err)
+		}
+
+		// A merge patch that isn't a mapping would replace the whole resource
+		var doc interface{}
+		if err := yaml.Unmarshal(content, &doc); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", report, err)
+		}
+
+		if _, ok := doc.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("%s: the patch must be a YAML mapping", report)
+		}
+
+		patchJSON, err := yamlToJSON(content)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", report, err)
+		}
+
+		for i, res := range resources {
+			ok, err := patch.Target.Matches(res)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", report, err)
+			}
+
+			if !ok {
+				continue
+			}
+
+			original, err := resourceJSON(res)
+			if err != nil {
+				return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+			}
+
+			merged, err := jsonpatch.MergePatch(original, patchJSON)
+			if err != nil {
+				return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+			}
+
+			if resources[i], err = updateResource(res, merged); err != nil {
+				return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+			}
+		}
+	}
+
+	return resources, nil
+}
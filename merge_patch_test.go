@@ -0,0 +1,94 @@
+package chartify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner_applyMergePatches(t *testing.T) {
+	resources, err := ReadResources(strings.NewReader(nativePatchTestResources))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"widget.yaml":  "target:\n  group: example.com\n  kind: Widget\npatch:\n  spec:\n    sizes: [3]\n    color: null\n",
+		"inline.yaml":  "target:\n  kind: Job\npatch: |\n  spec:\n    backoffLimit: 2\n",
+		"list.yaml":    "target:\n  kind: Job\npatch:\n- spec: {}\n",
+		"missing.yaml": "target:\n  kind: Job\n",
+	})
+
+	r := New(WithLogf(t.Logf))
+
+	patched, err := r.applyMergePatches(append([]*Resource{}, resources...), &PatchOpts{
+		MergePatches: []string{filepath.Join(dir, "widget.yaml"), filepath.Join(dir, "inline.yaml")},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, resources[0], patched[0])
+	require.Equal(t, `apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  sizes: [3]
+`, string(patched[1].Raw))
+	require.Contains(t, string(patched[2].Raw), "backoffLimit: 2")
+
+	_, err = r.applyMergePatches(resources, &PatchOpts{MergePatches: []string{filepath.Join(dir, "list.yaml")}})
+	require.EqualError(t, err, "merge patch "+filepath.Join(dir, "list.yaml")+": the patch must be a YAML mapping")
+
+	_, err = r.applyMergePatches(resources, &PatchOpts{MergePatches: []string{filepath.Join(dir, "missing.yaml")}})
+	require.EqualError(t, err, "merge patch "+filepath.Join(dir, "missing.yaml")+`: either "path" or "patch" must be set`)
+}
+
+func TestChartify_MergePatchesAndFieldSetters(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	patch := filepath.Join(t.TempDir(), "patch.yaml")
+	require.NoError(t, os.WriteFile(patch, []byte("target:\n  kind: Deployment\npatch:\n  metadata:\n    labels:\n      merged: \"true\"\n"), 0644))
+
+	opts := &ChartifyOpts{
+		MergePatches: []string{patch},
+		FieldSetters: []FieldSetter{
+			{Target: PatchTarget{Kind: "Deployment"}, Path: "spec.replicas", Value: 3},
+			{Target: PatchTarget{Kind: "StatefulSet"}, Path: "spec.replicas", Value: 3},
+		},
+	}
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	diff, err := r.Diff("myapp", "testdata/charts/log", WithChartifyOpts(opts))
+	require.NoError(t, err)
+
+	require.Equal(t, []ResourceDiff{
+		{
+			ID: ResourceID{APIVersion: "apps/v1", Kind: "Deployment", Name: "myapp-log"},
+			Stages: []StageDiff{
+				{Stage: "merge patch " + patch, Changes: []FieldChange{{Type: ChangeAdded, Path: "metadata.labels.merged", New: "true"}}},
+				{Stage: "field setter #0 at spec.replicas", Changes: []FieldChange{{Type: ChangeModified, Path: "spec.replicas", Old: 1, New: 3}}},
+			},
+		},
+	}, diff.Resources)
+
+	opts.FailOnUnmatchedPatch = true
+
+	_, err = r.Chartify("myapp", "testdata/charts/log", WithChartifyOpts(opts))
+	require.EqualError(t, err, "patches matched no resources:\n- field setter #1 at spec.replicas")
+
+	// The field setter matches the label added by the merge patch applied before it
+	opts.FieldSetters = []FieldSetter{{Target: PatchTarget{LabelSelector: "merged=true"}, Path: "spec.replicas", Value: 3}}
+
+	tmpDir, err := r.Chartify("myapp", "testdata/charts/log", WithChartifyOpts(opts))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+}
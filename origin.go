@@ -0,0 +1,249 @@
+package chartify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OriginAnnotation is the annotation chartify temporarily adds to rendered resources to remember the chart file
+// each of them was rendered from.
+// It is carried through the patches and transformers, so that the origin survives them changing the name or namespace
+// of the resource, and is always stripped before the patched resources are written.
+//
+// Only the resources whose origin matters have the annotation while they are patched:
+// every resource when PreserveFileLayout is set, and CRDs rendered from templates/ otherwise.
+const OriginAnnotation = "chartify.helmfile.io/origin"
+
+// annotateOrigins reads the resources contained in files, and adds the OriginAnnotation to every resource
+// for which needsOrigin returns true.
+// The origin is the path to the file the resource was read from relative to chartDir, using forward slashes.
+// The files containing such resources are rewritten with the annotation, so that kustomize sees it too,
+// whereas the other files are left untouched.
+func (r *Runner) annotateOrigins(chartDir string, files []string, needsOrigin func(res *Resource, origin string) bool) ([]*Resource, error) {
+	var resources []*Resource
+
+	for _, f := range files {
+		// Like kustomization.yaml's resources, files can also be relative to chartDir
+		rel := f
+		if filepath.IsAbs(f) {
+			var err error
+			rel, err = filepath.Rel(chartDir, f)
+			if err != nil {
+				return nil, fmt.Errorf("calculating relative path to %s from %s: %w", f, chartDir, err)
+			}
+		} else {
+			f = filepath.Join(chartDir, f)
+		}
+
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		rs, err := ReadResources(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", f, err)
+		}
+
+		origin := filepath.ToSlash(rel)
+
+		var (
+			annotated bool
+			buf       bytes.Buffer
+		)
+
+		for i, res := range rs {
+			if needsOrigin(res, origin) {
+				if err := setAnnotation(res.Node, OriginAnnotation, origin); err != nil {
+					return nil, fmt.Errorf("annotating %s in %s: %w", res.ID(), f, err)
+				}
+
+				if rs[i], err = resourceFromNode(res.Node); err != nil {
+					return nil, fmt.Errorf("annotating %s in %s: %w", res.ID(), f, err)
+				}
+
+				annotated = true
+			}
+
+			if buf.Len() > 0 {
+				buf.WriteString("---\n")
+			}
+			buf.Write(rs[i].Raw)
+		}
+
+		if annotated {
+			if err := r.WriteFile(f, buf.Bytes(), 0644); err != nil {
+				return nil, err
+			}
+		}
+
+		resources = append(resources, rs...)
+	}
+
+	return resources, nil
+}
+
+// extractOrigin removes the OriginAnnotation from the resource and returns the resource without it,
+// along with the value of the annotation.
+// The returned origin is empty when the resource had no OriginAnnotation, which is the case for
+// resources whose origin doesn't matter, and resources generated by kustomize transformers rather than rendered from the chart.
+func extractOrigin(res *Resource) (string, string, error) {
+	if !bytes.Contains(res.Raw, []byte(OriginAnnotation)) {
+		return string(res.Raw), "", nil
+	}
+
+	origin, ok := removeAnnotation(res.Node, OriginAnnotation)
+	if !ok {
+		return string(res.Raw), "", nil
+	}
+
+	var buf bytes.Buffer
+	if err := encodeYAMLDocument(&buf, res.Node); err != nil {
+		return "", "", err
+	}
+
+	return buf.String(), origin, nil
+}
+
+func encodeYAMLDocument(w io.Writer, doc *yaml.Node) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// setAnnotation sets metadata.annotations[key] of the resource to value,
+// creating metadata and annotations when missing.
+func setAnnotation(doc *yaml.Node, key, value string) error {
+	root := documentRoot(doc)
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("expected a YAML mapping but got %s", root.Tag)
+	}
+
+	metadata, err := ensureMapping(root, "metadata")
+	if err != nil {
+		return fmt.Errorf("metadata: %w", err)
+	}
+
+	annotations, err := ensureMapping(metadata, "annotations")
+	if err != nil {
+		return fmt.Errorf("metadata.annotations: %w", err)
+	}
+
+	if v := mappingValue(annotations, key); v != nil {
+		*v = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+		return nil
+	}
+
+	annotations.Content = append(annotations.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value},
+	)
+
+	return nil
+}
+
+// removeAnnotation removes metadata.annotations[key] from the resource and returns its value.
+// The annotations field itself is removed too when it becomes empty.
+func removeAnnotation(doc *yaml.Node, key string) (string, bool) {
+	metadata := mappingValue(documentRoot(doc), "metadata")
+
+	annotations := mappingValue(metadata, "annotations")
+	if annotations == nil || annotations.Kind != yaml.MappingNode {
+		return "", false
+	}
+
+	for i := 0; i+1 < len(annotations.Content); i += 2 {
+		if annotations.Content[i].Value != key {
+			continue
+		}
+
+		value := annotations.Content[i+1].Value
+		annotations.Content = append(annotations.Content[:i], annotations.Content[i+2:]...)
+
+		if len(annotations.Content) == 0 {
+			removeMappingKey(metadata, "annotations")
+		}
+
+		return value, true
+	}
+
+	return "", false
+}
+
+func removeMappingKey(m *yaml.Node, key string) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		return doc.Content[0]
+	}
+	return doc
+}
+
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// ensureMapping returns the mapping at m[key], adding it when missing.
+// An empty value like `key:` or `key: null` is turned into a mapping, whereas any other value is an error.
+func ensureMapping(m *yaml.Node, key string) (*yaml.Node, error) {
+	if v := mappingValue(m, key); v != nil {
+		switch {
+		case v.Kind == yaml.MappingNode:
+		case v.Kind == yaml.ScalarNode && v.Tag == "!!null":
+			*v = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		default:
+			return nil, fmt.Errorf("expected a YAML mapping but got %s", v.Tag)
+		}
+		return v, nil
+	}
+
+	v := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, v)
+	return v, nil
+}
+
+// writeResourcesByOrigin writes every group of resources to the file under chartDir named after its origin.
+// origins lists the keys of resourcesByOrigin in the order the files should be written.
+func (r *Runner) writeResourcesByOrigin(chartDir string, origins []string, resourcesByOrigin map[string][]string) error {
+	for _, origin := range origins {
+		rel := filepath.FromSlash(origin)
+		if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("invalid %s annotation %q: it must be a path relative to the chart", OriginAnnotation, origin)
+		}
+
+		path := filepath.Join(chartDir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		if err := r.WriteFile(path, []byte(strings.Join(resourcesByOrigin[origin], "---\n")), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package chartify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnotateOrigins(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "templates", "all.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(f), 0755))
+
+	require.NoError(t, os.WriteFile(f, []byte(`---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+---
+`), 0644))
+
+	other := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm1\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.yaml"), []byte(other), 0644))
+
+	r := New(WithLogf(t.Logf))
+
+	resources, err := r.annotateOrigins(dir, []string{f, "other.yaml"}, func(res *Resource, origin string) bool {
+		return res.Kind() == "CustomResourceDefinition"
+	})
+	require.NoError(t, err)
+	require.Len(t, resources, 3)
+
+	got, err := os.ReadFile(f)
+	require.NoError(t, err)
+	require.Equal(t, `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm1
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+  annotations:
+    chartify.helmfile.io/origin: templates/all.yaml
+`, string(got))
+	require.Equal(t, string(got), string(resources[0].Raw)+"---\n"+string(resources[1].Raw))
+
+	got, err = os.ReadFile(filepath.Join(dir, "other.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, other, string(got), "files without annotated resources must be left untouched")
+
+	raw, origin, err := extractOrigin(resources[1])
+	require.NoError(t, err)
+	require.Equal(t, "templates/all.yaml", origin)
+	require.Equal(t, "apiVersion: apiextensions.k8s.io/v1\nkind: CustomResourceDefinition\nmetadata:\n  name: widgets.example.com\n", raw)
+
+	raw, origin, err = extractOrigin(resources[0])
+	require.NoError(t, err)
+	require.Empty(t, origin)
+	require.Equal(t, string(resources[0].Raw), raw)
+}
+
+func TestWriteResourcesByOrigin_RejectsPathsOutsideChart(t *testing.T) {
+	r := New(WithLogf(t.Logf))
+
+	err := r.writeResourcesByOrigin(t.TempDir(), []string{"../evil.yaml"}, map[string][]string{"../evil.yaml": {"kind: ConfigMap\n"}})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "it must be a path relative to the chart")
+}
+
+func TestChartify_PreserveFileLayout(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	logChart, err := filepath.Abs("testdata/charts/log")
+	require.NoError(t, err)
+
+	tmpDir, err := r.Chartify("myapp", "testdata/charts/db", WithChartifyOpts(&ChartifyOpts{
+		AdhocChartDependencies: []ChartDependency{
+			{Alias: "log", Chart: logChart, Version: "0.1.0"},
+		},
+		StrategicMergePatches: []string{"testdata/chart_patch/deploy.db.strategic.yaml"},
+		// The origin must survive the patches changing the ID of the resource
+		FieldSetters:       []FieldSetter{{Target: PatchTarget{Kind: "Deployment", Name: "myapp-db"}, Path: "metadata.name", Value: "renamed-db"}},
+		PreserveFileLayout: true,
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	deployment, err := os.ReadFile(filepath.Join(tmpDir, "files", "templates", "deployment.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(deployment), "name: renamed-db")
+	require.Contains(t, string(deployment), "replicas: 2")
+	require.NotContains(t, string(deployment), OriginAnnotation)
+
+	require.FileExists(t, filepath.Join(tmpDir, "files", "templates", "tests", "test-connection.yaml"))
+	require.FileExists(t, filepath.Join(tmpDir, "files", "charts", "log", "templates", "deployment.yaml"))
+	require.NoFileExists(t, filepath.Join(tmpDir, "files", "templates", "patched_resources.yaml"))
+
+	template, err := os.ReadFile(filepath.Join(tmpDir, "templates", "deployment.yaml"))
+	require.NoError(t, err)
+	require.Equal(t, `{{ .Files.Get "files/templates/deployment.yaml" }}`, string(template))
+}
@@ -25,8 +25,32 @@ type PatchOpts struct {
 	// See https://github.com/kubernetes-sigs/kustomize/blob/master/examples/inlinePatch.md
 	Patches []string
 
+	// HookPatches is like Patches, but every patch in it is applied only to Helm hooks, that is,
+	// resources annotated with `helm.sh/hook`.
+	// Every patch must have a target, which is restricted to hooks by adding `helm.sh/hook` to its annotationSelector.
+	HookPatches []string
+
 	Transformers []string
 
+	// MergePatches is the list of YAML files each defining a JSON merge patch (RFC 7386) and its target,
+	// in the same format as JsonPatches except that the patch is a YAML mapping:
+	//
+	//	target:
+	//	  kind: Certificate
+	//	patch:
+	//	  spec:
+	//	    dnsNames: [example.com]
+	//
+	// Unlike strategic merge patches, lists are always replaced as a whole, which is what custom resources
+	// without known patch strategies need.
+	// MergePatches and FieldSetters are applied in-process after all the other patches and transformers,
+	// regardless of the PatchEngine.
+	MergePatches []string
+
+	// FieldSetters set a field of every resource their targets match to a value. They are applied after MergePatches.
+	// See FieldSetter for more details.
+	FieldSetters []FieldSetter
+
 	// Kustomize alpha plugin enable flag.
 	// Above Kustomize v3, it is `--enable-alpha-plugins`.
 	// Below Kustomize v3 (including v3), it is `--enable_alpha_plugins`.
@@ -38,6 +62,31 @@ type PatchOpts struct {
 	// ExtraArgs are extra arguments to pass to `kustomize build` command
 	// For example, ["--enable-exec"] for plugins like ksops
 	ExtraArgs []string
+
+	// CRDPlacement determines where the patched CRDs are written. Defaults to CRDPlacementPreserve.
+	CRDPlacement CRDPlacement
+
+	// PreserveFileLayout writes every patched resource back to the file it was rendered from,
+	// like templates/deployment.yaml or charts/SUBCHART/templates/service.yaml,
+	// instead of collapsing all of them into templates/patched_resources.yaml and crds/patched_crds.yaml.
+	// Resources that didn't exist before patching, like ones generated by transformers, still go to the latter files.
+	// The file is remembered in the OriginAnnotation of every resource while the patches are applied.
+	PreserveFileLayout bool
+
+	// SeparateHookFiles writes the patched Helm hooks to templates/patched_hooks.yaml and the test hooks among them
+	// to templates/tests/patched_tests.yaml, so that they are easy to tell apart from the other resources
+	// in the generated chart and `helm diff` output.
+	// Otherwise, they are written to templates/patched_resources.yaml along with the other resources.
+	SeparateHookFiles bool
+
+	// FailOnUnmatchedPatch makes Patch fail when any patch, or transformer with a target, matches no resources.
+	// The resources each patch matches are logged regardless of this option. See Runner.MatchPatches for more details.
+	// MergePatches and FieldSetters are checked against the resources they are applied to, after the other patches and transformers.
+	FailOnUnmatchedPatch bool
+
+	// PatchEngine determines what applies JsonPatches, StrategicMergePatches, Patches and HookPatches.
+	// Defaults to PatchEngineKustomize. See PatchEngine for the available options.
+	PatchEngine PatchEngine
 }
 
 func (o *PatchOpts) SetPatchOption(opts *PatchOpts) error {
@@ -59,28 +108,227 @@ func (r *Runner) Patch(tempDir string, generatedManifestFiles []string, opts ...
 		}
 	}
 
-	// Resolve the kustomize binary once so PATH lookups are not repeated for every check.
-	bin := r.kustomizeBin()
-	usingKubectl := bin == "kubectl kustomize"
-
 	r.Logf("patching files: %v", generatedManifestFiles)
 
-	// Detect if CRDs originally came from templates/ directory
-	// This is important for preserving the chart author's intent
-	// Issue: https://github.com/helmfile/helmfile/issues/2291
-	crdsFromTemplates := false
-	for _, f := range generatedManifestFiles {
-		relPath := strings.Replace(f, tempDir+string(filepath.Separator), "", 1)
-		// Normalize path separators for cross-platform compatibility
-		relPath = filepath.ToSlash(relPath)
-		// Check if any file is in templates/crds/ subdirectory
-		if strings.HasPrefix(relPath, "templates/crds/") {
-			crdsFromTemplates = true
-			r.Logf("Detected CRDs in templates/ directory - will preserve location")
-			break
+	if err := u.CRDPlacement.Validate(); err != nil {
+		return err
+	}
+
+	if err := u.PatchEngine.Validate(); err != nil {
+		return err
+	}
+
+	// Track the file each resource came from across kustomize build,
+	// so that we can place CRDs according to the CRDPlacement and
+	// write resources back to the same file afterwards when PreserveFileLayout is enabled.
+	// Only CRDs rendered from templates/ need it otherwise, as the others go to crds/ regardless of their origin.
+	needsOrigin := func(res *Resource, origin string) bool {
+		if u.PreserveFileLayout {
+			return true
+		}
+
+		return res.Kind() == "CustomResourceDefinition" && (u.CRDPlacement == "" || u.CRDPlacement == CRDPlacementPreserve) && isTemplateOrigin(origin)
+	}
+
+	inputResources, err := r.annotateOrigins(tempDir, generatedManifestFiles, needsOrigin)
+	if err != nil {
+		return err
+	}
+
+	// Merge patches and field setters are checked below against the resources they are applied to,
+	// as they are applied after the other patches and transformers
+	kustomizeOpts := *u
+	kustomizeOpts.MergePatches, kustomizeOpts.FieldSetters = nil, nil
+
+	if err := r.checkPatchMatches(inputResources, &kustomizeOpts); err != nil {
+		return err
+	}
+
+	renderedFileName := "all.patched.yaml"
+	renderedFile := filepath.Join(tempDir, renderedFileName)
+	r.Logf("Generating %s", renderedFileName)
+
+	switch u.PatchEngine {
+	case PatchEngineNative:
+		if err := r.nativePatch(tempDir, inputResources, renderedFile, u); err != nil {
+			return err
+		}
+	default:
+		if err := r.kustomizePatch(tempDir, generatedManifestFiles, renderedFile, u); err != nil {
+			return err
 		}
 	}
 
+	var resources, hooks, testHooks []string
+
+	var origins []string
+	resourcesByOrigin := map[string][]string{}
+
+	var crdDirs []string
+	crdsByDir := map[string][]string{}
+
+	renderedResources, err := ReadResourcesFromFile(renderedFile)
+	if err != nil {
+		return fmt.Errorf("processing %s: %w", renderedFileName, err)
+	}
+
+	// kustomize supports neither of them, so they are always applied in-process
+	if err := r.checkPatchMatches(renderedResources, &PatchOpts{MergePatches: u.MergePatches, FailOnUnmatchedPatch: u.FailOnUnmatchedPatch}); err != nil {
+		return err
+	}
+
+	renderedResources, err = r.applyMergePatches(renderedResources, u)
+	if err != nil {
+		return err
+	}
+
+	if err := r.checkPatchMatches(renderedResources, &PatchOpts{FieldSetters: u.FieldSetters, FailOnUnmatchedPatch: u.FailOnUnmatchedPatch}); err != nil {
+		return err
+	}
+
+	renderedResources, err = applyFieldSetters(renderedResources, u.FieldSetters)
+	if err != nil {
+		return err
+	}
+
+	for _, res := range renderedResources {
+		// Resources generated by kustomize transformers have no origin
+		t, origin, err := extractOrigin(res)
+		if err != nil {
+			return fmt.Errorf("removing the %s annotation from %s: %w", OriginAnnotation, res.ID(), err)
+		}
+
+		isCRD := res.Kind() == "CustomResourceDefinition"
+
+		if u.PreserveFileLayout && origin != "" && (!isCRD || u.CRDPlacement == "" || u.CRDPlacement == CRDPlacementPreserve) {
+			if _, ok := resourcesByOrigin[origin]; !ok {
+				origins = append(origins, origin)
+			}
+			resourcesByOrigin[origin] = append(resourcesByOrigin[origin], t)
+			continue
+		}
+
+		if isCRD {
+			dir := r.crdDir(tempDir, origin, u.CRDPlacement)
+			if _, ok := crdsByDir[dir]; !ok {
+				crdDirs = append(crdDirs, dir)
+			}
+			crdsByDir[dir] = append(crdsByDir[dir], t)
+			continue
+		}
+
+		switch {
+		case u.SeparateHookFiles && res.IsTestHook():
+			testHooks = append(testHooks, t)
+		case u.SeparateHookFiles && len(res.Hooks()) > 0:
+			hooks = append(hooks, t)
+		default:
+			resources = append(resources, t)
+		}
+	}
+
+	if u.PreserveFileLayout {
+		r.Logf("Detected resources from %d files, %d new resources, %d new hooks, %d new test hooks and CRDs for %d directories", len(origins), len(resources), len(hooks), len(testHooks), len(crdDirs))
+	} else {
+		r.Logf("Detected %d resources, %d hooks, %d test hooks and CRDs for %d directories", len(resources), len(hooks), len(testHooks), len(crdDirs))
+	}
+
+	resourcesFile := filepath.Join(tempDir, "all.patched.resources.yaml")
+
+	err = func() error {
+		f, err := os.Create(resourcesFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+
+		w := bufio.NewWriter(f)
+
+		for _, resource := range resources {
+			_, _ = w.WriteString(resource)
+			_, _ = w.WriteString("---\n")
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		return f.Sync()
+	}()
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", resourcesFile, err)
+	}
+
+	removedPathList := append(append([]string{}, ContentDirs...), "strategicmergepatches", "jsonpatches", "patch-files", "transformer-patch-files", "kustomization.yaml", nativePatchedFileName, renderedFileName)
+
+	for _, f := range removedPathList {
+		d := filepath.Join(tempDir, f)
+		r.Logf("Removing %s", d)
+		if err := os.RemoveAll(d); err != nil {
+			return err
+		}
+	}
+
+	if err := r.writeResourcesByOrigin(tempDir, origins, resourcesByOrigin); err != nil {
+		return err
+	}
+
+	for _, dir := range crdDirs {
+		r.Logf("Placing CRDs in %s", dir)
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+
+		crdsFile := filepath.Join(dir, "patched_crds.yaml")
+		if err := r.WriteFile(crdsFile, []byte(strings.Join(crdsByDir[dir], "---\n")), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", crdsFile, err)
+		}
+	}
+
+	if len(resources) > 0 {
+		templatesDir := filepath.Join(tempDir, "templates")
+		if err := os.MkdirAll(templatesDir, 0755); err != nil {
+			return err
+		}
+
+		if err := os.Rename(resourcesFile, filepath.Join(templatesDir, "patched_resources.yaml")); err != nil {
+			return err
+		}
+	}
+
+	for _, h := range []struct {
+		file      string
+		resources []string
+	}{
+		{filepath.Join(tempDir, "templates", hooksFileName), hooks},
+		{filepath.Join(tempDir, "templates", "tests", testHooksFileName), testHooks},
+	} {
+		if len(h.resources) == 0 {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(h.file), 0755); err != nil {
+			return err
+		}
+
+		if err := r.WriteFile(h.file, []byte(strings.Join(h.resources, "---\n")), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", h.file, err)
+		}
+	}
+
+	return nil
+}
+
+// kustomizePatch generates kustomization.yaml that applies the patches and transformers in u to the generated manifests,
+// and runs `kustomize build` to write the result to renderedFile.
+func (r *Runner) kustomizePatch(tempDir string, generatedManifestFiles []string, renderedFile string, u *PatchOpts) error {
+	// Resolve the kustomize binary once so PATH lookups are not repeated for every check.
+	bin := r.kustomizeBin()
+	usingKubectl := bin == "kubectl kustomize"
+
 	kustomizationYamlContent := `kind: ""
 apiversion: ""
 resources:
@@ -90,7 +338,7 @@ resources:
 		kustomizationYamlContent += `- ` + f + "\n"
 	}
 
-	if len(u.StrategicMergePatches) > 0 || len(u.JsonPatches) > 0 || len(u.Patches) > 0 {
+	if len(u.StrategicMergePatches) > 0 || len(u.JsonPatches) > 0 || len(u.Patches) > 0 || len(u.HookPatches) > 0 {
 		kustomizationYamlContent += `patches:
 `
 	}
@@ -178,7 +426,10 @@ resources:
 	// External file references via "path:" are copied into tempDir so kustomize can
 	// access them within its restricted root.
 	// See https://github.com/kubernetes-sigs/kustomize/blob/master/examples/inlinePatch.md
-	for i, f := range u.Patches {
+	patchFiles := append(append([]string{}, u.Patches...), u.HookPatches...)
+	for i, f := range patchFiles {
+		isHookPatch := i >= len(u.Patches)
+
 		fileBytes, err := r.ReadFile(f)
 		if err != nil {
 			return err
@@ -191,6 +442,12 @@ resources:
 		}
 
 		for j, entry := range entries {
+			if isHookPatch {
+				if err := restrictTargetToHooks(entry); err != nil {
+					return fmt.Errorf("processing hook patches file %s: %w", f, err)
+				}
+			}
+
 			// If the entry references an external file via "path:", copy that file
 			// into tempDir and rewrite the path to be relative to the kustomization root.
 			if pathStr, ok := entry["path"].(string); ok && pathStr != "" {
@@ -281,10 +538,6 @@ resources:
 
 	r.Logf("generated and using kustomization.yaml:\n%s", kustomizationYamlContent)
 
-	renderedFileName := "all.patched.yaml"
-	renderedFile := filepath.Join(tempDir, renderedFileName)
-	r.Logf("Generating %s", renderedFileName)
-
 	kustomizeArgs := []string{"--output", renderedFile}
 
 	if !usingKubectl {
@@ -310,195 +563,6 @@ resources:
 		return err
 	}
 
-	var resources, crds []string
-
-	bs, err := os.ReadFile(renderedFile)
-	if err != nil {
-		return fmt.Errorf("reading %s: %w", renderedFileName, err)
-	}
-
-	scanner := bufio.NewScanner(bytes.NewReader(bs))
-
-	// Forget about resource consumption and
-	// use the file size as the buffer size, so that we will never
-	// mis-parse looong YAML due to buffer isn't large enough to
-	// contain the YAML document separator...
-	buffer := make([]byte, 0, len(bs))
-	scanner.Buffer(buffer, len(bs))
-
-	split := func(d []byte, atEOF bool) (int, []byte, error) {
-		if atEOF {
-			if len(d) == 0 {
-				return 0, nil, nil
-			}
-
-			return len(d), d, nil
-		}
-
-		if i := bytes.Index(d, []byte("\n---\n")); i >= 0 {
-			return i + 5, d[0 : i+1], nil
-		}
-
-		// "SplitFunc can return (0, nil, nil) to signal the Scanner to read more data into the slice and try again with a longer slice starting at the same point in the input."
-		//https://golang.org/pkg/bufio/#SplitFunc
-		return 0, nil, nil
-	}
-
-	consume := func(t string) error {
-		type res struct {
-			Kind string `yaml:"kind"`
-		}
-		var r res
-
-		if err := yaml.Unmarshal([]byte(t), &r); err != nil {
-			return fmt.Errorf("processing %s: parsing yaml doc from %q: %w", renderedFileName, t, err)
-		}
-
-		if r.Kind == "CustomResourceDefinition" {
-			crds = append(crds, t)
-		} else {
-			resources = append(resources, t)
-		}
-
-		return nil
-	}
-
-	var scanned bool
-
-	scanner.Split(split)
-	for scanner.Scan() {
-		scanned = true
-
-		t := scanner.Text()
-
-		if err := consume(t); err != nil {
-			return err
-		}
-	}
-
-	// When the scanner managed to provide all the buffer on first scan, `split` func ends up
-	// returning `0, nil, nil` and stops the scanner.
-	// In other words, a single resourced chart can never be patched if we didn't handle that case.
-	if !scanned {
-		if err := consume(string(bs)); err != nil {
-			return err
-		}
-	}
-
-	r.Logf("Detected %d resources and %d CRDs", len(resources), len(crds))
-
-	resourcesFile := filepath.Join(tempDir, "all.patched.resources.yaml")
-	crdsFile := filepath.Join(tempDir, "all.patched.crds.yaml")
-
-	err = func() error {
-		f, err := os.Create(resourcesFile)
-		if err != nil {
-			return err
-		}
-		defer func() {
-			_ = f.Close()
-		}()
-
-		w := bufio.NewWriter(f)
-
-		for _, resource := range resources {
-			_, _ = w.WriteString(resource)
-			_, _ = w.WriteString("---\n")
-		}
-
-		if err := w.Flush(); err != nil {
-			return err
-		}
-
-		return f.Sync()
-	}()
-	if err != nil {
-		return fmt.Errorf("writing %s: %w", resourcesFile, err)
-	}
-
-	err = func() error {
-		f, err := os.Create(crdsFile)
-		if err != nil {
-			return err
-		}
-		defer func() {
-			_ = f.Close()
-		}()
-
-		w := bufio.NewWriter(f)
-		for _, crd := range crds {
-			_, _ = w.WriteString(crd)
-			_, _ = w.WriteString("---\n")
-		}
-
-		if err := w.Flush(); err != nil {
-			return err
-		}
-
-		return f.Sync()
-	}()
-	if err != nil {
-		return fmt.Errorf("writing %s: %w", crdsFile, err)
-	}
-
-	removedPathList := append(append([]string{}, ContentDirs...), "strategicmergepatches", "jsonpatches", "patch-files", "transformer-patch-files", "kustomization.yaml", renderedFileName)
-
-	for _, f := range removedPathList {
-		d := filepath.Join(tempDir, f)
-		r.Logf("Removing %s", d)
-		if err := os.RemoveAll(d); err != nil {
-			return err
-		}
-	}
-
-	if len(crds) > 0 {
-		var crdsDir string
-
-		// Preserve original CRD location to maintain chart author's intent
-		// Issue: https://github.com/helmfile/helmfile/issues/2291
-		//
-		// If CRDs originally came from templates/ directory (e.g., templates/crds/),
-		// they were likely placed there intentionally for:
-		// - Conditional rendering with {{- if .Values.crds.install }}
-		// - Using template features like .Release.Namespace
-		// - Requiring CRD updates during helm upgrade
-		//
-		// Moving them to the special crds/ directory changes Helm's behavior:
-		// - templates/crds/: Regular resources, updated on upgrade, deleted on uninstall
-		// - crds/: Install-only, immutable on upgrade, not deleted on uninstall
-		if crdsFromTemplates {
-			// Preserve original location in templates/crds/
-			crdsDir = filepath.Join(tempDir, "templates", "crds")
-			r.Logf("Preserving CRDs in templates/crds/ (original location)")
-		} else if r.IsHelm3() || r.IsHelm4() {
-			// Use standard Helm 3/4 crds/ directory for CRDs from root crds/
-			crdsDir = filepath.Join(tempDir, "crds")
-			r.Logf("Placing CRDs in crds/ (standard Helm 3/4 location)")
-		} else {
-			// Helm 2 compatibility
-			crdsDir = filepath.Join(tempDir, "templates")
-		}
-
-		if err := os.MkdirAll(crdsDir, 0755); err != nil {
-			return err
-		}
-
-		if err := os.Rename(crdsFile, filepath.Join(crdsDir, "patched_crds.yaml")); err != nil {
-			return err
-		}
-	}
-
-	if len(resources) > 0 {
-		templatesDir := filepath.Join(tempDir, "templates")
-		if err := os.MkdirAll(templatesDir, 0755); err != nil {
-			return err
-		}
-
-		if err := os.Rename(resourcesFile, filepath.Join(templatesDir, "patched_resources.yaml")); err != nil {
-			return err
-		}
-	}
-
 	return nil
 }
 
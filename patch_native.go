@@ -0,0 +1,668 @@
+package chartify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// PatchEngine determines what applies the patches in PatchOpts.
+type PatchEngine string
+
+const (
+	// PatchEngineKustomize generates kustomization.yaml for all the patches and transformers, and runs `kustomize build`.
+	// This is the default.
+	PatchEngineKustomize PatchEngine = "kustomize"
+
+	// PatchEngineNative applies JSON patches (RFC 6902) and strategic merge patches in-process,
+	// without the kustomize binary.
+	// Strategic merge patches use the patch strategies of the built-in K8s types, and fall back to
+	// JSON merge patches (RFC 7386) for other types like custom resources.
+	// Resources that no patch applies to are written as they were rendered.
+	//
+	// kustomize is still run when there are transformers, to apply them to the natively patched resources.
+	// SortOptions, ExtraArgs and EnableAlphaPlugins take effect only in that case.
+	PatchEngineNative PatchEngine = "native"
+)
+
+// nativePatchedFileName is the file the natively patched resources are written to, when they need to be
+// transformed by kustomize afterwards.
+const nativePatchedFileName = "native.patched.yaml"
+
+// Validate returns an error if the engine is not one of the known values.
+func (e PatchEngine) Validate() error {
+	switch e {
+	case "", PatchEngineKustomize, PatchEngineNative:
+		return nil
+	default:
+		return fmt.Errorf("unsupported PatchEngine %q: it must be either %q or %q",
+			string(e), PatchEngineKustomize, PatchEngineNative)
+	}
+}
+
+// nativePatch applies the patches in u to the resources in-process and writes the result to renderedFile.
+// Transformers, if any, are applied by kustomize to the patched resources.
+func (r *Runner) nativePatch(tempDir string, resources []*Resource, renderedFile string, u *PatchOpts) error {
+	// Patch applies MergePatches and FieldSetters after transformers regardless of the engine
+	patchOpts := *u
+	patchOpts.MergePatches = nil
+	patchOpts.FieldSetters = nil
+
+	patched, err := r.PatchResources(resources, &patchOpts)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := WriteResources(&buf, patched); err != nil {
+		return err
+	}
+
+	if len(u.Transformers) == 0 {
+		return r.WriteFile(renderedFile, buf.Bytes(), 0644)
+	}
+
+	patchedFile := filepath.Join(tempDir, nativePatchedFileName)
+	if err := r.WriteFile(patchedFile, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	transformOpts := *u
+	transformOpts.JsonPatches = nil
+	transformOpts.StrategicMergePatches = nil
+	transformOpts.Patches = nil
+	transformOpts.HookPatches = nil
+
+	return r.kustomizePatch(tempDir, []string{patchedFile}, renderedFile, &transformOpts)
+}
+
+// PatchResources applies JsonPatches, StrategicMergePatches, Patches and HookPatches in the options to the resources
+// in-process, in the same order as kustomize does, followed by MergePatches and FieldSetters,
+// and returns the patched resources.
+// Transformers and the options for kustomize are ignored.
+//
+// Like kustomize, a strategic merge patch without a target must match at least one resource,
+// and the apiVersion, kind, name and namespace in a strategic merge patch are ignored when it has a target.
+// A strategic merge patch with `$patch: delete` at the top level removes the resources it matches.
+func (r *Runner) PatchResources(resources []*Resource, opts ...PatchOption) ([]*Resource, error) {
+	u := &PatchOpts{}
+
+	for i := range opts {
+		if err := opts[i].SetPatchOption(u); err != nil {
+			return nil, err
+		}
+	}
+
+	resources = append([]*Resource{}, resources...)
+
+	for _, f := range u.JsonPatches {
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var patch struct {
+			Target PatchTarget `yaml:"target"`
+			Patch  interface{} `yaml:"patch"`
+			Path   string      `yaml:"path"`
+		}
+		if err := yaml.Unmarshal(content, &patch); err != nil {
+			return nil, fmt.Errorf("parsing json patch %s: %w", f, err)
+		}
+
+		report := PatchReport{Type: PatchTypeJSON, Source: f}
+
+		// Like kustomize, which would otherwise patch every resource
+		if patch.Target == (PatchTarget{}) {
+			return nil, fmt.Errorf("%s: json patches must have a target", report)
+		}
+
+		ops, err := r.nativePatchContent(patch.Patch, patch.Path, filepath.Dir(f))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", report, err)
+		}
+
+		if resources, err = applyJSONPatch(resources, report, patch.Target, ops); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range u.StrategicMergePatches {
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		report := PatchReport{Type: PatchTypeStrategicMerge, Source: f}
+
+		if resources, err = applyStrategicMergePatch(resources, report, nil, content); err != nil {
+			return nil, err
+		}
+	}
+
+	patchFiles := append(append([]string{}, u.Patches...), u.HookPatches...)
+	for i, f := range patchFiles {
+		typ := PatchTypePatch
+		if i >= len(u.Patches) {
+			typ = PatchTypeHookPatch
+		}
+
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := parsePatchDocuments(content)
+		if err != nil {
+			return nil, fmt.Errorf("parsing patches file %s: %w", f, err)
+		}
+
+		for j, entry := range entries {
+			if typ == PatchTypeHookPatch {
+				if err := restrictTargetToHooks(entry); err != nil {
+					return nil, fmt.Errorf("processing hook patches file %s: %w", f, err)
+				}
+			}
+
+			report := PatchReport{Type: typ, Source: f, Index: j}
+
+			var target *PatchTarget
+			if v, ok := entry["target"]; ok {
+				t, err := decodePatchTarget(v)
+				if err != nil {
+					return nil, fmt.Errorf("parsing %s: %w", report, err)
+				}
+				target = &t
+			}
+
+			path, _ := entry["path"].(string)
+			patch, err := r.nativePatchContent(entry["patch"], path, filepath.Dir(f))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", report, err)
+			}
+
+			// Like kustomize, a patch that is a list of operations is a JSON patch, and otherwise a strategic merge patch
+			var doc interface{}
+			if err := yaml.Unmarshal(patch, &doc); err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", report, err)
+			}
+
+			if _, isJSONPatch := doc.([]interface{}); isJSONPatch {
+				if target == nil {
+					return nil, fmt.Errorf("%s: json patches must have a target", report)
+				}
+				resources, err = applyJSONPatch(resources, report, *target, patch)
+			} else {
+				resources, err = applyStrategicMergePatch(resources, report, target, patch)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	resources, err := r.applyMergePatches(resources, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyFieldSetters(resources, u.FieldSetters)
+}
+
+// nativePatchContent returns the content of a patch that is either inline or in the file at path.
+// Relative paths are resolved like resolveTransformerPath does, against the directory of the file defining the patch.
+func (r *Runner) nativePatchContent(inline interface{}, path, dir string) ([]byte, error) {
+	switch v := inline.(type) {
+	case nil:
+	case string:
+		return []byte(v), nil
+	default:
+		return yaml.Marshal(v)
+	}
+
+	if path == "" {
+		return nil, fmt.Errorf("either \"path\" or \"patch\" must be set")
+	}
+
+	resolved, _ := r.resolveTransformerPath(path, dir)
+
+	content, err := r.ReadFile(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("reading patch file %q: %w", path, err)
+	}
+
+	return content, nil
+}
+
+// applyJSONPatch applies the JSON patch operations, in either YAML or JSON, to the resources matching the target.
+func applyJSONPatch(resources []*Resource, report PatchReport, target PatchTarget, ops []byte) ([]*Resource, error) {
+	opsJSON, err := yamlToJSON(ops)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", report, err)
+	}
+
+	patch, err := jsonpatch.DecodePatch(opsJSON)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", report, err)
+	}
+
+	for i, res := range resources {
+		ok, err := target.Matches(res)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", report, err)
+		}
+
+		if !ok {
+			continue
+		}
+
+		doc, err := resourceJSON(res)
+		if err != nil {
+			return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+		}
+
+		patched, err := patch.Apply(doc)
+		if err != nil {
+			return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+		}
+
+		if resources[i], err = updateResource(res, patched); err != nil {
+			return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+		}
+	}
+
+	return resources, nil
+}
+
+// applyStrategicMergePatch applies every document in the strategic merge patch to the resources matching the target,
+// or the resources named after the document when the target is nil.
+func applyStrategicMergePatch(resources []*Resource, report PatchReport, target *PatchTarget, patch []byte) ([]*Resource, error) {
+	docs, err := ReadResources(bytes.NewReader(patch))
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", report, err)
+	}
+
+	for _, doc := range docs {
+		t := target
+		if t == nil {
+			targets, err := strategicMergePatchTargets(doc.Raw)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", report, err)
+			}
+			t = &targets[0]
+		}
+
+		var patchDoc map[string]interface{}
+		if err := doc.Node.Decode(&patchDoc); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", report, err)
+		}
+
+		remove := patchDoc["$patch"] == "delete"
+
+		var (
+			patched []*Resource
+			matched bool
+		)
+
+		for _, res := range resources {
+			ok, err := t.Matches(res)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", report, err)
+			}
+
+			if !ok {
+				patched = append(patched, res)
+				continue
+			}
+
+			matched = true
+
+			if remove {
+				continue
+			}
+
+			merged, err := strategicMerge(res, patchDoc)
+			if err != nil {
+				return nil, fmt.Errorf("applying %s to %s: %w", report, res.ID(), err)
+			}
+
+			patched = append(patched, merged)
+		}
+
+		if !matched && target == nil {
+			return nil, fmt.Errorf("%s: no resource matches %s", report, doc.ID())
+		}
+
+		resources = patched
+	}
+
+	return resources, nil
+}
+
+// strategicMerge applies the decoded strategic merge patch to the resource.
+// The apiVersion, kind, name and namespace in the patch are replaced with the resource's ones,
+// so that the patch never renames the resource it is applied to.
+func strategicMerge(res *Resource, patchDoc map[string]interface{}) (*Resource, error) {
+	id := res.ID()
+
+	patch := map[string]interface{}{}
+	for k, v := range patchDoc {
+		patch[k] = v
+	}
+
+	metadata := map[string]interface{}{}
+	if m, ok := patch["metadata"].(map[string]interface{}); ok {
+		for k, v := range m {
+			metadata[k] = v
+		}
+	}
+	delete(metadata, "name")
+	delete(metadata, "namespace")
+	// Resources named by generateName alone have no name
+	if id.Name != "" {
+		metadata["name"] = id.Name
+	}
+	if id.Namespace != "" {
+		metadata["namespace"] = id.Namespace
+	}
+
+	patch["apiVersion"] = id.APIVersion
+	patch["kind"] = id.Kind
+	patch["metadata"] = metadata
+
+	patchJSON, err := marshalJSON(patch)
+	if err != nil {
+		return nil, fmt.Errorf("encoding the patch in JSON: %w", err)
+	}
+
+	original, err := resourceJSON(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var merged []byte
+
+	obj, err := scheme.Scheme.New(schema.FromAPIVersionAndKind(id.APIVersion, id.Kind))
+	switch {
+	case err == nil:
+		merged, err = strategicpatch.StrategicMergePatch(original, patchJSON, obj)
+	case runtime.IsNotRegisteredError(err):
+		// The patch strategies of custom resources are unknown
+		merged, err = jsonpatch.MergePatch(original, patchJSON)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return updateResource(res, merged)
+}
+
+// resourceJSON returns the resource encoded in JSON.
+func resourceJSON(res *Resource) ([]byte, error) {
+	var v interface{}
+	if err := res.Node.Decode(&v); err != nil {
+		return nil, err
+	}
+
+	data, err := marshalJSON(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding the resource in JSON: %w", err)
+	}
+
+	return data, nil
+}
+
+// marshalJSON encodes the value decoded from YAML in JSON.
+// Unlike json.Marshal, it tells the path to the mapping key that JSON doesn't support, like a number or a boolean.
+func marshalJSON(v interface{}) ([]byte, error) {
+	if err := checkJSONKeys("", v); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// checkJSONKeys returns an error naming the first mapping key in v that isn't a string.
+// path is the path to v, in the same format as FieldChange.Path.
+func checkJSONKeys(path string, v interface{}) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if err := checkJSONKeys(appendFieldPath(path, k), v[k]); err != nil {
+				return err
+			}
+		}
+	case map[interface{}]interface{}:
+		// yaml.v3 decodes mappings to map[interface{}]interface{} only when any of the keys isn't a string
+		keys := make([]interface{}, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+
+		for _, k := range keys {
+			s, ok := k.(string)
+			if !ok {
+				return fmt.Errorf("%s: unsupported key %v of type %T: JSON supports only string keys", appendFieldPath(path, fmt.Sprint(k)), k, k)
+			}
+
+			if err := checkJSONKeys(appendFieldPath(path, s), v[k]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			if err := checkJSONKeys(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// updateResource returns the resource updated to the one encoded in JSON.
+// Unlike decoding the JSON from scratch, the fields and items of the resource keep their order and comments.
+func updateResource(res *Resource, data []byte) (*Resource, error) {
+	v, err := decodeJSON(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the resource again to leave the original node untouched
+	var doc yaml.Node
+	if err := yaml.Unmarshal(res.Raw, &doc); err != nil {
+		return nil, err
+	}
+
+	if err := updateNode(documentRoot(&doc), v); err != nil {
+		return nil, err
+	}
+
+	return resourceFromNode(&doc)
+}
+
+// updateNode updates the node in place so that it represents v.
+// Mapping keys and sequence items found in both keep their position and comments, removed ones are dropped,
+// and added ones are appended in the order of the keys or the items.
+func updateNode(node *yaml.Node, v interface{}) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if node.Kind != yaml.MappingNode {
+			return replaceNode(node, v)
+		}
+
+		var content []*yaml.Node
+
+		kept := map[string]bool{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			k := node.Content[i].Value
+
+			child, ok := v[k]
+			if !ok || kept[k] {
+				continue
+			}
+			kept[k] = true
+
+			if err := updateNode(node.Content[i+1], child); err != nil {
+				return err
+			}
+
+			content = append(content, node.Content[i], node.Content[i+1])
+		}
+
+		var added []string
+		for k := range v {
+			if !kept[k] {
+				added = append(added, k)
+			}
+		}
+		sort.Strings(added)
+
+		for _, k := range added {
+			var child yaml.Node
+			if err := child.Encode(v[k]); err != nil {
+				return err
+			}
+
+			content = append(content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, &child)
+		}
+
+		node.Content = content
+
+		return nil
+	case []interface{}:
+		if node.Kind != yaml.SequenceNode {
+			return replaceNode(node, v)
+		}
+
+		items := make([]*yaml.Node, len(v))
+		used := make([]bool, len(node.Content))
+
+		for i, item := range v {
+			j := matchingItem(node.Content, used, item, i)
+			if j < 0 {
+				items[i] = &yaml.Node{}
+				if err := items[i].Encode(item); err != nil {
+					return err
+				}
+				continue
+			}
+
+			used[j] = true
+
+			if err := updateNode(node.Content[j], item); err != nil {
+				return err
+			}
+
+			items[i] = node.Content[j]
+		}
+
+		node.Content = items
+
+		return nil
+	}
+
+	var n yaml.Node
+	if err := n.Encode(v); err != nil {
+		return err
+	}
+
+	// Keep the original notation of the same value, like quotes
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == n.ShortTag() && (node.Value == n.Value || n.ShortTag() == "!!null") {
+		return nil
+	}
+
+	return replaceNode(node, v)
+}
+
+// matchingItem returns the index of the unused item in items that corresponds to v at the index i,
+// or -1 if there is none.
+// Mappings are matched by their names like containers, scalars by their values, and others by their indices.
+func matchingItem(items []*yaml.Node, used []bool, v interface{}, i int) int {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if name, ok := v["name"].(string); ok {
+			for j, item := range items {
+				if n := mappingValue(item, "name"); !used[j] && n != nil && n.Value == name {
+					return j
+				}
+			}
+		}
+	case []interface{}:
+		// Nested sequences are matched by their indices
+	default:
+		var n yaml.Node
+		if err := n.Encode(v); err == nil {
+			for j, item := range items {
+				if !used[j] && item.Kind == yaml.ScalarNode && item.ShortTag() == n.ShortTag() && item.Value == n.Value {
+					return j
+				}
+			}
+		}
+	}
+
+	if i < len(items) && !used[i] {
+		return i
+	}
+
+	return -1
+}
+
+// replaceNode replaces the node with the one encoded from v, keeping the comments attached to the node.
+func replaceNode(node *yaml.Node, v interface{}) error {
+	var n yaml.Node
+	if err := n.Encode(v); err != nil {
+		return err
+	}
+
+	n.HeadComment, n.LineComment, n.FootComment = node.HeadComment, node.LineComment, node.FootComment
+
+	*node = n
+
+	return nil
+}
+
+// resourceFromNode returns the resource encoded from the YAML node.
+func resourceFromNode(node *yaml.Node) (*Resource, error) {
+	var buf bytes.Buffer
+	if err := encodeYAMLDocument(&buf, node); err != nil {
+		return nil, err
+	}
+
+	resources, err := ReadResources(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resources) != 1 {
+		return nil, fmt.Errorf("expected a single resource but got %d", len(resources))
+	}
+
+	return resources[0], nil
+}
+
+// yamlToJSON converts the YAML document, which may also be JSON, to JSON.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	return marshalJSON(v)
+}
@@ -0,0 +1,283 @@
+package chartify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const nativePatchTestResources = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: web
+        image: web:1.0
+      - name: sidecar
+        image: sidecar:1.0
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+spec:
+  sizes: [1, 2]
+  color: red
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install
+spec:
+  backoffLimit: 1
+`
+
+func TestRunner_PatchResources(t *testing.T) {
+	resources, err := ReadResources(strings.NewReader(nativePatchTestResources))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"json.yaml":     "target:\n  kind: Deployment\npatch:\n- op: replace\n  path: /spec/replicas\n  value: 3\n",
+		"ops.yaml":      "- op: add\n  path: /metadata/labels\n  value:\n    patched: \"true\"\n",
+		"jsonpath.yaml": "target:\n  kind: Widget\npath: ops.yaml\n",
+		"smp.yaml": `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+spec:
+  template:
+    spec:
+      containers:
+      - name: sidecar
+        image: sidecar:2.0
+`,
+		"patches.yaml": `- target:
+    kind: Widget
+  patch: |-
+    spec:
+      sizes: [3]
+- patch: |-
+    apiVersion: batch/v1
+    kind: Job
+    metadata:
+      name: migrate
+    $patch: delete
+`,
+		"hooks.yaml": "target:\n  kind: Job|Deployment\npatch: '[{\"op\": \"replace\", \"path\": \"/spec/backoffLimit\", \"value\": 5}]'\n",
+	})
+
+	r := New(WithLogf(t.Logf))
+
+	patched, err := r.PatchResources(resources, &PatchOpts{
+		JsonPatches:           []string{filepath.Join(dir, "json.yaml"), filepath.Join(dir, "jsonpath.yaml")},
+		StrategicMergePatches: []string{filepath.Join(dir, "smp.yaml")},
+		HookPatches:           []string{filepath.Join(dir, "hooks.yaml")},
+	})
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteResources(&buf, patched))
+	require.Equal(t, `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: web
+          image: web:1.0
+        - name: sidecar
+          image: sidecar:2.0
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+  labels:
+    patched: "true"
+spec:
+  sizes: [1, 2]
+  color: red
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install
+spec:
+  backoffLimit: 5
+`, buf.String(), "the fields must be kept in their original order and notation")
+
+	// Custom resources are merged as JSON merge patches, replacing lists
+	patched, err = r.PatchResources(resources, &PatchOpts{
+		Patches: []string{filepath.Join(dir, "patches.yaml")},
+	})
+	require.NoError(t, err)
+	require.Len(t, patched, 2)
+	require.Equal(t, resources[0], patched[0], "unpatched resources must be kept as is")
+	require.Contains(t, string(patched[1].Raw), "sizes: [3]\n")
+	require.Contains(t, string(patched[1].Raw), "color: red")
+
+	writeTestFiles(t, dir, map[string]string{
+		"unmatched.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web\n",
+		"notarget.yaml":  "patch: '[{\"op\": \"remove\", \"path\": \"/spec\"}]'\n",
+		"invalid.yaml":   "target:\n  kind: Deployment\npatch:\n- op: remove\n  path: /spec/paused\n",
+	})
+
+	_, err = r.PatchResources(resources, &PatchOpts{StrategicMergePatches: []string{filepath.Join(dir, "unmatched.yaml")}})
+	require.EqualError(t, err, "strategic merge patch "+filepath.Join(dir, "unmatched.yaml")+": no resource matches v1 ConfigMap web")
+
+	_, err = r.PatchResources(resources, &PatchOpts{Patches: []string{filepath.Join(dir, "notarget.yaml")}})
+	require.EqualError(t, err, "patch #0 in "+filepath.Join(dir, "notarget.yaml")+": json patches must have a target")
+
+	_, err = r.PatchResources(resources, &PatchOpts{JsonPatches: []string{filepath.Join(dir, "notarget.yaml")}})
+	require.EqualError(t, err, "json patch "+filepath.Join(dir, "notarget.yaml")+": json patches must have a target")
+
+	_, err = r.PatchResources(resources, &PatchOpts{JsonPatches: []string{filepath.Join(dir, "invalid.yaml")}})
+	require.ErrorContains(t, err, "applying json patch "+filepath.Join(dir, "invalid.yaml")+" to apps/v1 Deployment prod/web: ")
+}
+
+func TestChartify_NativePatchEngine(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"json.yaml": "target:\n  kind: Deployment\npatch:\n- op: replace\n  path: /spec/replicas\n  value: 3\n",
+		"smp.yaml":  "apiVersion: v1\nkind: Pod\nmetadata:\n  name: myapp-log-test-connection\n$patch: delete\n",
+		"transformer.yaml": `apiVersion: builtin
+kind: LabelTransformer
+metadata:
+  name: labels
+labels:
+  transformed: "true"
+fieldSpecs:
+- path: metadata/labels
+  create: true
+`,
+	})
+
+	// The native engine must not need kustomize
+	r := New(HelmBin(helm), KustomizeBin(filepath.Join(dir, "kustomize")), WithLogf(t.Logf))
+
+	_, err := r.Chartify("myapp", "testdata/charts/log", WithChartifyOpts(&ChartifyOpts{
+		JsonPatches: []string{filepath.Join(dir, "json.yaml")},
+		PatchEngine: "kustomise",
+	}))
+	require.EqualError(t, err, `unsupported PatchEngine "kustomise": it must be either "kustomize" or "native"`)
+
+	tmpDir, err := r.Chartify("myapp", "testdata/charts/log", WithChartifyOpts(&ChartifyOpts{
+		JsonPatches:           []string{filepath.Join(dir, "json.yaml")},
+		StrategicMergePatches: []string{filepath.Join(dir, "smp.yaml")},
+		PatchEngine:           PatchEngineNative,
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	resources, err := ReadResourcesFromFile(filepath.Join(tmpDir, "files", "templates", "patched_resources.yaml"))
+	require.NoError(t, err)
+	require.Len(t, resources, 1)
+	require.Equal(t, "Deployment", resources[0].Kind())
+	require.Contains(t, string(resources[0].Raw), "replicas: 3")
+
+	require.NoFileExists(t, filepath.Join(tmpDir, "kustomization.yaml"))
+	require.NoFileExists(t, filepath.Join(tmpDir, nativePatchedFileName))
+
+	// Transformers are still applied by kustomize, after the native patches
+	r = New(HelmBin(helm), WithLogf(t.Logf))
+
+	tmpDir, err = r.Chartify("myapp", "testdata/charts/log", WithChartifyOpts(&ChartifyOpts{
+		JsonPatches:  []string{filepath.Join(dir, "json.yaml")},
+		Transformers: []string{filepath.Join(dir, "transformer.yaml")},
+		PatchEngine:  PatchEngineNative,
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	resources, err = ReadResourcesFromFile(filepath.Join(tmpDir, "files", "templates", "patched_resources.yaml"))
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+	require.Contains(t, string(resources[0].Raw), "replicas: 3")
+	require.Equal(t, "true", resources[0].metadataMap("labels")["transformed"])
+	require.NoFileExists(t, filepath.Join(tmpDir, nativePatchedFileName))
+}
+
+func TestStrategicMerge(t *testing.T) {
+	resources, err := ReadResources(strings.NewReader(`# The job
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: migrate-
+  labels:
+    app: db # the app
+spec:
+  backoffLimit: 1
+  template:
+    spec:
+      containers:
+      - name: migrate
+        # pinned
+        image: "migrate:1.0"
+        args: [up]
+`))
+	require.NoError(t, err)
+
+	merged, err := strategicMerge(resources[0], map[string]interface{}{
+		"metadata": map[string]interface{}{"labels": map[string]interface{}{"tier": "backend"}},
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{map[string]interface{}{"name": "migrate", "args": []interface{}{"down"}}},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, `# The job
+apiVersion: batch/v1
+kind: Job
+metadata:
+  generateName: migrate-
+  labels:
+    app: db # the app
+    tier: backend
+spec:
+  backoffLimit: 1
+  template:
+    spec:
+      containers:
+        - name: migrate
+          # pinned
+          image: "migrate:1.0"
+          args: [down]
+`, string(merged.Raw), "the resource must keep its comments and field order, and have no name added")
+
+	_, err = strategicMerge(resources[0], map[string]interface{}{
+		"data": map[string]interface{}{"nested": map[interface{}]interface{}{1: "one"}},
+	})
+	require.EqualError(t, err, "encoding the patch in JSON: data.nested.1: unsupported key 1 of type int: JSON supports only string keys")
+}
@@ -0,0 +1,434 @@
+package chartify
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Types of the patches in PatchReport.
+const (
+	PatchTypeJSON           = "json patch"
+	PatchTypeStrategicMerge = "strategic merge patch"
+	PatchTypePatch          = "patch"
+	PatchTypeHookPatch      = "hook patch"
+	PatchTypeTransformer    = "transformer"
+	PatchTypeMergePatch     = "merge patch"
+	PatchTypeFieldSetter    = "field setter"
+)
+
+// PatchTarget selects the resources a patch applies to, in the same format as the target of kustomize patches.
+// Group, Version, Kind, Name and Namespace are regular expressions that must match the whole field,
+// and LabelSelector and AnnotationSelector are K8s label selectors like `app=web,tier!=db`.
+// An empty field matches any resource.
+type PatchTarget struct {
+	Group              string `yaml:"group,omitempty"`
+	Version            string `yaml:"version,omitempty"`
+	Kind               string `yaml:"kind,omitempty"`
+	Name               string `yaml:"name,omitempty"`
+	Namespace          string `yaml:"namespace,omitempty"`
+	LabelSelector      string `yaml:"labelSelector,omitempty"`
+	AnnotationSelector string `yaml:"annotationSelector,omitempty"`
+}
+
+// Matches returns true when the resource is selected by the target.
+func (t PatchTarget) Matches(res *Resource) (bool, error) {
+	group, version := res.GroupVersion()
+
+	for _, f := range []struct{ name, pattern, value string }{
+		{"group", t.Group, group},
+		{"version", t.Version, version},
+		{"kind", t.Kind, res.Kind()},
+		{"name", t.Name, res.Name()},
+		{"namespace", t.Namespace, res.Namespace()},
+	} {
+		if f.pattern == "" {
+			continue
+		}
+
+		re, err := regexp.Compile("^(?:" + f.pattern + ")$")
+		if err != nil {
+			return false, fmt.Errorf("invalid %s %q in target: %w", f.name, f.pattern, err)
+		}
+
+		if !re.MatchString(f.value) {
+			return false, nil
+		}
+	}
+
+	for _, s := range []struct{ name, selector, field string }{
+		{"labelSelector", t.LabelSelector, "labels"},
+		{"annotationSelector", t.AnnotationSelector, "annotations"},
+	} {
+		if s.selector == "" {
+			continue
+		}
+
+		selector, err := labels.Parse(s.selector)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s %q in target: %w", s.name, s.selector, err)
+		}
+
+		if !selector.Matches(labels.Set(res.metadataMap(s.field))) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// String returns the target in the form of a label selector like `kind=Deployment,name=web`.
+func (t PatchTarget) String() string {
+	var fields []string
+	for _, f := range []struct{ name, value string }{
+		{"group", t.Group},
+		{"version", t.Version},
+		{"kind", t.Kind},
+		{"name", t.Name},
+		{"namespace", t.Namespace},
+		{"labelSelector", t.LabelSelector},
+		{"annotationSelector", t.AnnotationSelector},
+	} {
+		if f.value != "" {
+			fields = append(fields, fmt.Sprintf("%s=%s", f.name, f.value))
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// metadataMap returns the string map at metadata.<field> of the resource, like its labels.
+func (r *Resource) metadataMap(field string) map[string]string {
+	m := mappingValue(mappingValue(documentRoot(r.Node), "metadata"), field)
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	values := map[string]string{}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		values[m.Content[i].Value] = m.Content[i+1].Value
+	}
+	return values
+}
+
+// PatchReport records the resources a patch matched.
+type PatchReport struct {
+	// Type is the type of the patch, like PatchTypeJSON.
+	Type string
+
+	// Source is the file the patch is defined in, or the path of the field for field setters.
+	Source string
+
+	// Index is the index of the patch among the ones defined in Source, or the index of the field setter.
+	// It is always 0 for json patches, strategic merge patches and merge patches, whose files define a single patch.
+	Index int
+
+	// Targets are the targets of the patch.
+	// Strategic merge patches without targets target the resources they're named after.
+	Targets []PatchTarget
+
+	// Matched are the resources matched by any of the targets.
+	Matched []ResourceID
+}
+
+func (p PatchReport) String() string {
+	switch p.Type {
+	case PatchTypeJSON, PatchTypeStrategicMerge, PatchTypeMergePatch:
+		return fmt.Sprintf("%s %s", p.Type, p.Source)
+	case PatchTypeFieldSetter:
+		return fmt.Sprintf("%s #%d at %s", p.Type, p.Index, p.Source)
+	}
+	return fmt.Sprintf("%s #%d in %s", p.Type, p.Index, p.Source)
+}
+
+// MatchPatches reports the resources each of the patches and transformers in the options matches.
+//
+// The targets of the patches are evaluated against the resources before patching, so a target that selects resources
+// by a field changed by a preceding patch is reported as it would have been before the change.
+// Transformers are reported only when they have targets, like PatchTransformer, as what other transformers
+// apply to depends on the transformer.
+func (r *Runner) MatchPatches(resources []*Resource, opts ...PatchOption) ([]PatchReport, error) {
+	u := &PatchOpts{}
+
+	for i := range opts {
+		if err := opts[i].SetPatchOption(u); err != nil {
+			return nil, err
+		}
+	}
+
+	reports, err := r.patchReports(u)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range reports {
+		p := &reports[i]
+
+		for _, res := range resources {
+			for _, t := range p.Targets {
+				ok, err := t.Matches(res)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", p, err)
+				}
+
+				if ok {
+					p.Matched = append(p.Matched, res.ID())
+					break
+				}
+			}
+		}
+	}
+
+	return reports, nil
+}
+
+// checkPatchMatches logs the resources each patch matches, and returns an error if u.FailOnUnmatchedPatch is set
+// and any patch matches no resources.
+func (r *Runner) checkPatchMatches(resources []*Resource, u *PatchOpts) error {
+	reports, err := r.MatchPatches(resources, u)
+	if err != nil {
+		return err
+	}
+
+	var unmatched []string
+
+	for _, p := range reports {
+		if len(p.Matched) == 0 {
+			r.Logf("%s matched no resources with %v", p, p.Targets)
+			unmatched = append(unmatched, p.String())
+			continue
+		}
+
+		ids := make([]string, len(p.Matched))
+		for i, id := range p.Matched {
+			ids[i] = id.String()
+		}
+		r.Logf("%s matched %s", p, strings.Join(ids, ", "))
+	}
+
+	if u.FailOnUnmatchedPatch && len(unmatched) > 0 {
+		return fmt.Errorf("patches matched no resources:\n- %s", strings.Join(unmatched, "\n- "))
+	}
+
+	return nil
+}
+
+// patchReports returns the reports of the patches and transformers in u, without matched resources.
+func (r *Runner) patchReports(u *PatchOpts) ([]PatchReport, error) {
+	var reports []PatchReport
+
+	for _, f := range u.JsonPatches {
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var patch struct {
+			Target PatchTarget `yaml:"target"`
+		}
+		if err := yaml.Unmarshal(content, &patch); err != nil {
+			return nil, fmt.Errorf("parsing json patch %s: %w", f, err)
+		}
+
+		reports = append(reports, PatchReport{Type: PatchTypeJSON, Source: f, Targets: []PatchTarget{patch.Target}})
+	}
+
+	for _, f := range u.StrategicMergePatches {
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		targets, err := strategicMergePatchTargets(content)
+		if err != nil {
+			return nil, fmt.Errorf("parsing strategic merge patch %s: %w", f, err)
+		}
+
+		reports = append(reports, PatchReport{Type: PatchTypeStrategicMerge, Source: f, Targets: targets})
+	}
+
+	patchFiles := append(append([]string{}, u.Patches...), u.HookPatches...)
+	for i, f := range patchFiles {
+		typ := PatchTypePatch
+		if i >= len(u.Patches) {
+			typ = PatchTypeHookPatch
+		}
+
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err := parsePatchDocuments(content)
+		if err != nil {
+			return nil, fmt.Errorf("parsing patches file %s: %w", f, err)
+		}
+
+		for j, entry := range entries {
+			if typ == PatchTypeHookPatch {
+				if err := restrictTargetToHooks(entry); err != nil {
+					return nil, fmt.Errorf("processing hook patches file %s: %w", f, err)
+				}
+			}
+
+			report := PatchReport{Type: typ, Source: f, Index: j}
+
+			if target, ok := entry["target"]; ok {
+				t, err := decodePatchTarget(target)
+				if err != nil {
+					return nil, fmt.Errorf("parsing %s: %w", report, err)
+				}
+				report.Targets = []PatchTarget{t}
+			} else {
+				var patch []byte
+				if p, ok := entry["patch"].(string); ok {
+					patch = []byte(p)
+				} else if p, ok := entry["path"].(string); ok && p != "" {
+					resolved, _ := r.resolveTransformerPath(p, filepath.Dir(f))
+					if patch, err = r.ReadFile(resolved); err != nil {
+						return nil, fmt.Errorf("reading file referenced by %s: %w", report, err)
+					}
+				}
+
+				if report.Targets, err = strategicMergePatchTargets(patch); err != nil {
+					return nil, fmt.Errorf("parsing %s: %w", report, err)
+				}
+			}
+
+			reports = append(reports, report)
+		}
+	}
+
+	for _, f := range u.Transformers {
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		transformers, err := decodeYAMLMaps(content)
+		if err != nil {
+			return nil, fmt.Errorf("parsing transformer %s: %w", f, err)
+		}
+
+		for j, transformer := range transformers {
+			target, ok := transformer["target"]
+			if !ok {
+				continue
+			}
+
+			report := PatchReport{Type: PatchTypeTransformer, Source: f, Index: j}
+
+			t, err := decodePatchTarget(target)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s: %w", report, err)
+			}
+			report.Targets = []PatchTarget{t}
+
+			reports = append(reports, report)
+		}
+	}
+
+	for _, f := range u.MergePatches {
+		content, err := r.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var patch struct {
+			Target PatchTarget `yaml:"target"`
+		}
+		if err := yaml.Unmarshal(content, &patch); err != nil {
+			return nil, fmt.Errorf("parsing merge patch %s: %w", f, err)
+		}
+
+		reports = append(reports, PatchReport{Type: PatchTypeMergePatch, Source: f, Targets: []PatchTarget{patch.Target}})
+	}
+
+	for i, s := range u.FieldSetters {
+		reports = append(reports, PatchReport{Type: PatchTypeFieldSetter, Source: s.Path, Index: i, Targets: []PatchTarget{s.Target}})
+	}
+
+	return reports, nil
+}
+
+// decodePatchTarget decodes the target of a patch decoded as a generic YAML value.
+func decodePatchTarget(v interface{}) (PatchTarget, error) {
+	var t PatchTarget
+
+	bs, err := yaml.Marshal(v)
+	if err != nil {
+		return t, err
+	}
+
+	if err := yaml.Unmarshal(bs, &t); err != nil {
+		return t, fmt.Errorf("invalid target: %w", err)
+	}
+
+	return t, nil
+}
+
+// strategicMergePatchTargets returns the targets of the strategic merge patch without an explicit target,
+// which are the resources with the same kind, name and namespace as the patch documents.
+func strategicMergePatchTargets(patch []byte) ([]PatchTarget, error) {
+	docs, err := ReadResources(bytes.NewReader(patch))
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []PatchTarget
+	for _, doc := range docs {
+		group, version := doc.GroupVersion()
+
+		t := PatchTarget{
+			Group:     regexp.QuoteMeta(group),
+			Version:   regexp.QuoteMeta(version),
+			Kind:      regexp.QuoteMeta(doc.Kind()),
+			Name:      regexp.QuoteMeta(doc.Name()),
+			Namespace: regexp.QuoteMeta(doc.Namespace()),
+		}
+
+		// The core group is empty, which would otherwise match any group
+		if group == "" && version != "" {
+			t.Group = "^$"
+		}
+
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// decodeYAMLMaps decodes the YAML stream whose documents are either mappings or lists of mappings.
+// Other documents are ignored.
+func decodeYAMLMaps(content []byte) ([]map[string]interface{}, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+
+	var maps []map[string]interface{}
+	for {
+		var doc interface{}
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			maps = append(maps, v)
+		case []interface{}:
+			for _, item := range v {
+				if m, ok := item.(map[string]interface{}); ok {
+					maps = append(maps, m)
+				}
+			}
+		}
+	}
+
+	return maps, nil
+}
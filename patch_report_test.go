@@ -0,0 +1,177 @@
+package chartify
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const patchReportTestResources = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  labels:
+    app: web
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web-config
+  namespace: prod
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+  annotations:
+    helm.sh/hook: pre-install
+`
+
+func TestPatchTarget_Matches(t *testing.T) {
+	resources, err := ReadResources(strings.NewReader(patchReportTestResources))
+	require.NoError(t, err)
+
+	deploy, cm := resources[0], resources[1]
+
+	for _, tc := range []struct {
+		target PatchTarget
+		want   []bool
+	}{
+		{PatchTarget{}, []bool{true, true}},
+		{PatchTarget{Name: "web.*"}, []bool{true, true}},
+		{PatchTarget{Name: "web"}, []bool{true, false}},
+		{PatchTarget{Group: "apps", Version: "v1", Kind: "Deployment", Namespace: "prod"}, []bool{true, false}},
+		{PatchTarget{Version: "v1", Kind: "ConfigMap"}, []bool{false, true}},
+		{PatchTarget{Namespace: "dev"}, []bool{false, false}},
+		{PatchTarget{LabelSelector: "app in (web,api)"}, []bool{true, false}},
+		{PatchTarget{LabelSelector: "app!=web"}, []bool{false, true}},
+	} {
+		for i, res := range []*Resource{deploy, cm} {
+			ok, err := tc.target.Matches(res)
+			require.NoError(t, err)
+			require.Equal(t, tc.want[i], ok, "%s against %s", tc.target, res.ID())
+		}
+	}
+
+	_, err = PatchTarget{Name: "("}.Matches(deploy)
+	require.ErrorContains(t, err, `invalid name "(" in target`)
+
+	_, err = PatchTarget{LabelSelector: "app in web"}.Matches(deploy)
+	require.ErrorContains(t, err, `invalid labelSelector "app in web" in target`)
+}
+
+func TestRunner_MatchPatches(t *testing.T) {
+	resources, err := ReadResources(strings.NewReader(patchReportTestResources))
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"json.yaml": "target:\n  kind: Deployment\npatch:\n- op: replace\n  path: /spec/replicas\n  value: 3\n",
+		"smp.yaml":  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: web-config\ndata:\n  foo: bar\n",
+		"patches.yaml": `- target:
+    kind: Service
+  patch: |-
+    - op: add
+      path: /metadata/labels/foo
+      value: bar
+- patch: |-
+    apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: web
+    spec:
+      replicas: 2
+`,
+		"hooks.yaml": "target:\n  kind: Job|Deployment\npatch: |-\n  - op: add\n    path: /metadata/labels/foo\n    value: bar\n",
+		"transformer.yaml": `apiVersion: builtin
+kind: LabelTransformer
+metadata:
+  name: labels
+labels:
+  foo: bar
+---
+apiVersion: builtin
+kind: PatchTransformer
+metadata:
+  name: patch
+target:
+  labelSelector: app=web
+patch: '[{"op": "add", "path": "/metadata/labels/bar", "value": "baz"}]'
+`,
+	})
+
+	r := New(WithLogf(t.Logf))
+
+	reports, err := r.MatchPatches(resources, &PatchOpts{
+		JsonPatches:           []string{filepath.Join(dir, "json.yaml")},
+		StrategicMergePatches: []string{filepath.Join(dir, "smp.yaml")},
+		Patches:               []string{filepath.Join(dir, "patches.yaml")},
+		HookPatches:           []string{filepath.Join(dir, "hooks.yaml")},
+		Transformers:          []string{filepath.Join(dir, "transformer.yaml")},
+	})
+	require.NoError(t, err)
+
+	deploy := ResourceID{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "prod", Name: "web"}
+	cm := ResourceID{APIVersion: "v1", Kind: "ConfigMap", Namespace: "prod", Name: "web-config"}
+	job := ResourceID{APIVersion: "batch/v1", Kind: "Job", Name: "migrate"}
+
+	var got []string
+	matched := map[string][]ResourceID{}
+	for _, p := range reports {
+		got = append(got, p.String())
+		matched[p.String()] = p.Matched
+	}
+
+	require.Equal(t, []string{
+		"json patch " + filepath.Join(dir, "json.yaml"),
+		"strategic merge patch " + filepath.Join(dir, "smp.yaml"),
+		"patch #0 in " + filepath.Join(dir, "patches.yaml"),
+		"patch #1 in " + filepath.Join(dir, "patches.yaml"),
+		"hook patch #0 in " + filepath.Join(dir, "hooks.yaml"),
+		"transformer #1 in " + filepath.Join(dir, "transformer.yaml"),
+	}, got)
+
+	require.Equal(t, []ResourceID{deploy}, matched[got[0]])
+	require.Equal(t, []ResourceID{cm}, matched[got[1]])
+	require.Empty(t, matched[got[2]])
+	require.Equal(t, []ResourceID{deploy}, matched[got[3]])
+	require.Equal(t, []ResourceID{job}, matched[got[4]])
+	require.Equal(t, []ResourceID{deploy}, matched[got[5]])
+
+	err = r.checkPatchMatches(resources, &PatchOpts{
+		Patches:              []string{filepath.Join(dir, "patches.yaml")},
+		FailOnUnmatchedPatch: true,
+	})
+	require.EqualError(t, err, "patches matched no resources:\n- patch #0 in "+filepath.Join(dir, "patches.yaml"))
+}
+
+func TestChartify_FailOnUnmatchedPatch(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	patch := filepath.Join(t.TempDir(), "patch.yaml")
+	require.NoError(t, os.WriteFile(patch, []byte("target:\n  kind: StatefulSet\npatch:\n- op: replace\n  path: /spec/replicas\n  value: 3\n"), 0644))
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", "testdata/charts/log", WithChartifyOpts(&ChartifyOpts{
+		JsonPatches: []string{patch},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	_, err = r.Chartify("myapp", "testdata/charts/log", WithChartifyOpts(&ChartifyOpts{
+		JsonPatches:          []string{patch},
+		FailOnUnmatchedPatch: true,
+	}))
+	require.ErrorContains(t, err, "patches matched no resources:\n- json patch "+patch)
+}
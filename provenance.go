@@ -0,0 +1,67 @@
+package chartify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	provenancev4 "helm.sh/helm/v4/pkg/provenance"
+)
+
+// defaultKeyring returns the keyring helm uses when no --keyring flag is given.
+func defaultKeyring() string {
+	if v, ok := os.LookupEnv("GNUPGHOME"); ok {
+		return filepath.Join(v, "pubring.gpg")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".gnupg", "pubring.gpg")
+	}
+	return filepath.Join(home, ".gnupg", "pubring.gpg")
+}
+
+// VerifyChartArchive verifies the chart archive at path against the provenance file
+// located next to it (path + ".prov"), using the public keys in the keyring.
+// It uses the default keyring of helm when keyring is empty.
+func VerifyChartArchive(path, keyring string) error {
+	if keyring == "" {
+		keyring = defaultKeyring()
+	}
+
+	provPath := path + ".prov"
+
+	archiveData, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading chart archive %s: %w", path, err)
+	}
+
+	provData, err := os.ReadFile(provPath)
+	if err != nil {
+		return fmt.Errorf("reading provenance file %s: %w", provPath, err)
+	}
+
+	sig, err := provenancev4.NewFromKeyring(keyring, "")
+	if err != nil {
+		return fmt.Errorf("loading keyring %s: %w", keyring, err)
+	}
+
+	if _, err := sig.Verify(archiveData, provData, filepath.Base(path)); err != nil {
+		return fmt.Errorf("verifying %s against %s: %w", path, provPath, err)
+	}
+
+	return nil
+}
+
+// verifyFlags returns the helm flags to verify charts being pulled or downloaded as dependencies.
+func verifyFlags(verify bool, keyring string) []string {
+	if !verify {
+		return nil
+	}
+
+	flags := []string{"--verify"}
+	if keyring != "" {
+		flags = append(flags, "--keyring", keyring)
+	}
+
+	return flags
+}
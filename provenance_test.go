@@ -0,0 +1,150 @@
+package chartify
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/helmfile/chartify/chartrepo"
+	"github.com/helmfile/chartify/helmtesting"
+)
+
+const (
+	testSigningKey     = "chartify-test"
+	testSecretKeyring  = "testdata/keys/secring.gpg"
+	testPublicKeyring  = "testdata/keys/pubring.gpg"
+	testUntrustedRing  = "testdata/keys/other-pubring.gpg"
+	testSignedRepoName = "signedrepo"
+)
+
+// packageSignedChart packages the chart at chartDir with a provenance file signed by the test key,
+// and returns the path to the chart archive.
+func packageSignedChart(t *testing.T, chartDir string) string {
+	t.Helper()
+
+	dest := t.TempDir()
+	keyring, err := filepath.Abs(testSecretKeyring)
+	require.NoError(t, err)
+
+	cmd := exec.Command(helm, "package", chartDir, "-d", dest, "--sign", "--key", testSigningKey, "--keyring", keyring)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	matches, err := filepath.Glob(filepath.Join(dest, "*.tgz"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+
+	return matches[0]
+}
+
+func TestVerifyChartArchive(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	archive := packageSignedChart(t, "testdata/charts/log")
+
+	require.NoError(t, VerifyChartArchive(archive, testPublicKeyring))
+
+	err := VerifyChartArchive(archive, testUntrustedRing)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown entity")
+
+	unsigned := filepath.Join(t.TempDir(), filepath.Base(archive))
+	require.NoError(t, CopyFile(archive, unsigned))
+
+	err = VerifyChartArchive(unsigned, testPublicKeyring)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "reading provenance file")
+}
+
+func TestVerifyFlags(t *testing.T) {
+	require.Empty(t, verifyFlags(false, "keyring.gpg"))
+	require.Equal(t, []string{"--verify"}, verifyFlags(true, ""))
+	require.Equal(t, []string{"--verify", "--keyring", "keyring.gpg"}, verifyFlags(true, "keyring.gpg"))
+}
+
+func TestChartify_Verify(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	srv := helmtesting.StartChartRepoServer(t, helmtesting.ChartRepoServerConfig{
+		Port:           chartrepo.RandomPort,
+		ChartsDir:      "testdata/charts",
+		SigningKey:     testSigningKey,
+		SigningKeyring: testSecretKeyring,
+	})
+	helmtesting.AddChartRepo(t, helm, testSignedRepoName, srv)
+
+	signedArchive := packageSignedChart(t, "testdata/charts/log")
+
+	tests := []struct {
+		name    string
+		chart   string
+		keyring string
+		wantErr string
+	}{
+		{
+			name:    "remote chart signed by a trusted key",
+			chart:   testSignedRepoName + "/log",
+			keyring: testPublicKeyring,
+		},
+		{
+			name:    "remote chart signed by an untrusted key",
+			chart:   testSignedRepoName + "/log",
+			keyring: testUntrustedRing,
+			wantErr: "unknown entity",
+		},
+		{
+			name:    "local chart archive signed by a trusted key",
+			chart:   signedArchive,
+			keyring: testPublicKeyring,
+		},
+		{
+			name:    "local chart archive signed by an untrusted key",
+			chart:   signedArchive,
+			keyring: testUntrustedRing,
+			wantErr: "unknown entity",
+		},
+		{
+			name:    "local chart directory",
+			chart:   "testdata/charts/log",
+			keyring: testPublicKeyring,
+			wantErr: "unpacked charts cannot be verified",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := filepath.Abs(tt.keyring)
+			require.NoError(t, err)
+
+			r := New(HelmBin(helm))
+
+			tmpDir, err := r.Chartify("myapp", tt.chart, WithChartifyOpts(&ChartifyOpts{
+				Verify:  true,
+				Keyring: keyring,
+			}))
+			if tmpDir != "" {
+				t.Cleanup(func() {
+					_ = os.RemoveAll(tmpDir)
+				})
+			}
+
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.FileExists(t, filepath.Join(tmpDir, "Chart.yaml"))
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package chartify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	releaseutil "helm.sh/helm/v4/pkg/release/v1/util"
+)
+
+// Render runs the same pipeline as Chartify, and returns the resulting K8s resources instead of the generated chart.
+// The resources are taken from the pipeline right after they are patched and injected, so that the chart is rendered only once,
+// and are ordered the way `helm template` outputs them. The generated chart is removed afterwards.
+//
+// The CustomResourceDefinitions that are placed in the companion chart by CRDPlacementSeparateChart, or in the crds directory
+// when IncludeCRDs is set, are returned before the other resources.
+func (r *Runner) Render(release, dirOrChart string, opts ...ChartifyOption) ([]*Resource, error) {
+	u := &ChartifyOpts{}
+
+	for i := range opts {
+		if err := opts[i].SetChartifyOption(u); err != nil {
+			return nil, err
+		}
+	}
+
+	chart, resources, err := r.chartify(release, dirOrChart, u, true)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.RemoveAll(chart)
+	}()
+
+	return resources, nil
+}
+
+// readRenderedResources reads the K8s resources rendered into the chart being generated in chartDir,
+// in the order `helm template` would output them.
+func (r *Runner) readRenderedResources(chartDir string, includeCRDs bool) ([]*Resource, error) {
+	var resources []*Resource
+
+	crdDirs := []string{filepath.Join(CRDsChartPath(chartDir), "templates")}
+	if includeCRDs {
+		crdDirs = append(crdDirs, filepath.Join(chartDir, "crds"))
+	}
+
+	for _, d := range crdDirs {
+		files, err := r.readManifestFiles(chartDir, d)
+		if err != nil {
+			return nil, err
+		}
+
+		crds, err := sortRenderedManifests(files)
+		if err != nil {
+			return nil, err
+		}
+
+		resources = append(resources, crds...)
+	}
+
+	files := map[string]string{}
+	for _, d := range []string{"templates", "charts"} {
+		fs, err := r.readManifestFiles(chartDir, filepath.Join(chartDir, d))
+		if err != nil {
+			return nil, err
+		}
+
+		for f, content := range fs {
+			if isTemplateOrigin(f) {
+				files[f] = content
+			}
+		}
+	}
+
+	rendered, err := sortRenderedManifests(files)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(resources, rendered...), nil
+}
+
+// readManifestFiles reads the YAML files under dir, keyed by their slash-separated paths relative to chartDir.
+func (r *Runner) readManifestFiles(chartDir, dir string) (map[string]string, error) {
+	files := map[string]string{}
+
+	if !r.dirExists(dir) {
+		return files, nil
+	}
+
+	err := r.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(chartDir, path)
+		if err != nil {
+			return fmt.Errorf("calculating relative path to %s from %s: %w", path, chartDir, err)
+		}
+
+		content, err := r.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		files[filepath.ToSlash(rel)] = string(content)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// sortRenderedManifests reads the resources from the files, sorting them by kind in the install order
+// and placing hooks after the other resources, as `helm template` does.
+func sortRenderedManifests(files map[string]string) ([]*Resource, error) {
+	hooks, manifests, err := releaseutil.SortManifests(files, nil, releaseutil.InstallOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	var contents []string
+	for _, m := range manifests {
+		contents = append(contents, m.Content)
+	}
+	for _, h := range hooks {
+		contents = append(contents, h.Manifest)
+	}
+
+	var resources []*Resource
+	for _, c := range contents {
+		rs, err := ReadResources(strings.NewReader(c))
+		if err != nil {
+			return nil, fmt.Errorf("reading rendered resources: %w", err)
+		}
+
+		resources = append(resources, rs...)
+	}
+
+	return resources, nil
+}
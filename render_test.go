@@ -0,0 +1,110 @@
+package chartify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	workDir := t.TempDir()
+	t.Setenv(EnvVarTempDir, workDir)
+
+	patch := filepath.Join(t.TempDir(), "patch.yaml")
+	require.NoError(t, os.WriteFile(patch, []byte(`target:
+  kind: Deployment
+patch:
+- op: replace
+  path: /spec/replicas
+  value: 3
+`), 0644))
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	render := func(t *testing.T, dirOrChart string, opts *ChartifyOpts) []*Resource {
+		t.Helper()
+
+		resources, err := r.Render("myapp", dirOrChart, WithChartifyOpts(opts))
+		require.NoError(t, err)
+
+		entries, err := os.ReadDir(workDir)
+		require.NoError(t, err)
+		require.Empty(t, entries, "the generated chart must be removed")
+
+		return resources
+	}
+
+	kinds := func(resources []*Resource) []string {
+		var kinds []string
+		for _, res := range resources {
+			kinds = append(kinds, res.Kind())
+		}
+		return kinds
+	}
+
+	t.Run("patched chart", func(t *testing.T) {
+		resources := render(t, "testdata/charts/log", &ChartifyOpts{
+			OverrideNamespace: "ns",
+			JsonPatches:       []string{patch},
+			SetFlags:          []string{"--set", "image.tag=1.0"},
+		})
+		require.Equal(t, []string{"Deployment", "Pod"}, kinds(resources))
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteResources(&buf, resources))
+		require.Contains(t, buf.String(), "replicas: 3")
+		require.Contains(t, buf.String(), "namespace: ns")
+		require.Contains(t, buf.String(), "image: nginx:1.0")
+		require.NotContains(t, buf.String(), "files/")
+	})
+
+	t.Run("unmodified chart", func(t *testing.T) {
+		resources := render(t, "testdata/charts/log", &ChartifyOpts{
+			SetFlags: []string{"--set", "replicaCount=2"},
+		})
+		require.Equal(t, []string{"Deployment", "Pod"}, kinds(resources))
+		require.Contains(t, string(resources[0].Raw), "replicas: 2")
+	})
+
+	t.Run("values from stdin", func(t *testing.T) {
+		setStdin(t, "image:\n  tag: \"2.0\"\n")
+
+		// The chart is rendered only once, so the standard input is read only once
+		resources := render(t, "testdata/charts/log", &ChartifyOpts{
+			ValuesFiles: []string{"-"},
+			JsonPatches: []string{patch},
+		})
+		require.Equal(t, []string{"Deployment", "Pod"}, kinds(resources))
+		require.Contains(t, string(resources[0].Raw), "replicas: 3")
+		require.Contains(t, string(resources[0].Raw), "image: nginx:2.0")
+	})
+
+	t.Run("crds in a separate chart", func(t *testing.T) {
+		chart := filepath.Join(t.TempDir(), "mixed")
+		writeTestFiles(t, chart, map[string]string{
+			"Chart.yaml":               "apiVersion: v2\nname: mixed\nversion: 0.2.0\n",
+			"templates/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n",
+			"templates/crds/a.yaml":    testCRD("as.example.com"),
+		})
+
+		resources := render(t, chart, &ChartifyOpts{
+			CRDPlacement: CRDPlacementSeparateChart,
+		})
+		require.Equal(t, []string{"CustomResourceDefinition", "ConfigMap"}, kinds(resources))
+	})
+
+	t.Run("manifests", func(t *testing.T) {
+		resources := render(t, "testdata/kube_manifest", &ChartifyOpts{})
+		require.Equal(t, []string{"ConfigMap", "ConfigMap", "ConfigMap"}, kinds(resources))
+		require.Contains(t, string(resources[0].Raw), "-----BEGIN CERTIFICATE-----")
+	})
+}
@@ -5,8 +5,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-
-	"gopkg.in/yaml.v3"
 )
 
 type ReplaceWithRenderedOpts struct {
@@ -64,33 +62,42 @@ type ReplaceWithRenderedOpts struct {
 	WorkaroundOutputDirIssue bool
 }
 
-func (r *Runner) ReplaceWithRendered(name, chartName, chartPath string, o ReplaceWithRenderedOpts) ([]string, error) {
-	var additionalFlags string
-	additionalFlags += createFlagChain("set", o.SetValues)
+// templateFlags returns the flags to `helm template` for the values, the namespace and the capabilities in o,
+// as a string starting with a space.
+func templateFlags(o ReplaceWithRenderedOpts) string {
+	var flags string
+	flags += createFlagChain("set", o.SetValues)
 	if len(o.SetFlags) > 0 {
-		additionalFlags += " " + strings.Join(o.SetFlags, " ")
-	}
-	defaultValuesPath := filepath.Join(chartPath, "values.yaml")
-	exists, err := r.Exists(defaultValuesPath)
-	if err != nil {
-		return nil, err
+		flags += " " + strings.Join(o.SetFlags, " ")
 	}
-	if exists {
-		additionalFlags += createFlagChain("f", []string{defaultValuesPath})
-	}
-	additionalFlags += createFlagChain("f", o.ValuesFiles)
+	flags += createFlagChain("f", o.ValuesFiles)
 	if o.Namespace != "" {
-		additionalFlags += createFlagChain("namespace", []string{o.Namespace})
+		flags += createFlagChain("namespace", []string{o.Namespace})
 	}
 	if o.KubeVersion != "" {
-		additionalFlags += createFlagChain("kube-version", []string{o.KubeVersion})
+		flags += createFlagChain("kube-version", []string{o.KubeVersion})
 	}
-	additionalFlags += createFlagChain("api-versions", o.ApiVersions)
+	flags += createFlagChain("api-versions", o.ApiVersions)
 
 	if o.TemplateArgs != "" {
-		additionalFlags += fmt.Sprintf(" %s", o.TemplateArgs)
+		flags += fmt.Sprintf(" %s", o.TemplateArgs)
 	}
 
+	return flags
+}
+
+func (r *Runner) ReplaceWithRendered(name, chartName, chartPath string, o ReplaceWithRenderedOpts) ([]string, error) {
+	flagOpts := o
+	defaultValuesPath := filepath.Join(chartPath, "values.yaml")
+	exists, err := r.Exists(defaultValuesPath)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		flagOpts.ValuesFiles = append([]string{defaultValuesPath}, o.ValuesFiles...)
+	}
+	additionalFlags := templateFlags(flagOpts)
+
 	r.Logf("options: %v", o)
 
 	helmOutputDir := filepath.Join(chartPath, "helmx.1.rendered")
@@ -98,29 +105,10 @@ func (r *Runner) ReplaceWithRendered(name, chartName, chartPath string, o Replac
 		return nil, err
 	}
 
-	var command string
-
 	writtenFiles := map[string]bool{}
-	if r.IsHelm3() || r.IsHelm4() {
-		args := []string{
-			fmt.Sprintf("--debug=%v", o.Debug),
-			fmt.Sprintf("--output-dir=%s", helmOutputDir),
-		}
 
-		if o.IncludeCRDs {
-			args = append(args, "--include-crds")
-		}
-
-		if o.Validate {
-			args = append(args, "--validate")
-		}
-
-		args = append(args, name, chartPath)
-
-		command = fmt.Sprintf("%s template %s%s", r.helmBin(), strings.Join(args, " "), additionalFlags)
-	} else {
-		command = fmt.Sprintf("%s template --debug=%v %s --name %s%s --output-dir %s", r.helmBin(), o.Debug, chartPath, name, additionalFlags, helmOutputDir)
-	}
+	templateArgs := r.HelmAdapter().TemplateArgs(name, chartPath, helmOutputDir, o)
+	command := fmt.Sprintf("%s %s%s", r.helmBin(), strings.Join(templateArgs, " "), additionalFlags)
 
 	stdout, err := r.run(nil, command)
 	if err != nil {
@@ -243,70 +231,8 @@ func (r *Runner) ReplaceWithRendered(name, chartName, chartPath string, o Replac
 	// Note that this is the fix for adhoc chart dependencies.
 	// The standard chart dependencies that are declared in the original Chart.yaml or requirements.yaml,
 	// should have been downloaded by `helm fetch` that run in an even earlier phase of chartify.
-	if r.IsHelm3() || r.IsHelm4() {
-		type ChartMeta struct {
-			Dependencies []Dependency           `yaml:"dependencies,omitempty"`
-			Data         map[string]interface{} `yaml:",inline"`
-		}
-		var chartMeta ChartMeta
-
-		chartYamlPath := filepath.Join(chartPath, "Chart.yaml")
-
-		bytes, err := r.ReadFile(chartYamlPath)
-		if os.IsNotExist(err) {
-
-		} else if err != nil {
-			return nil, err
-		} else {
-			if err := yaml.Unmarshal(bytes, &chartMeta); err != nil {
-				return nil, err
-			}
-		}
-
-		chartMeta.Dependencies = nil
-
-		chartYamlContent, err := yaml.Marshal(&chartMeta)
-		if err != nil {
-			return nil, fmt.Errorf("marshaling-back %s's Chart.yaml: %w", chartName, err)
-		}
-
-		r.Logf("Removing the dependencies field from the original Chart.yaml.")
-
-		if err := r.WriteFile(chartYamlPath, chartYamlContent, 0644); err != nil {
-			return nil, err
-		}
-	} else {
-		var reqs Requirements
-
-		bytes, err := r.ReadFile(filepath.Join(chartPath, "requirements.yaml"))
-		if os.IsNotExist(err) {
-
-		} else if err != nil {
-			return nil, err
-		} else {
-			if err := yaml.Unmarshal(bytes, &reqs); err != nil {
-				return nil, err
-			}
-		}
-
-		reqs.Dependencies = nil
-
-		requirementsYamlContent, err := yaml.Marshal(&reqs)
-		if err != nil {
-			return nil, fmt.Errorf("marshaling %s's requirements as YAML: %w", chartName, err)
-		}
-
-		if err := r.WriteFile(filepath.Join(chartPath, "requirements.yaml"), requirementsYamlContent, 0644); err != nil {
-			return nil, err
-		}
-
-		{
-			debugOut, err := r.ReadFile(filepath.Join(chartPath, "requirements.yaml"))
-			if err != nil {
-				return nil, err
-			}
-			r.Logf("using requirements.yaml:\n%s", debugOut)
-		}
+	if err := r.HelmAdapter().ClearDependencies(r, chartName, chartPath); err != nil {
+		return nil, err
 	}
 
 	// We need to remove dangling Chart.lock and requirements.lock too, as the corresponding dependencies
@@ -1,7 +1,6 @@
 package chartify
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
 
@@ -43,10 +42,18 @@ type ChartDependency struct {
 func (r *Runner) UpdateRequirements(replace bool, chartYamlPath, tempDir string, deps []Dependency) ([]Dependency, error) {
 	// requirements.yaml can exist for both helm v2 or helm v3 chart
 	// so we try to load it regardless of the helm version
+	reqs, err := r.readRequirements(tempDir)
+	if err != nil {
+		return nil, err
+	}
 
+	return r.HelmAdapter().UpdateDependencies(r, replace, chartYamlPath, tempDir, *reqs, deps)
+}
+
+func (r *Runner) readRequirements(chartPath string) (*Requirements, error) {
 	var reqs Requirements
 
-	bytes, err := r.ReadFile(filepath.Join(tempDir, "requirements.yaml"))
+	bytes, err := r.ReadFile(filepath.Join(chartPath, "requirements.yaml"))
 	if os.IsNotExist(err) {
 
 	} else if err != nil {
@@ -57,91 +64,5 @@ func (r *Runner) UpdateRequirements(replace bool, chartYamlPath, tempDir string,
 		}
 	}
 
-	var all []Dependency
-
-	if r.IsHelm3() || r.IsHelm4() {
-		type ChartMeta struct {
-			Dependencies []Dependency           `yaml:"dependencies,omitempty"`
-			Data         map[string]interface{} `yaml:",inline"`
-		}
-		var chartMeta ChartMeta
-
-		bytes, err := r.ReadFile(chartYamlPath)
-		if os.IsNotExist(err) {
-
-		} else if err != nil {
-			return nil, err
-		} else {
-			if err := yaml.Unmarshal(bytes, &chartMeta); err != nil {
-				return nil, err
-			}
-		}
-
-		all = append(all, chartMeta.Dependencies...)
-		all = append(all, reqs.Dependencies...)
-		all = append(all, deps...)
-
-		if replace {
-			// When it's a remote chart, the helm-fetch preceded this chartification step
-			// should have been already downloaded all the dependencies into the charts/ directory.
-			//
-			// In that case, we need to remove the original Chart.yaml's `dependencies` to
-			// avoid failing due to unnecessarily trying to fetch chart dependencies.
-			//
-			// Note that this depends on how `helm package` used to package the chart served by the chart repo server works.
-			// We assume that `helm package` to enforce the package to contains `charts/*.tgz` for every dependency declared in Chart.yaml or requirements.yaml.
-			// If the package somehow misses the `charts/*.tgz` files even though it has one ore more dependencies in either Chart.yaml or requirements.yaml,
-			// this assumption breaks and chartify might not work well.
-			chartMeta.Dependencies = deps
-		} else {
-			chartMeta.Dependencies = all
-		}
-
-		chartYamlContent, err := yaml.Marshal(&chartMeta)
-		if err != nil {
-			return nil, fmt.Errorf("marshaling-back Chart.yaml: %w", err)
-		}
-
-		r.Logf("Removing the dependencies field from the original Chart.yaml.")
-
-		if err := r.WriteFile(filepath.Join(tempDir, "Chart.yaml"), chartYamlContent, 0644); err != nil {
-			return nil, err
-		}
-
-		// We already merged requirements.yaml into Chart.yaml's dependencies field
-		// so we don't need requirements anymore.
-		reqYaml := filepath.Join(tempDir, "requirements.yaml")
-		if _, err := os.Stat(reqYaml); err == nil {
-			r.Logf("Removing requirements.yaml as unneeded. charts/ should have already been populated by helm-fetch.")
-			if err := os.Remove(reqYaml); err != nil {
-				return nil, err
-			}
-		}
-	} else {
-		all = append(all, reqs.Dependencies...)
-		all = append(all, deps...)
-
-		if replace {
-			reqs.Dependencies = all
-		} else {
-			reqs.Dependencies = deps
-		}
-
-		requirementsYamlContent, err := yaml.Marshal(&reqs)
-		if err != nil {
-			return nil, fmt.Errorf("marshaling requirements as YAML: %w", err)
-		}
-
-		if err := r.WriteFile(filepath.Join(tempDir, "requirements.yaml"), requirementsYamlContent, 0644); err != nil {
-			return nil, err
-		}
-
-		debugOut, err := r.ReadFile(filepath.Join(tempDir, "requirements.yaml"))
-		if err != nil {
-			return nil, err
-		}
-		r.Logf("using requirements.yaml:\n%s", debugOut)
-	}
-
-	return all, nil
+	return &reqs, nil
 }
@@ -0,0 +1,241 @@
+package chartify
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Resource is a single document read from a YAML stream of K8s manifests.
+type Resource struct {
+	// Raw is the content of the document as it appeared in the stream, with LF line endings and
+	// without the surrounding document markers.
+	// It always ends with a newline.
+	Raw []byte
+
+	// Node is the parsed document.
+	Node *yaml.Node
+}
+
+// Kind returns the kind of the K8s resource, or an empty string if the document has no kind.
+func (r *Resource) Kind() string {
+	if v := mappingValue(documentRoot(r.Node), "kind"); v != nil {
+		return v.Value
+	}
+	return ""
+}
+
+// GroupVersion returns the API group and version of the K8s resource.
+// The group is empty for resources in the core group, like v1 ConfigMaps.
+func (r *Resource) GroupVersion() (string, string) {
+	v := mappingValue(documentRoot(r.Node), "apiVersion")
+	if v == nil {
+		return "", ""
+	}
+
+	if group, version, ok := strings.Cut(v.Value, "/"); ok {
+		return group, version
+	}
+
+	return "", v.Value
+}
+
+// Name returns metadata.name of the K8s resource.
+func (r *Resource) Name() string {
+	if v := mappingValue(mappingValue(documentRoot(r.Node), "metadata"), "name"); v != nil {
+		return v.Value
+	}
+	return ""
+}
+
+// Namespace returns metadata.namespace of the K8s resource, or an empty string if it has none.
+func (r *Resource) Namespace() string {
+	if v := mappingValue(mappingValue(documentRoot(r.Node), "metadata"), "namespace"); v != nil {
+		return v.Value
+	}
+	return ""
+}
+
+// ReadResources splits the YAML stream into documents and parses each of them.
+//
+// Unlike a naive split on "\n---\n", it recognizes `---` document start markers followed by comments or content,
+// `...` document end markers, directives, and CRLF line endings.
+// Document markers are only recognized at the beginning of a line as the YAML spec requires,
+// so that `---` within an indented block scalar is kept as part of the content.
+// Documents that contain nothing but comments, whitespaces or an explicit null are skipped.
+func ReadResources(r io.Reader) ([]*Resource, error) {
+	scanner := bufio.NewScanner(r)
+	// Lines in manifests, like ones containing embedded certificates or scripts, can be very long.
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+
+	var (
+		resources []*Resource
+		buf       bytes.Buffer
+		// inDocument is true after we've seen either a document start marker or any content in the current document.
+		// Directives are only allowed before it.
+		inDocument bool
+		docIndex   int
+	)
+
+	flush := func() error {
+		defer func() {
+			buf.Reset()
+			inDocument = false
+			docIndex++
+		}()
+
+		if strings.TrimSpace(buf.String()) == "" {
+			return nil
+		}
+
+		var node yaml.Node
+		if err := yaml.Unmarshal(buf.Bytes(), &node); err != nil {
+			return fmt.Errorf("parsing yaml document %d: %w", docIndex, err)
+		}
+
+		if len(node.Content) == 0 || documentRoot(&node).Tag == "!!null" {
+			return nil
+		}
+
+		raw := append([]byte{}, buf.Bytes()...)
+
+		resources = append(resources, &Resource{Raw: raw, Node: &node})
+
+		return nil
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+
+		if rest, ok := documentMarker(line, "---"); ok {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+
+			inDocument = true
+
+			if rest != "" && !strings.HasPrefix(rest, "#") {
+				// Content on the same line as the marker, like `--- !tag` or `--- |`
+				buf.WriteString(rest)
+				buf.WriteString("\n")
+			}
+
+			continue
+		}
+
+		if _, ok := documentMarker(line, "..."); ok {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if !inDocument && strings.HasPrefix(line, "%") {
+			// Directives like `%YAML 1.2` only apply to the original stream
+			continue
+		}
+
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			inDocument = true
+		}
+
+		buf.WriteString(line)
+		buf.WriteString("\n")
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	return resources, nil
+}
+
+// ReadResourcesFromFile reads all the resources contained in the YAML file.
+func ReadResourcesFromFile(path string) ([]*Resource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	resources, err := ReadResources(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading resources from %s: %w", path, err)
+	}
+
+	return resources, nil
+}
+
+// WriteResources writes the raw content of the resources as a YAML stream, separated by `---`.
+func WriteResources(w io.Writer, resources []*Resource) error {
+	for i, res := range resources {
+		if i > 0 {
+			if _, err := io.WriteString(w, "---\n"); err != nil {
+				return err
+			}
+		}
+
+		if _, err := w.Write(res.Raw); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// documentMarker reports whether the line is the given document marker, either `---` or `...`,
+// and returns the rest of the line after the marker.
+func documentMarker(line, marker string) (string, bool) {
+	if !strings.HasPrefix(line, marker) {
+		return "", false
+	}
+
+	rest := line[len(marker):]
+	if rest == "" {
+		return "", true
+	}
+
+	// `---foo` is a plain scalar, not a marker
+	if rest[0] != ' ' && rest[0] != '\t' {
+		return "", false
+	}
+
+	return strings.TrimSpace(rest), true
+}
+
+// normalizeManifestFile rewrites the YAML file so that it contains only the non-empty documents
+// separated by plain `---` lines with LF line endings, keeping the content of each document as-is.
+func (r *Runner) normalizeManifestFile(path string) error {
+	content, err := r.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	resources, err := ReadResources(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("reading resources from %s: %w", path, err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteResources(&buf, resources); err != nil {
+		return err
+	}
+
+	if bytes.Equal(buf.Bytes(), content) {
+		return nil
+	}
+
+	return r.WriteFile(path, buf.Bytes(), 0644)
+}
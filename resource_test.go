@@ -0,0 +1,217 @@
+package chartify
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadResources(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		kinds   []string
+		wantErr string
+	}{
+		{
+			name:  "single document without markers",
+			input: "kind: ConfigMap\nmetadata:\n  name: a\n",
+			want:  []string{"kind: ConfigMap\nmetadata:\n  name: a\n"},
+			kinds: []string{"ConfigMap"},
+		},
+		{
+			name:  "single document without trailing newline",
+			input: "kind: ConfigMap\nmetadata:\n  name: a",
+			want:  []string{"kind: ConfigMap\nmetadata:\n  name: a\n"},
+		},
+		{
+			name:  "leading and trailing separators",
+			input: "---\nkind: A\n---\nkind: B\n---\n",
+			want:  []string{"kind: A\n", "kind: B\n"},
+			kinds: []string{"A", "B"},
+		},
+		{
+			name:  "separator followed by a comment",
+			input: "kind: A\n--- # Source: chart/templates/b.yaml\nkind: B\n",
+			want:  []string{"kind: A\n", "kind: B\n"},
+		},
+		{
+			name:  "separator followed by trailing whitespaces",
+			input: "kind: A\n---   \nkind: B\n",
+			want:  []string{"kind: A\n", "kind: B\n"},
+		},
+		{
+			name:  "CRLF line endings",
+			input: "kind: A\r\nmetadata:\r\n  name: a\r\n---\r\nkind: B\r\n",
+			want:  []string{"kind: A\nmetadata:\n  name: a\n", "kind: B\n"},
+		},
+		{
+			name:  "document end markers",
+			input: "kind: A\n...\n---\nkind: B\n...\n",
+			want:  []string{"kind: A\n", "kind: B\n"},
+		},
+		{
+			name:  "document end marker without following start marker",
+			input: "kind: A\n...\nkind: B\n",
+			want:  []string{"kind: A\n", "kind: B\n"},
+		},
+		{
+			name:  "separator inside a block scalar",
+			input: "kind: ConfigMap\ndata:\n  script: |\n    echo a\n    ---\n    echo b\n---\nkind: B\n",
+			want:  []string{"kind: ConfigMap\ndata:\n  script: |\n    echo a\n    ---\n    echo b\n", "kind: B\n"},
+			kinds: []string{"ConfigMap", "B"},
+		},
+		{
+			name:  "marker-like plain scalars",
+			input: "kind: A\ndata:\n  x: |\n    ---foo\n---foo: bar\n",
+			want:  []string{"kind: A\ndata:\n  x: |\n    ---foo\n---foo: bar\n"},
+		},
+		{
+			name:  "empty, comment-only and null documents are skipped",
+			input: "---\n---\n# just a comment\n---\nnull\n---\n~\n---\n\n   \n---\nkind: A\n",
+			want:  []string{"kind: A\n"},
+		},
+		{
+			name:  "comments before content are kept",
+			input: "---\n# Source: chart/templates/a.yaml\nkind: A\n",
+			want:  []string{"# Source: chart/templates/a.yaml\nkind: A\n"},
+		},
+		{
+			name:  "directives",
+			input: "%YAML 1.2\n---\nkind: A\n",
+			want:  []string{"kind: A\n"},
+		},
+		{
+			name:  "content on the marker line",
+			input: "--- {kind: A}\n--- !!map\nkind: B\n",
+			want:  []string{"{kind: A}\n", "!!map\nkind: B\n"},
+			kinds: []string{"A", "B"},
+		},
+		{
+			name:  "empty input",
+			input: "",
+		},
+		{
+			name:    "invalid document",
+			input:   "kind: A\n---\nkind: [B\n",
+			wantErr: "parsing yaml document 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, err := ReadResources(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var got []string
+			var kinds []string
+			for _, res := range resources {
+				got = append(got, string(res.Raw))
+				kinds = append(kinds, res.Kind())
+			}
+			require.Equal(t, tt.want, got)
+
+			if tt.kinds != nil {
+				require.Equal(t, tt.kinds, kinds)
+			}
+		})
+	}
+}
+
+func TestReadResources_LongLines(t *testing.T) {
+	long := strings.Repeat("x", 1024*1024)
+
+	resources, err := ReadResources(strings.NewReader("kind: Secret\ndata:\n  cert: " + long + "\n---\nkind: B\n"))
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+	require.Equal(t, "Secret", resources[0].Kind())
+}
+
+func TestWriteResources(t *testing.T) {
+	resources, err := ReadResources(strings.NewReader("--- # a\r\nkind: A\r\n...\r\n---\r\nkind: B\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteResources(&buf, resources))
+	require.Equal(t, "kind: A\n---\nkind: B\n", buf.String())
+}
+
+func TestSetNamespace(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "templates", "all.yaml")
+	require.NoError(t, os.MkdirAll(filepath.Dir(f), 0755))
+	require.NoError(t, os.WriteFile(f, []byte("--- # Source: a.yaml\r\n"+
+		"kind: ConfigMap\r\nmetadata:\r\n  name: a\r\n"+
+		"...\r\n---\r\n---\r\n"+
+		"kind: ConfigMap\r\nmetadata:\r\n  name: b\r\n  namespace: other\r\n"+
+		"---\r\n"+
+		"kind: ConfigMap\r\n"), 0644))
+
+	r := New(WithLogf(t.Logf))
+	require.NoError(t, r.SetNamespace(dir, "myns"))
+
+	got, err := os.ReadFile(f)
+	require.NoError(t, err)
+	require.Equal(t, `kind: ConfigMap
+metadata:
+  name: a
+  namespace: myns
+---
+kind: ConfigMap
+metadata:
+  name: b
+  namespace: other
+---
+kind: ConfigMap
+`, string(got))
+}
+
+func TestChartify_PathologicalManifests(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	manifestsDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(manifestsDir, "all.yaml"), []byte("--- # first\r\n"+
+		"apiVersion: v1\r\nkind: ConfigMap\r\nmetadata:\r\n  name: script\r\ndata:\r\n  run.sh: |\r\n    echo a\r\n    ---\r\n    echo b\r\n"+
+		"...\r\n"+
+		"---\r\n"+
+		"# nothing here\r\n"+
+		"---\r\n"+
+		"apiVersion: v1\r\nkind: ConfigMap\r\nmetadata:\r\n  name: patched\r\ndata:\r\n  patched: \"false\"\r\n"), 0644))
+
+	patch := filepath.Join(t.TempDir(), "patch.yaml")
+	require.NoError(t, os.WriteFile(patch, []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: patched\ndata:\n  patched: \"true\"\n"), 0644))
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", manifestsDir, WithChartifyOpts(&ChartifyOpts{
+		StrategicMergePatches: []string{patch},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	patched, err := os.ReadFile(filepath.Join(tmpDir, "files", "templates", "patched_resources.yaml"))
+	require.NoError(t, err)
+
+	resources, err := ReadResources(bytes.NewReader(patched))
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+
+	got := string(patched)
+	require.Contains(t, got, "patched: \"true\"")
+	require.Contains(t, got, "echo a\n    ---\n    echo b")
+	require.NotContains(t, got, "\r")
+}
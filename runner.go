@@ -21,9 +21,28 @@ type Runner struct {
 	// KustomizeBinary is the name or the path to `kustomize` command
 	KustomizeBinary string
 
+	// GitBinary is the name or the path to `git` command, used to fetch git sources
+	GitBinary string
+
+	// JsonnetBinary is the name or the path to `jsonnet` command, used to evaluate Jsonnet inputs
+	JsonnetBinary string
+
+	// CueBinary is the name or the path to `cue` command, used to export CUE packages
+	CueBinary string
+
+	// InProcessChartFetch makes chartify fetch charts in classic chart repositories, referenced like `REPO/CHART`,
+	// with ChartFetcher instead of `helm pull`.
+	// It skips RunCommand and the helm binary for those charts, and always uses the Helm v4 repository client.
+	InProcessChartFetch bool
+
 	isHelm3 bool
 	isHelm4 bool
 
+	helmAdapter HelmVersionAdapter
+
+	// inputDetectors are the detectors added via WithInputDetectors
+	inputDetectors []InputDetector
+
 	RunCommand RunCommandFunc
 
 	CopyFile    func(src, dst string) error
@@ -61,6 +80,14 @@ func UseHelm4(u bool) Option {
 	}
 }
 
+// UseInProcessChartFetch sets Runner.InProcessChartFetch.
+func UseInProcessChartFetch(u bool) Option {
+	return func(r *Runner) error {
+		r.InProcessChartFetch = u
+		return nil
+	}
+}
+
 func WithLogf(logf func(string, ...interface{})) Option {
 	return func(r *Runner) error {
 		r.Logf = logf
@@ -75,6 +102,27 @@ func KustomizeBin(b string) Option {
 	}
 }
 
+func GitBin(b string) Option {
+	return func(r *Runner) error {
+		r.GitBinary = b
+		return nil
+	}
+}
+
+func JsonnetBin(b string) Option {
+	return func(r *Runner) error {
+		r.JsonnetBinary = b
+		return nil
+	}
+}
+
+func CueBin(b string) Option {
+	return func(r *Runner) error {
+		r.CueBinary = b
+		return nil
+	}
+}
+
 func New(opts ...Option) *Runner {
 	r := &Runner{
 		RunCommand:  RunCommand,
@@ -120,6 +168,36 @@ func (r *Runner) kustomizeBin() string {
 	return "kustomize"
 }
 
+func (r *Runner) gitBin() string {
+	if r.GitBinary != "" {
+		return r.GitBinary
+	}
+	if env := os.Getenv("GIT_BIN"); env != "" {
+		return env
+	}
+	return "git"
+}
+
+func (r *Runner) jsonnetBin() string {
+	if r.JsonnetBinary != "" {
+		return r.JsonnetBinary
+	}
+	if env := os.Getenv("JSONNET_BIN"); env != "" {
+		return env
+	}
+	return "jsonnet"
+}
+
+func (r *Runner) cueBin() string {
+	if r.CueBinary != "" {
+		return r.CueBinary
+	}
+	if env := os.Getenv("CUE_BIN"); env != "" {
+		return env
+	}
+	return "cue"
+}
+
 func (r *Runner) run(envs map[string]string, cmd string, args ...string) (string, error) {
 	bytes, err := r.runBytes(envs, "", cmd, args...)
 
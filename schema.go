@@ -0,0 +1,241 @@
+package chartify
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ValuesSchemaFileName is the name of the JSON Schema file Helm validates the values of a chart against.
+	// See https://helm.sh/docs/topics/charts/#schema-files
+	ValuesSchemaFileName = "values.schema.json"
+
+	valuesSchemaDraft = "https://json-schema.org/draft-07/schema#"
+)
+
+// writeValuesSchema makes the chart at chartDir have values.schema.json so that Helm validates the values
+// on installing the chart.
+//
+// When the chart has no schema and generate is true, which is the case for charts chartify generated from
+// K8s manifests or kustomizations, it writes a schema describing the values the chart uses, along with
+// an empty values.yaml if missing. The schema rejects unknown values only when strict is true.
+// When the chart already has a schema carried over from the source chart, it adds the values chartify added
+// to the chart, so that they don't fail the validation.
+// It does nothing for charts without schemas when generate is false, as the values of the source chart are unknown.
+//
+// deps are the adhoc dependencies of the chart, whose `enabled` values are accepted.
+// exposed are the values chartify added to the chart, whose defaults are read from values.yaml to determine their types.
+// givenValues are the keys of the values given to chartify, which are accepted by the strict schema as well,
+// because tools like Helmfile pass the same values to both chartify and `helm upgrade` on the generated chart.
+func (r *Runner) writeValuesSchema(chartDir string, generate, strict bool, deps []Dependency, exposed []ExposedValue, givenValues []string) error {
+	schemaPath := filepath.Join(chartDir, ValuesSchemaFileName)
+	valuesPath := filepath.Join(chartDir, "values.yaml")
+
+	var schema map[string]interface{}
+
+	if exists, err := r.Exists(schemaPath); err != nil {
+		return err
+	} else if exists {
+		content, err := r.ReadFile(schemaPath)
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(content, &schema); err != nil {
+			return fmt.Errorf("parsing %s: %w", schemaPath, err)
+		}
+
+		if len(deps) == 0 && len(exposed) == 0 {
+			return nil
+		}
+	} else if generate {
+		schema = map[string]interface{}{
+			"$schema": valuesSchemaDraft,
+			"type":    "object",
+			"properties": map[string]interface{}{
+				// Global values are shared across charts, and commonly given to every release regardless of the chart
+				"global": map[string]interface{}{"type": "object"},
+			},
+		}
+
+		if strict {
+			schema["additionalProperties"] = false
+
+			for _, k := range givenValues {
+				addSchemaProperty(schema, []string{k}, map[string]interface{}{})
+			}
+		}
+	} else {
+		return nil
+	}
+
+	for _, d := range deps {
+		name := d.Alias
+		if name == "" {
+			name = d.Name
+		}
+
+		addSchemaProperty(schema, []string{name}, map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"enabled": map[string]interface{}{"type": "boolean"},
+			},
+		})
+	}
+
+	if len(exposed) > 0 {
+		var values yaml.Node
+
+		content, err := r.ReadFile(valuesPath)
+		if err != nil {
+			return err
+		}
+
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return fmt.Errorf("parsing %s: %w", valuesPath, err)
+		}
+
+		for _, e := range exposed {
+			key, err := parseValuesKey(e.Key)
+			if err != nil {
+				return err
+			}
+
+			property := map[string]interface{}{}
+			if def := lookupField(&values, key); def != nil {
+				if t := jsonSchemaType(def); t != "" {
+					property["type"] = t
+				}
+			}
+
+			addSchemaProperty(schema, key, property)
+		}
+	}
+
+	content, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling %s: %w", schemaPath, err)
+	}
+
+	if err := r.WriteFile(schemaPath, append(content, '\n'), 0644); err != nil {
+		return err
+	}
+
+	if exists, err := r.Exists(valuesPath); err != nil {
+		return err
+	} else if !exists {
+		if err := r.WriteFile(valuesPath, nil, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addSchemaProperty adds the property at the key path to the object schema, creating the intermediate object schemas.
+// Properties that are already defined in the schema are kept as-is, as the source chart knows better.
+func addSchemaProperty(schema map[string]interface{}, key []string, property map[string]interface{}) {
+	for i, k := range key {
+		properties, ok := schema["properties"].(map[string]interface{})
+		if !ok {
+			if schema["properties"] != nil {
+				// Not a schema chartify understands
+				return
+			}
+
+			properties = map[string]interface{}{}
+			schema["properties"] = properties
+		}
+
+		child, ok := properties[k].(map[string]interface{})
+		if !ok {
+			if properties[k] != nil {
+				return
+			}
+
+			if i == len(key)-1 {
+				properties[k] = property
+				return
+			}
+
+			child = map[string]interface{}{"type": "object"}
+			properties[k] = child
+		}
+
+		schema = child
+	}
+}
+
+// jsonSchemaType returns the JSON Schema type of the YAML value, or an empty string if it accepts any type.
+func jsonSchemaType(n *yaml.Node) string {
+	switch n.Kind {
+	case yaml.MappingNode:
+		return "object"
+	case yaml.SequenceNode:
+		return "array"
+	case yaml.ScalarNode:
+		switch n.Tag {
+		case "!!str":
+			return "string"
+		case "!!int":
+			return "integer"
+		case "!!float":
+			return "number"
+		case "!!bool":
+			return "boolean"
+		}
+	}
+	return ""
+}
+
+// givenValuesKeys returns the top-level keys of the values given to chartify via values files and set flags.
+func (r *Runner) givenValuesKeys(u *ChartifyOpts) ([]string, error) {
+	var keys []string
+
+	for _, f := range u.ValuesFiles {
+		content, err := r.readValuesFile(f)
+		if err != nil {
+			return nil, err
+		}
+
+		var values map[string]interface{}
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", f, err)
+		}
+
+		for k := range values {
+			keys = append(keys, k)
+		}
+	}
+
+	var assignments []string
+
+	for _, f := range append(setValuesFlags(u.SetValues), parseSetFlags(u.SetFlags)...) {
+		if strings.HasPrefix(f.flag, "--set") {
+			assignments = append(assignments, f.value)
+		}
+	}
+
+	for _, a := range assignments {
+		for _, kv := range strings.Split(a, ",") {
+			k, _, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok {
+				continue
+			}
+
+			if i := strings.IndexAny(k, ".["); i >= 0 {
+				k = k[:i]
+			}
+
+			if k != "" {
+				keys = append(keys, k)
+			}
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,266 @@
+package chartify
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner_writeValuesSchema(t *testing.T) {
+	readSchema := func(t *testing.T, chartDir string) map[string]interface{} {
+		t.Helper()
+
+		content, err := os.ReadFile(filepath.Join(chartDir, ValuesSchemaFileName))
+		require.NoError(t, err)
+
+		var schema map[string]interface{}
+		require.NoError(t, json.Unmarshal(content, &schema))
+
+		return schema
+	}
+
+	r := New(WithLogf(t.Logf))
+
+	t.Run("strict", func(t *testing.T) {
+		chartDir := t.TempDir()
+		writeTestFiles(t, chartDir, map[string]string{
+			"values.yaml": "db:\n  replicas: 2\n  image: nginx:1.0\n",
+		})
+
+		require.NoError(t, r.writeValuesSchema(chartDir, true, true,
+			[]Dependency{{Name: "log", Alias: "mylog"}},
+			[]ExposedValue{{Path: "spec.replicas", Key: "db.replicas"}, {Path: "spec.template.spec.containers[0].image", Key: "db.image"}},
+			[]string{"foo"},
+		))
+
+		require.Equal(t, map[string]interface{}{
+			"$schema":              "https://json-schema.org/draft-07/schema#",
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties": map[string]interface{}{
+				"global": map[string]interface{}{"type": "object"},
+				"foo":    map[string]interface{}{},
+				"mylog": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"enabled": map[string]interface{}{"type": "boolean"},
+					},
+				},
+				"db": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"replicas": map[string]interface{}{"type": "integer"},
+						"image":    map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		}, readSchema(t, chartDir))
+	})
+
+	t.Run("generated", func(t *testing.T) {
+		chartDir := t.TempDir()
+
+		require.NoError(t, r.writeValuesSchema(chartDir, true, false, []Dependency{{Name: "log"}}, nil, []string{"foo"}))
+
+		require.Equal(t, map[string]interface{}{
+			"$schema": "https://json-schema.org/draft-07/schema#",
+			"type":    "object",
+			"properties": map[string]interface{}{
+				"global": map[string]interface{}{"type": "object"},
+				"log": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"enabled": map[string]interface{}{"type": "boolean"},
+					},
+				},
+			},
+		}, readSchema(t, chartDir))
+	})
+
+	t.Run("strict without values", func(t *testing.T) {
+		chartDir := t.TempDir()
+
+		require.NoError(t, r.writeValuesSchema(chartDir, true, true, nil, nil, nil))
+
+		require.FileExists(t, filepath.Join(chartDir, "values.yaml"))
+		require.Equal(t, false, readSchema(t, chartDir)["additionalProperties"])
+	})
+
+	t.Run("carried over", func(t *testing.T) {
+		chartDir := t.TempDir()
+		writeTestFiles(t, chartDir, map[string]string{
+			"values.yaml":        "replicaCount: 1\n",
+			ValuesSchemaFileName: `{"type": "object", "additionalProperties": false, "properties": {"replicaCount": {"type": "integer", "minimum": 1}}}`,
+		})
+
+		require.NoError(t, r.writeValuesSchema(chartDir, false, false,
+			[]Dependency{{Name: "log"}},
+			[]ExposedValue{{Path: "spec.replicas", Key: "replicaCount"}},
+			[]string{"ignored"},
+		))
+
+		require.Equal(t, map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties": map[string]interface{}{
+				"replicaCount": map[string]interface{}{"type": "integer", "minimum": float64(1)},
+				"log": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"enabled": map[string]interface{}{"type": "boolean"},
+					},
+				},
+			},
+		}, readSchema(t, chartDir))
+	})
+
+	t.Run("no schema", func(t *testing.T) {
+		chartDir := t.TempDir()
+
+		require.NoError(t, r.writeValuesSchema(chartDir, false, false, []Dependency{{Name: "log"}}, nil, nil))
+
+		require.NoFileExists(t, filepath.Join(chartDir, ValuesSchemaFileName))
+		require.NoFileExists(t, filepath.Join(chartDir, "values.yaml"))
+	})
+}
+
+func TestRunner_givenValuesKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"values.yaml": "fromFile:\n  nested: true\n",
+	})
+
+	keys, err := New().givenValuesKeys(&ChartifyOpts{
+		ValuesFiles: []string{filepath.Join(dir, "values.yaml")},
+		SetValues:   []string{"deprecated=1"},
+		SetFlags: []string{
+			"--set", "log.enabled=true,list[0]=a",
+			"--set-string single=x",
+			"--set-json=json={\"a\":1,\"b\":2}",
+			"--namespace", "notavalue=1",
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"fromFile", "deprecated", "log", "list", "single", "json"}, keys)
+}
+
+func TestChartify_ValuesSchema(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", "testdata/kube_manifest", WithChartifyOpts(&ChartifyOpts{
+		SetFlags: []string{"--set", "known=1"},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	require.FileExists(t, filepath.Join(tmpDir, "values.yaml"))
+	require.FileExists(t, filepath.Join(tmpDir, ValuesSchemaFileName))
+
+	// Values other than the ones given to chartify are rejected by default
+	out, err := exec.Command(helm, "template", "myapp", tmpDir, "--set", "known=2").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	out, err = exec.Command(helm, "template", "myapp", tmpDir, "--set", "knwon=2").CombinedOutput()
+	require.Error(t, err)
+	require.Contains(t, string(out), "knwon")
+
+	permissiveDir, err := r.Chartify("myapp", "testdata/kube_manifest", WithChartifyOpts(&ChartifyOpts{
+		SetFlags:               []string{"--set", "known=1"},
+		PermissiveValuesSchema: true,
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(permissiveDir)
+	})
+	require.NoError(t, err)
+
+	out, err = exec.Command(helm, "template", "myapp", permissiveDir, "--set", "other=2").CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+func TestChartify_ValuesSchema_StdinValues(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	setStdin(t, "known: 1\n")
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	// Like `chartify -f - -o out myapp testdata/kube_manifest`
+	tmpDir, err := r.Chartify("myapp", "testdata/kube_manifest", WithChartifyOpts(&ChartifyOpts{
+		ValuesFiles: []string{"-"},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	schema, err := os.ReadFile(filepath.Join(tmpDir, ValuesSchemaFileName))
+	require.NoError(t, err)
+	require.Contains(t, string(schema), `"known"`)
+}
+
+// setStdin makes the content readable from os.Stdin until the end of the test.
+func setStdin(t *testing.T, content string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "stdin")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+
+	orig := os.Stdin
+	os.Stdin = f
+	t.Cleanup(func() {
+		os.Stdin = orig
+		_ = f.Close()
+	})
+}
+
+func TestChartify_ValuesSchema_CarriedOver(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	chartDir := filepath.Join(t.TempDir(), "strict")
+	writeTestFiles(t, chartDir, map[string]string{
+		"Chart.yaml":         "apiVersion: v2\nname: strict\nversion: 0.1.0\n",
+		"values.yaml":        "name: foo\n",
+		ValuesSchemaFileName: `{"type": "object", "additionalProperties": false, "properties": {"name": {"type": "string"}}}`,
+		"templates/cm.yaml":  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Values.name }}\n",
+	})
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	tmpDir, err := r.Chartify("myapp", chartDir, WithChartifyOpts(&ChartifyOpts{
+		OverrideNamespace: "ns",
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	out, err := exec.Command(helm, "template", "myapp", tmpDir, "--set", "name=bar").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	out, err = exec.Command(helm, "template", "myapp", tmpDir, "--set", "nmae=bar").CombinedOutput()
+	require.Error(t, err)
+	require.Contains(t, string(out), "nmae")
+}
@@ -0,0 +1,236 @@
+package chartify
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"helm.sh/helm/v4/pkg/cli"
+	"helm.sh/helm/v4/pkg/getter"
+)
+
+// gitSourcePrefix is the prefix of git sources, like `git::https://github.com/org/repo//path/to/dir?ref=v1.2.3`.
+const gitSourcePrefix = "git::"
+
+// isRemoteSource returns true when the chart or the directory given to chartify is a remote source
+// that chartify fetches by itself before chartifying it, which is either:
+//
+//   - A git repository in the go-getter-like form of `git::URL[//SUBDIR][?ref=REF]`
+//   - An http(s) URL to a chart archive ending with `.tgz` or `.tar.gz`
+//   - An http(s) URL to a K8s manifest file ending with `.yaml` or `.yml`
+//
+// Other URLs, like OCI chart references, are left to `helm pull`.
+func isRemoteSource(src string) bool {
+	if strings.HasPrefix(src, gitSourcePrefix) {
+		return true
+	}
+
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	switch remoteFileKind(u) {
+	case remoteChartArchive, remoteManifest:
+		return true
+	}
+
+	return false
+}
+
+const (
+	remoteChartArchive = "chart archive"
+	remoteManifest     = "manifest"
+)
+
+func remoteFileKind(u *url.URL) string {
+	switch p := strings.ToLower(u.Path); {
+	case strings.HasSuffix(p, ".tgz"), strings.HasSuffix(p, ".tar.gz"):
+		return remoteChartArchive
+	case strings.HasSuffix(p, ".yaml"), strings.HasSuffix(p, ".yml"):
+		return remoteManifest
+	}
+	return ""
+}
+
+// fetchRemoteSource fetches the remote source into dir, and returns the local path to be chartified instead,
+// which is either a chart archive or a directory containing a chart, a kustomization, or K8s manifests.
+func (r *Runner) fetchRemoteSource(src, dir string, u *ChartifyOpts) (string, error) {
+	if strings.HasPrefix(src, gitSourcePrefix) {
+		return r.fetchGitSource(src, dir)
+	}
+
+	parsed, err := url.Parse(src)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s: %w", src, err)
+	}
+
+	name := path.Base(parsed.Path)
+
+	switch remoteFileKind(parsed) {
+	case remoteChartArchive:
+		// The rest of chartify recognizes chart archives by the .tgz extension
+		archive := filepath.Join(dir, strings.TrimSuffix(strings.TrimSuffix(name, ".tgz"), ".tar.gz")+".tgz")
+
+		if err := r.download(src, archive); err != nil {
+			return "", err
+		}
+
+		if u.Verify {
+			if err := r.download(src+".prov", archive+".prov"); err != nil {
+				return "", fmt.Errorf("downloading provenance file of %s: %w", src, err)
+			}
+		}
+
+		return archive, nil
+	case remoteManifest:
+		manifestsDir := filepath.Join(dir, "manifests")
+
+		if err := os.MkdirAll(manifestsDir, 0755); err != nil {
+			return "", err
+		}
+
+		if err := r.download(src, filepath.Join(manifestsDir, name)); err != nil {
+			return "", err
+		}
+
+		return manifestsDir, nil
+	}
+
+	return "", fmt.Errorf("unsupported remote source %s: it must be a URL to a .tgz chart archive or a .yaml manifest, or a git:: source", src)
+}
+
+// download downloads the file at the URL to the path, honoring helm's environment variables for proxies and TLS.
+func (r *Runner) download(u, path string) error {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+
+	g, err := getter.All(cli.New()).ByScheme(parsed.Scheme)
+	if err != nil {
+		return err
+	}
+
+	r.Logf("Downloading %s", u)
+
+	buf, err := g.Get(u)
+	if err != nil {
+		return fmt.Errorf("downloading %s: %w", u, err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	if _, err := io.Copy(f, buf); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+
+	return f.Close()
+}
+
+// gitSource is a parsed git source in the form of `git::URL[//SUBDIR][?ref=REF]`.
+type gitSource struct {
+	// Repo is the URL of the git repository, passed as-is to `git clone`
+	Repo string
+
+	// Subdir is the path to the directory within the repository to be chartified
+	Subdir string
+
+	// Ref is the branch, tag, or commit to check out. The default branch is used when empty.
+	Ref string
+}
+
+// parseGitSource parses the git source like `git::https://github.com/org/repo//path/to/dir?ref=v1.2.3`.
+// Like go-getter, the double slash after the repository URL separates the subdirectory.
+func parseGitSource(src string) (*gitSource, error) {
+	s, ok := strings.CutPrefix(src, gitSourcePrefix)
+	if !ok || s == "" {
+		return nil, fmt.Errorf("invalid git source %q: it must be in the form of git::URL[//SUBDIR][?ref=REF]", src)
+	}
+
+	var g gitSource
+
+	s, rawQuery, _ := strings.Cut(s, "?")
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid git source %q: %w", src, err)
+	}
+
+	g.Ref = query.Get("ref")
+	query.Del("ref")
+
+	// Skip the `//` of the scheme, like `https://`
+	var offset int
+	if i := strings.Index(s, "://"); i >= 0 {
+		offset = i + len("://")
+	}
+
+	if i := strings.Index(s[offset:], "//"); i >= 0 {
+		g.Subdir = s[offset+i+2:]
+		s = s[:offset+i]
+	}
+
+	if len(query) > 0 {
+		s += "?" + query.Encode()
+	}
+
+	g.Repo = s
+
+	if strings.HasPrefix(g.Ref, "-") {
+		return nil, fmt.Errorf("invalid git source %q: invalid ref %q", src, g.Ref)
+	}
+
+	for _, segment := range strings.Split(g.Subdir, "/") {
+		if segment == ".." {
+			return nil, fmt.Errorf("invalid git source %q: the subdirectory %q must be a path within the repository", src, g.Subdir)
+		}
+	}
+
+	return &g, nil
+}
+
+// fetchGitSource clones the git repository into dir and returns the path to the subdirectory.
+func (r *Runner) fetchGitSource(src, dir string) (string, error) {
+	g, err := parseGitSource(src)
+	if err != nil {
+		return "", err
+	}
+
+	repoDir := filepath.Join(dir, "repo")
+
+	r.Logf("Cloning %s", g.Repo)
+
+	if _, err := r.run(nil, r.gitBin(), "clone", "--quiet", "--", g.Repo, repoDir); err != nil {
+		return "", err
+	}
+
+	if g.Ref != "" {
+		if _, err := r.runInDir(repoDir, r.gitBin(), "checkout", "--quiet", g.Ref, "--"); err != nil {
+			return "", err
+		}
+	}
+
+	// Otherwise .git would end up in the generated chart when the whole repository is chartified
+	if err := os.RemoveAll(filepath.Join(repoDir, ".git")); err != nil {
+		return "", err
+	}
+
+	p := filepath.Join(repoDir, filepath.FromSlash(g.Subdir))
+
+	if !r.dirExists(p) {
+		return "", fmt.Errorf("%s: no directory %q found in the repository", src, g.Subdir)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,168 @@
+package chartify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRemoteSource(t *testing.T) {
+	for src, want := range map[string]bool{
+		"git::https://github.com/org/repo//deploy?ref=v1": true,
+		"https://example.com/charts/myapp-0.1.0.tgz":      true,
+		"https://example.com/charts/myapp-0.1.0.tar.gz":   true,
+		"http://example.com/manifests/deploy.yaml?x=1":    true,
+		"https://example.com/manifests/deploy.yml":        true,
+		"https://example.com/charts/myapp":                false,
+		"oci://registry.example.com/charts/myapp":         false,
+		"stable/myapp":           false,
+		"testdata/kube_manifest": false,
+		"./chart.tgz":            false,
+	} {
+		require.Equal(t, want, isRemoteSource(src), src)
+	}
+}
+
+func TestParseGitSource(t *testing.T) {
+	for _, tc := range []struct {
+		src     string
+		want    *gitSource
+		wantErr string
+	}{
+		{
+			src:  "git::https://github.com/org/repo",
+			want: &gitSource{Repo: "https://github.com/org/repo"},
+		},
+		{
+			src:  "git::https://github.com/org/repo.git//deploy/overlays/prod?ref=v1.2.3",
+			want: &gitSource{Repo: "https://github.com/org/repo.git", Subdir: "deploy/overlays/prod", Ref: "v1.2.3"},
+		},
+		{
+			src:  "git::file:///srv/git/repo.git//manifests?ref=main&depth=1",
+			want: &gitSource{Repo: "file:///srv/git/repo.git?depth=1", Subdir: "manifests", Ref: "main"},
+		},
+		{
+			src:  "git::git@github.com:org/repo.git//deploy",
+			want: &gitSource{Repo: "git@github.com:org/repo.git", Subdir: "deploy"},
+		},
+		{
+			src:     "git::https://github.com/org/repo//../../etc",
+			wantErr: `the subdirectory "../../etc" must be a path within the repository`,
+		},
+		{
+			src:     "git::https://github.com/org/repo?ref=--upload-pack=evil",
+			wantErr: `invalid ref "--upload-pack=evil"`,
+		},
+		{
+			src:     "git::",
+			wantErr: "it must be in the form of git::URL[//SUBDIR][?ref=REF]",
+		},
+	} {
+		t.Run(tc.src, func(t *testing.T) {
+			got, err := parseGitSource(tc.src)
+			if tc.wantErr != "" {
+				require.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestChartify_RemoteSources(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	serverDir := t.TempDir()
+
+	archive := newTestTGZ(t,
+		tgzFile("remote/Chart.yaml", "apiVersion: v2\nname: remote\nversion: 0.1.0\n"),
+		tgzFile("remote/templates/cm.yaml", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: from-archive\n"),
+	)
+	require.NoError(t, os.WriteFile(filepath.Join(serverDir, "remote-0.1.0.tgz"), archive.Bytes(), 0644))
+
+	writeTestFiles(t, serverDir, map[string]string{
+		"manifests/cm.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: from-url\n",
+	})
+
+	server := httptest.NewServer(http.FileServer(http.Dir(serverDir)))
+	t.Cleanup(server.Close)
+
+	repoDir := t.TempDir()
+	writeTestFiles(t, repoDir, map[string]string{
+		"deploy/base/cm.yaml":               "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: from-git-v1\n",
+		"deploy/base/kustomization.yaml":    "resources:\n- cm.yaml\n",
+		"deploy/overlay/kustomization.yaml": "resources:\n- ../base\nnamePrefix: prod-\n",
+	})
+
+	git := func(args ...string) {
+		t.Helper()
+
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repoDir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com", "GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+	}
+
+	git("init", "--quiet")
+	git("add", "-A")
+	git("commit", "--quiet", "-m", "v1")
+	git("tag", "v1")
+	writeTestFiles(t, repoDir, map[string]string{
+		"deploy/base/cm.yaml": "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: from-git-v2\n",
+	})
+	git("commit", "--quiet", "-am", "v2")
+
+	bareRepo := filepath.Join(t.TempDir(), "repo.git")
+	git("clone", "--quiet", "--bare", repoDir, bareRepo)
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	for _, tc := range []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "chart archive",
+			src:  server.URL + "/remote-0.1.0.tgz",
+			want: "name: from-archive",
+		},
+		{
+			name: "manifest",
+			src:  server.URL + "/manifests/cm.yaml",
+			want: "name: from-url",
+		},
+		{
+			name: "git",
+			src:  "git::file://" + filepath.ToSlash(bareRepo) + "//deploy/overlay?ref=v1",
+			want: "name: prod-from-git-v1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpDir, err := r.Chartify("myapp", tc.src, WithChartifyOpts(&ChartifyOpts{
+				OverrideNamespace: "ns",
+			}))
+			t.Cleanup(func() {
+				_ = os.RemoveAll(tmpDir)
+			})
+			require.NoError(t, err)
+
+			out, err := exec.Command(helm, "template", "myapp", tmpDir).CombinedOutput()
+			require.NoError(t, err, string(out))
+			require.Contains(t, string(out), tc.want)
+			require.Contains(t, string(out), "namespace: ns")
+		})
+	}
+}
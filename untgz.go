@@ -3,24 +3,58 @@ package chartify
 import (
 	"archive/tar"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+
+	"github.com/otiai10/copy"
 )
 
-func ExtractFilesFromChartTGZ(tgzReader io.Reader, dir string) (string, error) {
-	if err := ExtractFilesFromTGZ(tgzReader, dir); err != nil {
-		return "", fmt.Errorf("unable to extract files to %s: %w", dir, err)
+const (
+	// DefaultMaxExtractedFileSize is the default limit on the size of each file extracted from an archive.
+	// It is the same as the limit Helm enforces on loading chart archives.
+	DefaultMaxExtractedFileSize int64 = 5 * 1024 * 1024
+
+	// DefaultMaxExtractedSize is the default limit on the total size of the files extracted from an archive.
+	// It is the same as the limit Helm enforces on loading chart archives.
+	DefaultMaxExtractedSize int64 = 100 * 1024 * 1024
+)
+
+type extractOpts struct {
+	maxFileSize  int64
+	maxTotalSize int64
+}
+
+// ExtractOption customizes how ExtractFilesFromTGZ extracts files.
+type ExtractOption func(*extractOpts)
+
+// WithMaxExtractedFileSize limits the size of each extracted file, so that a malicious archive
+// can't fill up the disk. Zero or a negative value disables the limit.
+func WithMaxExtractedFileSize(n int64) ExtractOption {
+	return func(o *extractOpts) {
+		o.maxFileSize = n
 	}
+}
 
-	d, err := os.Open(dir)
-	if err != nil {
-		return "", fmt.Errorf("unable to open %s as dir: %w", dir, err)
+// WithMaxExtractedSize limits the total size of the extracted files, so that a malicious archive
+// can't fill up the disk. Zero or a negative value disables the limit.
+func WithMaxExtractedSize(n int64) ExtractOption {
+	return func(o *extractOpts) {
+		o.maxTotalSize = n
+	}
+}
+
+func ExtractFilesFromChartTGZ(tgzReader io.Reader, dir string, opts ...ExtractOption) (string, error) {
+	if err := ExtractFilesFromTGZ(tgzReader, dir, opts...); err != nil {
+		return "", fmt.Errorf("unable to extract files to %s: %w", dir, err)
 	}
 
-	entries, err := d.ReadDir(0)
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		return "", fmt.Errorf("unable to readdir %s: %w", dir, err)
 	}
@@ -38,7 +72,22 @@ func ExtractFilesFromChartTGZ(tgzReader io.Reader, dir string) (string, error) {
 	return p, nil
 }
 
-func ExtractFilesFromTGZ(tgzReader io.Reader, dir string) error {
+// ExtractFilesFromTGZ extracts the files in the gzipped tarball into dir.
+//
+// Entries that would be extracted outside of dir, like `../foo` or `/etc/foo`, are rejected.
+// Symbolic links and hard links are never created on the filesystem. Instead, each link is replaced with
+// a copy of the file or the directory it points to, which must also be in the archive.
+// That way, nothing outside of dir can be read or written via links by chartify or helm.
+// Other special entries like devices and FIFOs are rejected.
+func ExtractFilesFromTGZ(tgzReader io.Reader, dir string, opts ...ExtractOption) error {
+	o := extractOpts{
+		maxFileSize:  DefaultMaxExtractedFileSize,
+		maxTotalSize: DefaultMaxExtractedSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	gzReader, err := gzip.NewReader(tgzReader)
 	if err != nil {
 		return fmt.Errorf("unable to open tgz archive: %w", err)
@@ -46,6 +95,10 @@ func ExtractFilesFromTGZ(tgzReader io.Reader, dir string) error {
 
 	tReader := tar.NewReader(gzReader)
 
+	x := &extractor{dir: dir, opts: o}
+
+	var links []archiveLink
+
 	for {
 		header, err := tReader.Next()
 
@@ -57,37 +110,263 @@ func ExtractFilesFromTGZ(tgzReader io.Reader, dir string) error {
 			return fmt.Errorf("unable to read the next entry in tar: %w", err)
 		}
 
-		name := header.Name
-		path := filepath.Join(dir, name)
+		name, err := archivePath(header.Name)
+		if err != nil {
+			return err
+		}
 
 		switch f := header.Typeflag; f {
 		case tar.TypeDir:
-			if err := os.Mkdir(path, 0755); err != nil {
-				return fmt.Errorf("unable to mkdir %q: %w", name, err)
+			if name == "" {
+				continue
+			}
+
+			if err := x.mkdirAll(name, os.FileMode(header.Mode).Perm()|0700); err != nil {
+				return err
 			}
 		case tar.TypeReg:
-			d := filepath.Dir(path)
-			if stat, _ := os.Stat(d); stat == nil || !stat.IsDir() {
-				if err := os.MkdirAll(d, 0755); err != nil {
-					return fmt.Errorf("unable to mkdir %q: %w", d, err)
-				}
+			if name == "" {
+				return fmt.Errorf("invalid file %q in the archive", header.Name)
+			}
+
+			if err := x.writeFile(name, tReader, header.Size, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if path.IsAbs(header.Linkname) || filepath.IsAbs(header.Linkname) {
+				return fmt.Errorf("symlink %q points to the absolute path %q", header.Name, header.Linkname)
 			}
-			outFile, err := os.Create(path)
+
+			target, err := archivePath(path.Join(path.Dir(name), header.Linkname))
 			if err != nil {
-				return fmt.Errorf("unable to create %q: %w", name, err)
+				return fmt.Errorf("symlink %q points outside of the archive: %w", header.Name, err)
 			}
-			defer func() {
-				_ = outFile.Close()
-			}()
-			if _, err := io.Copy(outFile, tReader); err != nil {
-				return fmt.Errorf("unable to write %q: %w", name, err)
+
+			links = append(links, archiveLink{name: name, target: target, header: header})
+		case tar.TypeLink:
+			target, err := archivePath(header.Linkname)
+			if err != nil {
+				return fmt.Errorf("hard link %q points outside of the archive: %w", header.Name, err)
 			}
+
+			links = append(links, archiveLink{name: name, target: target, header: header})
+		case tar.TypeXGlobalHeader:
+			// PAX global headers contain metadata only
 		default:
-			log.Fatalf(
-				"encoutered unknown tar header %v in %s",
-				f,
-				name)
+			return fmt.Errorf("unsupported entry %q of type %q in the archive", header.Name, f)
+		}
+	}
+
+	return x.copyLinks(links)
+}
+
+// archiveLink is a symlink or a hard link in an archive, whose target is the cleaned path to the linked entry
+// relative to the root of the archive.
+type archiveLink struct {
+	name   string
+	target string
+	header *tar.Header
+}
+
+type extractor struct {
+	dir  string
+	opts extractOpts
+
+	// total is the total size of the files extracted so far
+	total int64
+}
+
+// archivePath cleans the name of an entry in an archive into a slash-separated path relative to the root of the archive.
+// It returns an empty string for the root itself, and an error when the entry is outside of the root.
+func archivePath(name string) (string, error) {
+	p := path.Clean(strings.ReplaceAll(name, `\`, "/"))
+
+	if path.IsAbs(p) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("illegal absolute path %q in the archive", name)
+	}
+
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("illegal path %q in the archive: it points outside of the extraction directory", name)
+	}
+
+	if p == "." {
+		return "", nil
+	}
+
+	return p, nil
+}
+
+func (x *extractor) localPath(name string) string {
+	return filepath.Join(x.dir, filepath.FromSlash(name))
+}
+
+func (x *extractor) mkdirAll(name string, perm os.FileMode) error {
+	p := x.localPath(name)
+
+	if err := os.MkdirAll(p, perm); err != nil {
+		return fmt.Errorf("unable to mkdir %q: %w", name, err)
+	}
+
+	return nil
+}
+
+func (x *extractor) writeFile(name string, r io.Reader, size int64, perm os.FileMode) error {
+	if err := x.reserve(name, size); err != nil {
+		return err
+	}
+
+	if d := path.Dir(name); d != "." {
+		if err := x.mkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+
+	p := x.localPath(name)
+
+	// Overwrite a file that appears twice in the archive, like tar does
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to replace %q: %w", name, err)
+	}
+
+	outFile, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm|0600)
+	if err != nil {
+		return fmt.Errorf("unable to create %q: %w", name, err)
+	}
+
+	// The header can't be trusted, so never read more than it claims
+	n, err := io.Copy(outFile, io.LimitReader(r, size))
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write %q: %w", name, err)
+	}
+
+	if n != size {
+		return fmt.Errorf("unable to write %q: expected %d bytes but got %d", name, size, n)
+	}
+
+	return nil
+}
+
+// reserve accounts for the file of the size about to be extracted, failing when it exceeds the limits.
+func (x *extractor) reserve(name string, size int64) error {
+	if size < 0 {
+		return fmt.Errorf("invalid size %d of %q in the archive", size, name)
+	}
+
+	if x.opts.maxFileSize > 0 && size > x.opts.maxFileSize {
+		return fmt.Errorf("%q is too large: %d bytes exceeds the limit of %d bytes", name, size, x.opts.maxFileSize)
+	}
+
+	x.total += size
+
+	if x.opts.maxTotalSize > 0 && x.total > x.opts.maxTotalSize {
+		return fmt.Errorf("the archive is too large: the extracted files exceed the limit of %d bytes", x.opts.maxTotalSize)
+	}
+
+	return nil
+}
+
+// copyLinks replaces each link with a copy of its target.
+// As a link may point to another link, it repeats until every link is copied or no more links can be copied.
+func (x *extractor) copyLinks(links []archiveLink) error {
+	for _, l := range links {
+		if l.name == "" {
+			return fmt.Errorf("invalid link %q in the archive", l.header.Name)
+		}
+
+		if l.target == "" || l.target == l.name || strings.HasPrefix(l.name, l.target+"/") {
+			return fmt.Errorf("link %q points to its ancestor directory %q", l.header.Name, l.header.Linkname)
 		}
 	}
+
+	for len(links) > 0 {
+		var pending []archiveLink
+
+		for _, l := range links {
+			if isLinkPending(l, links) {
+				pending = append(pending, l)
+				continue
+			}
+
+			if err := x.copyLink(l); err != nil {
+				return err
+			}
+		}
+
+		if len(pending) == len(links) {
+			return fmt.Errorf("unable to resolve %q to %q: links in the archive form a cycle", pending[0].header.Name, pending[0].header.Linkname)
+		}
+
+		links = pending
+	}
+
+	return nil
+}
+
+// isLinkPending returns true when the target of the link is, contains, or is contained in another link not copied yet.
+func isLinkPending(l archiveLink, links []archiveLink) bool {
+	for _, other := range links {
+		if other.name == l.name {
+			continue
+		}
+
+		if other.name == l.target || strings.HasPrefix(other.name, l.target+"/") || strings.HasPrefix(l.target, other.name+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *extractor) copyLink(l archiveLink) error {
+	src := x.localPath(l.target)
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return fmt.Errorf("unable to resolve %q to %q: it must point to a file or a directory in the archive: %w", l.header.Name, l.header.Linkname, err)
+	}
+
+	if !info.IsDir() {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+
+		return x.writeFile(l.name, f, info.Size(), info.Mode().Perm())
+	}
+
+	if err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			rel, err := filepath.Rel(x.dir, p)
+			if err != nil {
+				return err
+			}
+
+			return x.reserve(filepath.ToSlash(rel), info.Size())
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if d := path.Dir(l.name); d != "." {
+		if err := x.mkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := copy.Copy(src, x.localPath(l.name)); err != nil {
+		return fmt.Errorf("unable to copy %q to %q: %w", l.header.Linkname, l.header.Name, err)
+	}
+
 	return nil
 }
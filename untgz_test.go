@@ -0,0 +1,185 @@
+package chartify
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTGZ(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, h := range headers {
+		content := h.Linkname
+		if h.Typeflag == tar.TypeReg {
+			// Use Linkname to carry the content of the file in tests
+			h.Size = int64(len(content))
+			h.Linkname = ""
+		}
+		if h.Mode == 0 && h.Typeflag != tar.TypeXGlobalHeader {
+			h.Mode = 0644
+		}
+
+		require.NoError(t, tw.WriteHeader(h))
+
+		if h.Typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(content))
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	return &buf
+}
+
+func tgzFile(name, content string) *tar.Header {
+	return &tar.Header{Typeflag: tar.TypeReg, Name: name, Linkname: content}
+}
+
+func TestExtractFilesFromTGZ(t *testing.T) {
+	t.Run("files and directories", func(t *testing.T) {
+		dir := t.TempDir()
+
+		require.NoError(t, ExtractFilesFromTGZ(newTestTGZ(t,
+			&tar.Header{Typeflag: tar.TypeDir, Name: "./"},
+			&tar.Header{Typeflag: tar.TypeDir, Name: "mychart/", Mode: 0755},
+			tgzFile("mychart/Chart.yaml", "name: mychart\n"),
+			&tar.Header{Typeflag: tar.TypeReg, Name: "mychart/files/run.sh", Linkname: "#!/bin/sh\n", Mode: 0755},
+			&tar.Header{Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "test"}},
+			tgzFile("mychart/Chart.yaml", "name: overwritten\n"),
+		), dir))
+
+		content, err := os.ReadFile(filepath.Join(dir, "mychart", "Chart.yaml"))
+		require.NoError(t, err)
+		require.Equal(t, "name: overwritten\n", string(content))
+
+		info, err := os.Stat(filepath.Join(dir, "mychart", "files", "run.sh"))
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	})
+
+	t.Run("links are replaced with copies", func(t *testing.T) {
+		dir := t.TempDir()
+
+		require.NoError(t, ExtractFilesFromTGZ(newTestTGZ(t,
+			&tar.Header{Typeflag: tar.TypeSymlink, Name: "mychart/values-prod.yaml", Linkname: "values-default.yaml"},
+			&tar.Header{Typeflag: tar.TypeSymlink, Name: "mychart/values-default.yaml", Linkname: "./values.yaml"},
+			tgzFile("mychart/values.yaml", "foo: bar\n"),
+			&tar.Header{Typeflag: tar.TypeLink, Name: "mychart/values-copy.yaml", Linkname: "mychart/values.yaml"},
+			tgzFile("mychart/shared/cm.yaml", "kind: ConfigMap\n"),
+			&tar.Header{Typeflag: tar.TypeSymlink, Name: "mychart/templates/shared", Linkname: "../shared"},
+		), dir))
+
+		for _, f := range []string{"values-prod.yaml", "values-default.yaml", "values-copy.yaml"} {
+			p := filepath.Join(dir, "mychart", f)
+
+			info, err := os.Lstat(p)
+			require.NoError(t, err)
+			require.True(t, info.Mode().IsRegular(), "%s must not be a link", f)
+
+			content, err := os.ReadFile(p)
+			require.NoError(t, err)
+			require.Equal(t, "foo: bar\n", string(content))
+		}
+
+		info, err := os.Lstat(filepath.Join(dir, "mychart", "templates", "shared"))
+		require.NoError(t, err)
+		require.True(t, info.IsDir())
+		require.FileExists(t, filepath.Join(dir, "mychart", "templates", "shared", "cm.yaml"))
+	})
+
+	for _, tc := range []struct {
+		name    string
+		headers []*tar.Header
+		opts    []ExtractOption
+		wantErr string
+	}{
+		{
+			name:    "path traversal",
+			headers: []*tar.Header{tgzFile("mychart/../../evil.yaml", "evil")},
+			wantErr: `illegal path "mychart/../../evil.yaml" in the archive`,
+		},
+		{
+			name:    "absolute path",
+			headers: []*tar.Header{tgzFile("/tmp/evil.yaml", "evil")},
+			wantErr: `illegal absolute path "/tmp/evil.yaml" in the archive`,
+		},
+		{
+			name:    "symlink outside of the archive",
+			headers: []*tar.Header{{Typeflag: tar.TypeSymlink, Name: "mychart/passwd", Linkname: "../../etc/passwd"}},
+			wantErr: `symlink "mychart/passwd" points outside of the archive`,
+		},
+		{
+			name:    "absolute symlink",
+			headers: []*tar.Header{{Typeflag: tar.TypeSymlink, Name: "mychart/passwd", Linkname: "/etc/passwd"}},
+			wantErr: `symlink "mychart/passwd" points to the absolute path "/etc/passwd"`,
+		},
+		{
+			name:    "hard link outside of the archive",
+			headers: []*tar.Header{{Typeflag: tar.TypeLink, Name: "mychart/passwd", Linkname: "../etc/passwd"}},
+			wantErr: `hard link "mychart/passwd" points outside of the archive`,
+		},
+		{
+			name:    "dangling symlink",
+			headers: []*tar.Header{{Typeflag: tar.TypeSymlink, Name: "mychart/values.yaml", Linkname: "missing.yaml"}},
+			wantErr: `unable to resolve "mychart/values.yaml" to "missing.yaml"`,
+		},
+		{
+			name: "symlink cycle",
+			headers: []*tar.Header{
+				{Typeflag: tar.TypeSymlink, Name: "mychart/a", Linkname: "b"},
+				{Typeflag: tar.TypeSymlink, Name: "mychart/b", Linkname: "a"},
+			},
+			wantErr: "links in the archive form a cycle",
+		},
+		{
+			name:    "symlink to the ancestor",
+			headers: []*tar.Header{{Typeflag: tar.TypeSymlink, Name: "mychart/templates/loop", Linkname: ".."}},
+			wantErr: `link "mychart/templates/loop" points to its ancestor directory ".."`,
+		},
+		{
+			name:    "fifo",
+			headers: []*tar.Header{{Typeflag: tar.TypeFifo, Name: "mychart/fifo"}},
+			wantErr: `unsupported entry "mychart/fifo"`,
+		},
+		{
+			name:    "too large file",
+			headers: []*tar.Header{tgzFile("mychart/big.yaml", "0123456789")},
+			opts:    []ExtractOption{WithMaxExtractedFileSize(9)},
+			wantErr: `"mychart/big.yaml" is too large: 10 bytes exceeds the limit of 9 bytes`,
+		},
+		{
+			name: "too large archive",
+			headers: []*tar.Header{
+				tgzFile("mychart/a.yaml", "01234"),
+				tgzFile("mychart/b.yaml", "01234"),
+				{Typeflag: tar.TypeLink, Name: "mychart/c.yaml", Linkname: "mychart/a.yaml"},
+			},
+			opts:    []ExtractOption{WithMaxExtractedSize(12)},
+			wantErr: "the extracted files exceed the limit of 12 bytes",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "a", "b")
+			require.NoError(t, os.MkdirAll(dir, 0755))
+
+			err := ExtractFilesFromTGZ(newTestTGZ(t, tc.headers...), dir, tc.opts...)
+			require.ErrorContains(t, err, tc.wantErr)
+
+			require.NoFileExists(t, filepath.Join(dir, "..", "..", "evil.yaml"))
+			require.NoFileExists(t, filepath.Join(dir, "..", "passwd"))
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package chartify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"helm.sh/helm/v4/pkg/chart/common/util"
+	"helm.sh/helm/v4/pkg/chart/v2/loader"
+	"helm.sh/helm/v4/pkg/cli"
+	"helm.sh/helm/v4/pkg/cli/values"
+	"helm.sh/helm/v4/pkg/getter"
+)
+
+// setFlag is a flag of helm that sets values, like `--set k=v`.
+type setFlag struct {
+	flag  string
+	value string
+}
+
+// parseSetFlags parses ChartifyOpts.SetFlags, which is either like ["--set", "k=v"] or ["--set k=v"].
+// Flags other than --set, --set-string, --set-file, and so on are returned as well.
+func parseSetFlags(flags []string) []setFlag {
+	var parsed []setFlag
+
+	for i := 0; i < len(flags); i++ {
+		flag, value, ok := strings.Cut(strings.TrimSpace(flags[i]), " ")
+		if !ok {
+			flag, value, ok = strings.Cut(flag, "=")
+		}
+
+		if !strings.HasPrefix(flag, "-") {
+			continue
+		}
+
+		if !ok && i+1 < len(flags) && !strings.HasPrefix(flags[i+1], "-") {
+			i++
+			value = flags[i]
+		}
+
+		parsed = append(parsed, setFlag{flag: flag, value: strings.TrimSpace(value)})
+	}
+
+	return parsed
+}
+
+// setValuesFlags converts the deprecated ChartifyOpts.SetValues to the equivalent --set flags.
+func setValuesFlags(setValues []string) []setFlag {
+	var flags []setFlag
+	for _, v := range setValues {
+		flags = append(flags, setFlag{flag: "--set", value: v})
+	}
+	return flags
+}
+
+// valuesSource is a single source of the values given to helm, like a values file or a --set flag.
+type valuesSource struct {
+	name   string
+	values map[string]interface{}
+}
+
+// validateValues validates the values chartify renders the chart at chartPath with against the chart's
+// values.schema.json, so that invalid values are reported along with the values file or the flag that set them,
+// before spending time on rendering and patching the chart.
+// It does nothing when the chart has no schema.
+func (r *Runner) validateValues(chartPath string, u *ChartifyOpts) error {
+	schemaPath := filepath.Join(chartPath, ValuesSchemaFileName)
+
+	if exists, err := r.Exists(schemaPath); err != nil {
+		return err
+	} else if !exists {
+		return nil
+	}
+
+	if strings.Contains(u.TemplateArgs, "--skip-schema-validation") {
+		return nil
+	}
+
+	schemaJSON, err := r.ReadFile(schemaPath)
+	if err != nil {
+		return err
+	}
+
+	schema, err := compileValuesSchema(schemaPath, schemaJSON)
+	if err != nil {
+		// e.g. the schema refers to a remote schema. Leave it to helm-template, which is able to load it.
+		r.Logf("Skipping validation of values against %s: %v", schemaPath, err)
+		return nil
+	}
+
+	sources, merged, err := r.readValuesSources(chartPath, u)
+	if err != nil {
+		return err
+	}
+
+	err = schema.Validate(merged)
+	if err == nil {
+		return nil
+	}
+
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return fmt.Errorf("validating values against %s: %w", schemaPath, err)
+	}
+
+	p := message.NewPrinter(language.English)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "values don't meet the specifications of the schema in %s:", schemaPath)
+
+	for _, e := range leafValidationErrors(validationErr) {
+		buf.WriteString("\n- ")
+
+		if source := lastValuesSourceOf(sources, e.InstanceLocation); source != "" {
+			buf.WriteString(source + ": ")
+		}
+
+		fmt.Fprintf(&buf, "at %s: %s", valuesPath(e.InstanceLocation), e.ErrorKind.LocalizedString(p))
+	}
+
+	return errors.New(buf.String())
+}
+
+func compileValuesSchema(schemaPath string, schemaJSON []byte) (*jsonschema.Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schemaJSON))
+	if err != nil {
+		return nil, err
+	}
+
+	absPath, err := filepath.Abs(schemaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Relative $refs are resolved against the schema file
+	url := "file://" + filepath.ToSlash(absPath)
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(url, doc); err != nil {
+		return nil, err
+	}
+
+	return compiler.Compile(url)
+}
+
+// readValuesSources reads every source of the values in the order helm applies them,
+// and returns them along with the values merged the same way as helm does.
+func (r *Runner) readValuesSources(chartPath string, u *ChartifyOpts) ([]valuesSource, map[string]interface{}, error) {
+	var sources []valuesSource
+
+	defaults := map[string]interface{}{}
+
+	defaultValuesPath := filepath.Join(chartPath, "values.yaml")
+	if exists, err := r.Exists(defaultValuesPath); err != nil {
+		return nil, nil, err
+	} else if exists {
+		content, err := r.ReadFile(defaultValuesPath)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		defaults, err = loader.LoadValues(bytes.NewReader(content))
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing %s: %w", defaultValuesPath, err)
+		}
+
+		sources = append(sources, valuesSource{name: "values.yaml", values: defaults})
+	}
+
+	for _, f := range u.ValuesFiles {
+		content, err := r.readValuesFile(f)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		vals, err := loader.LoadValues(bytes.NewReader(content))
+		if err != nil {
+			return nil, nil, fmt.Errorf("parsing %s: %w", f, err)
+		}
+
+		sources = append(sources, valuesSource{name: f, values: vals})
+	}
+
+	// Like helm, values set by flags take precedence over values files regardless of the order,
+	// in the order of --set-json, --set, --set-string, --set-file, and --set-literal.
+	setSources := make([][]valuesSource, len(setFlagOrder))
+
+	flags := append(setValuesFlags(u.SetValues), parseSetFlags(u.SetFlags)...)
+
+	for _, f := range flags {
+		order, ok := setFlagOrder[f.flag]
+		if !ok {
+			continue
+		}
+
+		vals, err := mergeGivenValues(nil, []setFlag{f})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		setSources[order] = append(setSources[order], valuesSource{name: f.flag + " " + f.value, values: vals})
+	}
+
+	for _, s := range setSources {
+		sources = append(sources, s...)
+	}
+
+	vals, err := mergeGivenValues(u.ValuesFiles, flags)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sources, util.CoalesceTables(vals, defaults), nil
+}
+
+// stdinValuesFile is the values file that stands for the standard input, like `helm template -f -`.
+const stdinValuesFile = "-"
+
+// saveStdinValues saves the standard input to a temporary file when it is one of the values files,
+// and returns the values files with "-" replaced by the path to the saved file, along with a function to remove the file.
+// Chartify reads the values files many times, e.g. to validate the values and to render the chart,
+// whereas the standard input can be read only once.
+func saveStdinValues(valuesFiles []string) ([]string, func(), error) {
+	if !slices.Contains(valuesFiles, stdinValuesFile) {
+		return valuesFiles, func() {}, nil
+	}
+
+	content, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading values from the standard input: %w", err)
+	}
+
+	f, err := os.CreateTemp("", "chartify-stdin-values-*.yaml")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	remove := func() {
+		_ = os.Remove(f.Name())
+	}
+
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		remove()
+		return nil, nil, fmt.Errorf("saving values from the standard input to %s: %w", f.Name(), err)
+	}
+
+	saved := make([]string, len(valuesFiles))
+	for i, v := range valuesFiles {
+		saved[i] = v
+		if v == stdinValuesFile {
+			saved[i] = f.Name()
+		}
+	}
+
+	return saved, remove, nil
+}
+
+// setFlagOrder is the order in which helm applies the values set by each kind of set flag.
+var setFlagOrder = map[string]int{
+	"--set-json":    0,
+	"--set":         1,
+	"--set-string":  2,
+	"--set-file":    3,
+	"--set-literal": 4,
+}
+
+// mergeGivenValues merges the values given via the values files and the set flags the same way as helm does.
+// Flags other than set flags are ignored.
+func mergeGivenValues(valuesFiles []string, flags []setFlag) (map[string]interface{}, error) {
+	opts := values.Options{ValueFiles: valuesFiles}
+
+	for _, f := range flags {
+		switch f.flag {
+		case "--set-json":
+			opts.JSONValues = append(opts.JSONValues, f.value)
+		case "--set":
+			opts.Values = append(opts.Values, f.value)
+		case "--set-string":
+			opts.StringValues = append(opts.StringValues, f.value)
+		case "--set-file":
+			opts.FileValues = append(opts.FileValues, f.value)
+		case "--set-literal":
+			opts.LiteralValues = append(opts.LiteralValues, f.value)
+		}
+	}
+
+	return opts.MergeValues(getter.All(cli.New()))
+}
+
+// readValuesFile reads the values file the same way as helm does, fetching it with helm's getters
+// when it is a URL like `https://example.com/values.yaml`.
+func (r *Runner) readValuesFile(f string) ([]byte, error) {
+	u, err := url.Parse(f)
+	if err != nil || u.Scheme == "" || strings.ToLower(u.Scheme) == "file" {
+		return r.ReadFile(f)
+	}
+
+	g, err := getter.All(cli.New()).ByScheme(u.Scheme)
+	if err != nil {
+		// e.g. `C:\values.yaml` on Windows, whose drive letter is parsed as the scheme
+		return r.ReadFile(f)
+	}
+
+	r.Logf("Downloading values file %s", f)
+
+	buf, err := g.Get(f, getter.WithURL(f))
+	if err != nil {
+		return nil, fmt.Errorf("downloading values file %s: %w", f, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// leafValidationErrors returns the errors that caused the validation error, which tell what is wrong where.
+func leafValidationErrors(e *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(e.Causes) == 0 {
+		return []*jsonschema.ValidationError{e}
+	}
+
+	var leaves []*jsonschema.ValidationError
+	for _, c := range e.Causes {
+		leaves = append(leaves, leafValidationErrors(c)...)
+	}
+	return leaves
+}
+
+// lastValuesSourceOf returns the name of the source that took effect for the value at the path.
+// It returns an empty string if no source sets the value, like when a required value is missing.
+func lastValuesSourceOf(sources []valuesSource, path []string) string {
+	for i := len(sources) - 1; i >= 0; i-- {
+		if hasValue(sources[i].values, path) {
+			return sources[i].name
+		}
+	}
+	return ""
+}
+
+func hasValue(vals interface{}, path []string) bool {
+	for _, k := range path {
+		switch v := vals.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if vals, ok = v[k]; !ok {
+				return false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(k)
+			if err != nil || i < 0 || i >= len(v) {
+				return false
+			}
+			vals = v[i]
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// valuesPath returns the path to the value like `db.replicas` or `hosts[0]`, or `(root)` for the whole values.
+func valuesPath(path []string) string {
+	if len(path) == 0 {
+		return "(root)"
+	}
+
+	var b strings.Builder
+	for _, k := range path {
+		if _, err := strconv.Atoi(k); err == nil {
+			fmt.Fprintf(&b, "[%s]", k)
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(".")
+		}
+		b.WriteString(k)
+	}
+	return b.String()
+}
@@ -0,0 +1,236 @@
+package chartify
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner_validateValues(t *testing.T) {
+	const schema = `{
+  "type": "object",
+  "required": ["name"],
+  "properties": {
+    "name": {"type": "string"},
+    "db": {
+      "type": "object",
+      "properties": {
+        "replicas": {"type": "integer", "minimum": 1},
+        "hosts": {"type": "array", "items": {"type": "string"}}
+      }
+    }
+  }
+}`
+
+	r := New(WithLogf(t.Logf))
+
+	newChart := func(t *testing.T, files map[string]string) string {
+		t.Helper()
+
+		chartDir := t.TempDir()
+		writeTestFiles(t, chartDir, files)
+		return chartDir
+	}
+
+	t.Run("values file", func(t *testing.T) {
+		chartDir := newChart(t, map[string]string{
+			"values.yaml":        "name: foo\ndb:\n  replicas: 1\n",
+			ValuesSchemaFileName: schema,
+		})
+
+		valuesFile := filepath.Join(t.TempDir(), "prod.yaml")
+		require.NoError(t, os.WriteFile(valuesFile, []byte("db:\n  replicas: 0\n  hosts: [a, 1]\n"), 0644))
+
+		err := r.validateValues(chartDir, &ChartifyOpts{ValuesFiles: []string{valuesFile}})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), filepath.Join(chartDir, ValuesSchemaFileName))
+		require.Contains(t, err.Error(), "- "+valuesFile+": at db.replicas: minimum: got 0, want 1")
+		require.Contains(t, err.Error(), "- "+valuesFile+": at db.hosts[1]: got number, want string")
+	})
+
+	t.Run("set flags take precedence", func(t *testing.T) {
+		chartDir := newChart(t, map[string]string{
+			"values.yaml":        "name: foo\n",
+			ValuesSchemaFileName: schema,
+		})
+
+		valuesFile := filepath.Join(t.TempDir(), "prod.yaml")
+		require.NoError(t, os.WriteFile(valuesFile, []byte("db:\n  replicas: 0\n"), 0644))
+
+		err := r.validateValues(chartDir, &ChartifyOpts{
+			ValuesFiles: []string{valuesFile},
+			SetFlags:    []string{"--set", "db.replicas=-1"},
+		})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "- --set db.replicas=-1: at db.replicas: minimum: got -1, want 1")
+		require.NotContains(t, err.Error(), valuesFile)
+
+		require.NoError(t, r.validateValues(chartDir, &ChartifyOpts{
+			ValuesFiles: []string{valuesFile},
+			SetFlags:    []string{"--set db.replicas=2"},
+		}))
+	})
+
+	t.Run("missing required value", func(t *testing.T) {
+		chartDir := newChart(t, map[string]string{
+			ValuesSchemaFileName: schema,
+		})
+
+		err := r.validateValues(chartDir, &ChartifyOpts{})
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "- at (root): missing property 'name'")
+	})
+
+	t.Run("skip schema validation", func(t *testing.T) {
+		chartDir := newChart(t, map[string]string{
+			ValuesSchemaFileName: schema,
+		})
+
+		require.NoError(t, r.validateValues(chartDir, &ChartifyOpts{TemplateArgs: "--skip-schema-validation"}))
+	})
+
+	t.Run("no schema", func(t *testing.T) {
+		chartDir := newChart(t, map[string]string{
+			"values.yaml": "name: 1\n",
+		})
+
+		require.NoError(t, r.validateValues(chartDir, &ChartifyOpts{}))
+	})
+}
+
+func TestParseSetFlags(t *testing.T) {
+	require.Equal(t, []setFlag{
+		{flag: "--set", value: "a=1,b=2"},
+		{flag: "--set-string", value: "c=x"},
+		{flag: "--set-json", value: `d={"e":1}`},
+		{flag: "--namespace", value: "ns"},
+		{flag: "--debug"},
+	}, parseSetFlags([]string{
+		"--set", "a=1,b=2",
+		"--set-string c=x",
+		`--set-json=d={"e":1}`,
+		"--namespace", "ns",
+		"--debug",
+	}))
+}
+
+func TestChartify_ValidateValues(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	chartDir := filepath.Join(t.TempDir(), "validated")
+	writeTestFiles(t, chartDir, map[string]string{
+		"Chart.yaml":         "apiVersion: v2\nname: validated\nversion: 0.1.0\n",
+		"values.yaml":        "replicas: 1\n",
+		ValuesSchemaFileName: `{"type": "object", "properties": {"replicas": {"type": "integer"}}}`,
+		"templates/cm.yaml":  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\ndata:\n  replicas: {{ .Values.replicas | quote }}\n",
+	})
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	_, err := r.Chartify("myapp", chartDir, WithChartifyOpts(&ChartifyOpts{
+		OverrideNamespace: "ns",
+		SetFlags:          []string{"--set-string", "replicas=3"},
+	}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "--set-string replicas=3: at replicas: got string, want integer")
+
+	tmpDir, err := r.Chartify("myapp", chartDir, WithChartifyOpts(&ChartifyOpts{
+		OverrideNamespace: "ns",
+		SetFlags:          []string{"--set", "replicas=3"},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+}
+
+func TestChartify_ValidateValues_StdinValues(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	chartDir := filepath.Join(t.TempDir(), "validated")
+	writeTestFiles(t, chartDir, map[string]string{
+		"Chart.yaml":         "apiVersion: v2\nname: validated\nversion: 0.1.0\n",
+		"values.yaml":        "replicas: 1\n",
+		ValuesSchemaFileName: `{"type": "object", "properties": {"replicas": {"type": "integer"}}}`,
+		"templates/cm.yaml":  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\ndata:\n  replicas: {{ .Values.replicas | quote }}\n",
+	})
+
+	setStdin(t, "replicas: 5\n")
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	// The values from the standard input are validated first, and then still rendered by helm
+	tmpDir, err := r.Chartify("myapp", chartDir, WithChartifyOpts(&ChartifyOpts{
+		ValuesFiles:  []string{"-"},
+		FieldSetters: []FieldSetter{{Target: PatchTarget{Kind: "ConfigMap"}, Path: "metadata.labels.patched", Value: "true"}},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	resources, err := os.ReadFile(filepath.Join(tmpDir, "files", "templates", "patched_resources.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(resources), `replicas: "5"`)
+}
+
+func TestChartify_ValidateValues_RemoteValuesFile(t *testing.T) {
+	if h := os.Getenv("HELM_BIN"); h != "" {
+		helm = h
+	}
+
+	setupHelmConfig(t)
+
+	chartDir := filepath.Join(t.TempDir(), "validated")
+	writeTestFiles(t, chartDir, map[string]string{
+		"Chart.yaml":         "apiVersion: v2\nname: validated\nversion: 0.1.0\n",
+		"values.yaml":        "replicas: 1\n",
+		ValuesSchemaFileName: `{"type": "object", "properties": {"replicas": {"type": "integer"}}}`,
+		"templates/cm.yaml":  "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: {{ .Release.Name }}\ndata:\n  replicas: {{ .Values.replicas | quote }}\n",
+	})
+
+	serverDir := t.TempDir()
+	writeTestFiles(t, serverDir, map[string]string{
+		"valid.yaml":   "replicas: 7\n",
+		"invalid.yaml": "replicas: seven\n",
+	})
+
+	server := httptest.NewServer(http.FileServer(http.Dir(serverDir)))
+	t.Cleanup(server.Close)
+
+	r := New(HelmBin(helm), WithLogf(t.Logf))
+
+	setter := FieldSetter{Target: PatchTarget{Kind: "ConfigMap"}, Path: "metadata.labels.patched", Value: "true"}
+
+	_, err := r.Chartify("myapp", chartDir, WithChartifyOpts(&ChartifyOpts{
+		ValuesFiles:  []string{server.URL + "/invalid.yaml"},
+		FieldSetters: []FieldSetter{setter},
+	}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), server.URL+"/invalid.yaml: at replicas: got string, want integer")
+
+	tmpDir, err := r.Chartify("myapp", chartDir, WithChartifyOpts(&ChartifyOpts{
+		ValuesFiles:  []string{server.URL + "/valid.yaml"},
+		FieldSetters: []FieldSetter{setter},
+	}))
+	t.Cleanup(func() {
+		_ = os.RemoveAll(tmpDir)
+	})
+	require.NoError(t, err)
+
+	resources, err := os.ReadFile(filepath.Join(tmpDir, "files", "templates", "patched_resources.yaml"))
+	require.NoError(t, err)
+	require.Contains(t, string(resources), `replicas: "7"`)
+}